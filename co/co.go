@@ -7,6 +7,21 @@ import (
 	"sync"
 )
 
+// Options are passed to the functions in this package that allow the caller
+// to control how work is distributed across goroutines. The `Limit` is the
+// maximum number of goroutines that will be used at any one time; if it is less
+// than 1, a function dependent default is used. The `Grain` is the number of
+// iterations that a goroutine will take at once; if it is less than 1, one
+// iteration is taken at a time. If `FailFast` is true, the first error
+// will cancel the context that is given to all iterations, iterations that
+// have not started will not be started, and only the first error is returned.
+// Otherwise, all iterations are run and all errors are returned together.
+type Options struct {
+	Limit    int
+	Grain    int
+	FailFast bool
+}
+
 // ParBegin multiple functions onto background goroutines. This function blocks
 // until all goroutines have terminated.
 func ParBegin(fs ...func()) {
@@ -28,56 +43,33 @@ func ParBegin(fs ...func()) {
 // For maps, the iterand function must accept a key as the only argument. For
 // ints, the iterand function must accept an int, in the range [0, n), as the
 // only argument. This function blocks until all goroutines have terminated.
+//
+// ParForAll uses reflection to call the iterand function. Where possible, the
+// type safe (and faster) `ParForAllSlice`, `ParForAllMap`, and
+// `ParForAllRange` functions should be used instead.
 func ParForAll(iter interface{}, f interface{}) {
 	funTy := reflect.TypeOf(f)
-	if funTy.Kind() != reflect.Func {
-		panic(fmt.Sprintf("parforall error: expected iterator got %T", iter))
+	if funTy == nil || funTy.Kind() != reflect.Func {
+		panic(fmt.Sprintf("parforall error: expected func got %T", f))
 	}
 	fun := reflect.ValueOf(f)
 
 	switch reflect.TypeOf(iter).Kind() {
 
 	case reflect.Array, reflect.Slice:
-		it := reflect.ValueOf(iter)
-		numGoroutines := it.Len()
-
-		var wg sync.WaitGroup
-		wg.Add(numGoroutines)
-		for i := 0; i < numGoroutines; i++ {
-			go func(i int) {
-				defer wg.Done()
-				fun.Call([]reflect.Value{reflect.ValueOf(i)})
-			}(i)
-		}
-		wg.Wait()
+		ParForAllRange(reflect.ValueOf(iter).Len(), func(i int) {
+			fun.Call([]reflect.Value{reflect.ValueOf(i)})
+		})
 
 	case reflect.Map:
-		it := reflect.ValueOf(iter)
-		keys := it.MapKeys()
-		numGoroutines := len(keys)
-
-		var wg sync.WaitGroup
-		wg.Add(numGoroutines)
-		for i := 0; i < numGoroutines; i++ {
-			go func(i int) {
-				defer wg.Done()
-				fun.Call([]reflect.Value{keys[i]})
-			}(i)
-		}
-		wg.Wait()
+		ParForAllSlice(reflect.ValueOf(iter).MapKeys(), func(_ int, key reflect.Value) {
+			fun.Call([]reflect.Value{key})
+		})
 
 	case reflect.Int:
-		numGoroutines := int(reflect.ValueOf(iter).Int())
-
-		var wg sync.WaitGroup
-		wg.Add(numGoroutines)
-		for i := 0; i < numGoroutines; i++ {
-			go func(i int) {
-				defer wg.Done()
-				fun.Call([]reflect.Value{reflect.ValueOf(i)})
-			}(i)
-		}
-		wg.Wait()
+		ParForAllRange(int(reflect.ValueOf(iter).Int()), func(i int) {
+			fun.Call([]reflect.Value{reflect.ValueOf(i)})
+		})
 
 	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		panic(fmt.Sprintf("parforall error: expected int got %T", iter))
@@ -88,76 +80,92 @@ func ParForAll(iter interface{}, f interface{}) {
 
 }
 
+// ParForAllSlice executes an iterand function on each index, and element, of a
+// slice using a background goroutine for each iteration. This function blocks
+// until all goroutines have terminated.
+func ParForAllSlice[T any](xs []T, f func(int, T)) {
+	ParForAllRange(len(xs), func(i int) {
+		f(i, xs[i])
+	})
+}
+
+// ParForAllMap executes an iterand function on each key, and value, of a map
+// using a background goroutine for each iteration. This function blocks until
+// all goroutines have terminated.
+func ParForAllMap[K comparable, V any](xs map[K]V, f func(K, V)) {
+	var wg sync.WaitGroup
+	wg.Add(len(xs))
+	for k, v := range xs {
+		go func(k K, v V) {
+			defer wg.Done()
+			f(k, v)
+		}(k, v)
+	}
+	wg.Wait()
+}
+
+// ParForAllRange executes an iterand function on each int in the range [0, n)
+// using a background goroutine for each iteration. This function blocks until
+// all goroutines have terminated. If n is not positive, the range is empty.
+func ParForAllRange(n int, f func(int)) {
+	if n <= 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
 // ForAll uses an iterator to execute an iterand function on each value
 // returned by the iterator, using a background goroutine for CPU and
-// distributing the iterands evenly across each goroutine. An iterator can be
+// balancing the iterands across each goroutine (see `ForAllRange`). An
+// iterator can be
 // an array, a slice, a map, or an int. For arrays, and slices, the iterand
 // function must accept an int index as the only argument. For maps, the
 // iterand function must accept a key as the only argument. For ints, the
 // iterand function must accept an int, in the range [0, n), as the only
 // argument. This function blocks until all goroutines have terminated.
+//
+// ForAll uses reflection to call the iterand function. Where possible, the
+// type safe (and faster) `ForAllSlice`, `ForAllMap`, and `ForAllRange`
+// functions should be used instead.
 func ForAll(iter interface{}, f interface{}) {
+	ForAllWith(Options{}, iter, f)
+}
+
+// ForAllWith is the same as `ForAll`, except that the goroutines used, and the
+// schedule of the iterations, are controlled by the options (see
+// `ForAllRangeWith`).
+func ForAllWith(opts Options, iter interface{}, f interface{}) {
 	funTy := reflect.TypeOf(f)
-	if funTy.Kind() != reflect.Func {
-		panic(fmt.Sprintf("forall error: expected iterator got %T", iter))
+	if funTy == nil || funTy.Kind() != reflect.Func {
+		panic(fmt.Sprintf("forall error: expected func got %T", f))
 	}
 	fun := reflect.ValueOf(f)
 
 	switch reflect.TypeOf(iter).Kind() {
 
 	case reflect.Array, reflect.Slice:
-		it := reflect.ValueOf(iter)
-		num := it.Len()
-		numGoroutines := runtime.NumCPU()
-		numPerGoroutine := num/numGoroutines + 1
-
-		var wg sync.WaitGroup
-		for i := 0; i < num; i += numPerGoroutine {
-			wg.Add(1)
-			go func(i int) {
-				defer wg.Done()
-				for j := i; j < i+numPerGoroutine && j < num; j++ {
-					fun.Call([]reflect.Value{reflect.ValueOf(j)})
-				}
-			}(i)
-		}
-		wg.Wait()
+		ForAllRangeWith(opts, reflect.ValueOf(iter).Len(), func(i int) {
+			fun.Call([]reflect.Value{reflect.ValueOf(i)})
+		})
 
 	case reflect.Map:
-		it := reflect.ValueOf(iter)
-		keys := it.MapKeys()
-		num := len(keys)
-		numGoroutines := runtime.NumCPU()
-		numPerGoroutine := num/numGoroutines + 1
-
-		var wg sync.WaitGroup
-		for i := 0; i < num; i += numPerGoroutine {
-			wg.Add(1)
-			go func(i int) {
-				defer wg.Done()
-				for j := i; j < i+numPerGoroutine && j < num; j++ {
-					fun.Call([]reflect.Value{keys[j]})
-				}
-			}(i)
-		}
-		wg.Wait()
+		keys := reflect.ValueOf(iter).MapKeys()
+		ForAllRangeWith(opts, len(keys), func(i int) {
+			fun.Call([]reflect.Value{keys[i]})
+		})
 
 	case reflect.Int:
-		num := int(reflect.ValueOf(iter).Int())
-		numGoroutines := runtime.NumCPU()
-		numPerGoroutine := num/numGoroutines + 1
-
-		var wg sync.WaitGroup
-		for i := 0; i < num; i += numPerGoroutine {
-			wg.Add(1)
-			go func(i int) {
-				defer wg.Done()
-				for j := i; j < i+numPerGoroutine && j < num; j++ {
-					fun.Call([]reflect.Value{reflect.ValueOf(j)})
-				}
-			}(i)
-		}
-		wg.Wait()
+		ForAllRangeWith(opts, int(reflect.ValueOf(iter).Int()), func(i int) {
+			fun.Call([]reflect.Value{reflect.ValueOf(i)})
+		})
 
 	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		panic(fmt.Sprintf("forall error: expected int got %T", iter))
@@ -167,3 +175,144 @@ func ForAll(iter interface{}, f interface{}) {
 	}
 
 }
+
+// ForAllSlice executes an iterand function on each index, and element, of a
+// slice using a background goroutine per CPU and balancing the iterands
+// across each goroutine (see `ForAllRange`). This function blocks until all
+// goroutines have terminated.
+func ForAllSlice[T any](xs []T, f func(int, T)) {
+	ForAllRange(len(xs), func(i int) {
+		f(i, xs[i])
+	})
+}
+
+// ForAllMap executes an iterand function on each key, and value, of a map
+// using a background goroutine per CPU and balancing the iterands across each
+// goroutine (see `ForAllRange`). This function blocks until all goroutines
+// have terminated.
+func ForAllMap[K comparable, V any](xs map[K]V, f func(K, V)) {
+	keys := keysOf(xs)
+	ForAllRange(len(keys), func(i int) {
+		f(keys[i], xs[keys[i]])
+	})
+}
+
+// ForAllRange executes an iterand function on each int in the range [0, n)
+// using a background goroutine per CPU. The iterations are initially split
+// evenly across the goroutines, but a goroutine that runs out of iterations
+// will steal half of the remaining iterations from the goroutine with the most
+// remaining iterations, so that iterations with uneven costs do not leave
+// goroutines idle. This function blocks until all goroutines have terminated.
+func ForAllRange(n int, f func(int)) {
+	ForAllRangeWith(Options{}, n, f)
+}
+
+// ForAllRangeWith is the same as `ForAllRange`, except that at most
+// `opts.Limit` goroutines are used (by default, one goroutine per CPU), and
+// goroutines take `opts.Grain` iterations at a time (by default, one
+// iteration). Larger grains reduce scheduling overhead for cheap iterations.
+func ForAllRangeWith(opts Options, n int, f func(int)) {
+	if n <= 0 {
+		return
+	}
+	workers := opts.Limit
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	grain := opts.Grain
+	if grain < 1 {
+		grain = 1
+	}
+	if max := (n + grain - 1) / grain; workers > max {
+		workers = max
+	}
+
+	spans := make([]span, workers)
+	for w := range spans {
+		spans[w].lo = w * n / workers
+		spans[w].hi = (w + 1) * n / workers
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(own *span) {
+			defer wg.Done()
+			for {
+				lo, hi := own.take(grain)
+				if lo == hi {
+					if !own.steal(spans) {
+						return
+					}
+					continue
+				}
+				for i := lo; i < hi; i++ {
+					f(i)
+				}
+			}
+		}(&spans[w])
+	}
+	wg.Wait()
+}
+
+// span is a range of iterations, [lo, hi), that have not been started by the
+// goroutine that owns it.
+type span struct {
+	mu     sync.Mutex
+	lo, hi int
+}
+
+// take at most `grain` iterations from the front of the span.
+func (s *span) take(grain int) (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lo, hi := s.lo, s.lo+grain
+	if hi > s.hi {
+		hi = s.hi
+	}
+	s.lo = hi
+	return lo, hi
+}
+
+// steal half of the remaining iterations from the span with the most remaining
+// iterations, and make them the remaining iterations of this span (which is
+// assumed to be empty). It returns false when there are no iterations left to
+// steal.
+func (s *span) steal(spans []span) bool {
+	for {
+		var victim *span
+		remaining := 0
+		for i := range spans {
+			other := &spans[i]
+			if other == s {
+				continue
+			}
+			other.mu.Lock()
+			if r := other.hi - other.lo; r > remaining {
+				victim, remaining = other, r
+			}
+			other.mu.Unlock()
+		}
+		if victim == nil {
+			return false
+		}
+
+		victim.mu.Lock()
+		r := victim.hi - victim.lo
+		if r <= 0 {
+			// The victim finished its iterations before they could be stolen,
+			// so look for another victim.
+			victim.mu.Unlock()
+			continue
+		}
+		lo, hi := victim.lo+r/2, victim.hi
+		victim.hi = lo
+		victim.mu.Unlock()
+
+		s.mu.Lock()
+		s.lo, s.hi = lo, hi
+		s.mu.Unlock()
+		return true
+	}
+}
@@ -2,12 +2,16 @@ package co_test
 
 import (
 	"fmt"
+	"runtime"
 	"sync/atomic"
+	"testing"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/renproject/phi"
+
+	"github.com/renproject/phi/co"
 )
 
 var _ = Describe("Concurrency", func() {
@@ -170,4 +174,215 @@ var _ = Describe("Concurrency", func() {
 
 	})
 
+	Context("when using type safe loops", func() {
+
+		for _, par := range []bool{false, true} {
+			par := par
+
+			forAllSlice := co.ForAllSlice[int]
+			forAllMap := co.ForAllMap[string, int]
+			forAllRange := co.ForAllRange
+			if par {
+				forAllSlice = co.ParForAllSlice[int]
+				forAllMap = co.ParForAllMap[string, int]
+				forAllRange = co.ParForAllRange
+			}
+
+			Context(fmt.Sprintf("when the loop is explicitly parallel=%v", par), func() {
+
+				It("should iterate over slices", func() {
+					num := int64(0)
+					xs := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+					forAllSlice(xs, func(i int, x int) {
+						Expect(x).Should(Equal(i + 1))
+						atomic.AddInt64(&num, int64(x))
+					})
+					Expect(num).Should(Equal(int64(55)))
+				})
+
+				It("should iterate over maps", func() {
+					num := int64(0)
+					xs := map[string]int{"1": 1, "2": 2, "3": 3}
+					forAllMap(xs, func(key string, x int) {
+						Expect(key).Should(Equal(fmt.Sprint(x)))
+						atomic.AddInt64(&num, int64(x))
+					})
+					Expect(num).Should(Equal(int64(6)))
+				})
+
+				It("should iterate over ranges", func() {
+					num := int64(0)
+					forAllRange(10, func(i int) {
+						Expect(i).Should(BeNumerically(">=", 0))
+						Expect(i).Should(BeNumerically("<", 10))
+						atomic.AddInt64(&num, int64(i+1))
+					})
+					Expect(num).Should(Equal(int64(55)))
+
+					for _, n := range []int{0, -1} {
+						forAllRange(n, func(int) {
+							panic("iterated")
+						})
+					}
+				})
+			})
+		}
+	})
+
+	Context("when using scheduled loops", func() {
+
+		for _, opts := range []co.Options{{}, {Limit: 1}, {Limit: 3, Grain: 7}, {Grain: 1000}} {
+			opts := opts
+
+			It(fmt.Sprintf("should run each iteration exactly once with options %+v", opts), func() {
+				counts := make([]int64, 1000)
+				co.ForAllRangeWith(opts, len(counts), func(i int) {
+					atomic.AddInt64(&counts[i], 1)
+				})
+				for _, count := range counts {
+					Expect(count).Should(Equal(int64(1)))
+				}
+			})
+		}
+
+		It("should do nothing when the range is empty", func() {
+			for _, n := range []int{0, -1} {
+				co.ForAllRangeWith(co.Options{}, n, func(int) {
+					panic("iterated")
+				})
+				ForAll(n, func(int) {
+					panic("iterated")
+				})
+			}
+		})
+
+		It("should not exceed the limit", func() {
+			running, max := int64(0), int64(0)
+			ForAllWith(co.Options{Limit: 2}, 100, func(int) {
+				n := atomic.AddInt64(&running, 1)
+				defer atomic.AddInt64(&running, -1)
+				for m := atomic.LoadInt64(&max); n > m; m = atomic.LoadInt64(&max) {
+					atomic.CompareAndSwapInt64(&max, m, n)
+				}
+				time.Sleep(100 * time.Microsecond)
+			})
+			Expect(max).Should(BeNumerically("<=", 2))
+		})
+	})
+
+	Context("when the iterand is not a function", func() {
+
+		recovered := func(f func()) (r interface{}) {
+			defer func() { r = recover() }()
+			f()
+			return nil
+		}
+
+		It("should panic with the type of the iterand", func() {
+			Expect(recovered(func() { ForAll(10, 10) })).Should(Equal("forall error: expected func got int"))
+			Expect(recovered(func() { ParForAll(10, "f") })).Should(Equal("parforall error: expected func got string"))
+		})
+	})
+
 })
+
+func BenchmarkForAll(b *testing.B) {
+	xs := make([]int64, 10000)
+	for i := 0; i < b.N; i++ {
+		ForAll(xs, func(i int) {
+			xs[i]++
+		})
+	}
+}
+
+func BenchmarkForAllSlice(b *testing.B) {
+	xs := make([]int64, 10000)
+	for i := 0; i < b.N; i++ {
+		co.ForAllSlice(xs, func(i int, _ int64) {
+			xs[i]++
+		})
+	}
+}
+
+func BenchmarkForAllRange(b *testing.B) {
+	xs := make([]int64, 10000)
+	for i := 0; i < b.N; i++ {
+		co.ForAllRange(len(xs), func(i int) {
+			xs[i]++
+		})
+	}
+}
+
+func BenchmarkParForAll(b *testing.B) {
+	xs := make([]int64, 10*runtime.NumCPU())
+	for i := 0; i < b.N; i++ {
+		ParForAll(xs, func(i int) {
+			xs[i]++
+		})
+	}
+}
+
+func BenchmarkParForAllRange(b *testing.B) {
+	xs := make([]int64, 10*runtime.NumCPU())
+	for i := 0; i < b.N; i++ {
+		co.ParForAllRange(len(xs), func(i int) {
+			xs[i]++
+		})
+	}
+}
+
+// spin is a workload that spins for a number of iterations.
+func spin(cost int) {
+	x := 0
+	for j := 0; j < 1000*cost; j++ {
+		x += j
+	}
+	atomic.AddInt64(&sink, int64(x))
+}
+
+// nap is a workload that sleeps for a number of microseconds. It shows the
+// effect of scheduling independently of the number of CPUs available.
+func nap(cost int) {
+	time.Sleep(time.Duration(cost) * time.Microsecond)
+}
+
+var sink int64
+
+// benchmarkForAllSkewed runs a workload where the first eighth of the
+// iterations are a hundred times more expensive than the rest.
+func benchmarkForAllSkewed(b *testing.B, workers int, work func(cost int)) {
+	n := 256
+	skewed := func(i int) {
+		if i < n/8 {
+			work(100)
+		} else {
+			work(1)
+		}
+	}
+
+	b.Run("static", func(b *testing.B) {
+		// Split the iterations evenly, without balancing, as `ForAll` did
+		// before it was work-stealing.
+		numPerGoroutine := n/workers + 1
+		for i := 0; i < b.N; i++ {
+			co.ParForAllRange(workers, func(w int) {
+				for j := w * numPerGoroutine; j < (w+1)*numPerGoroutine && j < n; j++ {
+					skewed(j)
+				}
+			})
+		}
+	})
+	b.Run("stealing", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			co.ForAllRangeWith(co.Options{Limit: workers}, n, skewed)
+		}
+	})
+}
+
+func BenchmarkForAllSkewedSpin(b *testing.B) {
+	benchmarkForAllSkewed(b, runtime.NumCPU(), spin)
+}
+
+func BenchmarkForAllSkewedSleep(b *testing.B) {
+	benchmarkForAllSkewed(b, 8, nap)
+}
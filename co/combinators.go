@@ -0,0 +1,209 @@
+package co
+
+import "runtime"
+
+// Map applies a function to each element of a slice, in parallel, and returns
+// the results in the same order as the elements.
+func Map[T, U any](xs []T, f func(T) U) []U {
+	return MapRange(len(xs), func(i int) U {
+		return f(xs[i])
+	})
+}
+
+// MapRange applies a function to each int in the range [0, n), in parallel,
+// and returns the results in order. If n is not positive, the range is empty.
+func MapRange[U any](n int, f func(int) U) []U {
+	if n <= 0 {
+		return []U{}
+	}
+	ys := make([]U, n)
+	ForAllRange(n, func(i int) {
+		ys[i] = f(i)
+	})
+	return ys
+}
+
+// MapMap applies a function to each key, and value, of a map, in parallel,
+// and returns a map from each key to its result.
+func MapMap[K comparable, V, U any](xs map[K]V, f func(K, V) U) map[K]U {
+	keys := keysOf(xs)
+	ys := MapRange(len(keys), func(i int) U {
+		return f(keys[i], xs[keys[i]])
+	})
+	zs := make(map[K]U, len(keys))
+	for i, k := range keys {
+		zs[k] = ys[i]
+	}
+	return zs
+}
+
+// Filter applies a predicate to each element of a slice, in parallel, and
+// returns the elements that satisfy the predicate in their original order.
+func Filter[T any](xs []T, f func(T) bool) []T {
+	keep := MapRange(len(xs), func(i int) bool {
+		return f(xs[i])
+	})
+	ys := make([]T, 0, len(xs))
+	for i, x := range xs {
+		if keep[i] {
+			ys = append(ys, x)
+		}
+	}
+	return ys
+}
+
+// FilterRange applies a predicate to each int in the range [0, n), in
+// parallel, and returns the ints that satisfy the predicate in order. If n is
+// not positive, the range is empty.
+func FilterRange(n int, f func(int) bool) []int {
+	if n <= 0 {
+		return []int{}
+	}
+	keep := MapRange(n, f)
+	ys := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if keep[i] {
+			ys = append(ys, i)
+		}
+	}
+	return ys
+}
+
+// FilterMap applies a predicate to each key, and value, of a map, in parallel,
+// and returns a map of the entries that satisfy the predicate.
+func FilterMap[K comparable, V any](xs map[K]V, f func(K, V) bool) map[K]V {
+	keep := MapMap(xs, f)
+	ys := make(map[K]V, len(xs))
+	for k, v := range xs {
+		if keep[k] {
+			ys[k] = v
+		}
+	}
+	return ys
+}
+
+// Reduce combines the elements of a slice, in parallel, using an associative
+// function. The slice is split into contiguous chunks that are reduced in
+// parallel, and then adjacent results are combined in a tree, so the function
+// does not need to be commutative. The identity must be an identity of the
+// function, and is returned for empty slices.
+func Reduce[T any](xs []T, identity T, f func(T, T) T) T {
+	return ReduceRange(len(xs), identity, func(i int) T {
+		return xs[i]
+	}, f)
+}
+
+// ReduceRange maps each int in the range [0, n) to a value, and combines these
+// values, in parallel, using an associative function (see `Reduce`). If n is
+// not positive, the range is empty and the identity is returned.
+func ReduceRange[T any](n int, identity T, g func(int) T, f func(T, T) T) T {
+	if n <= 0 {
+		return identity
+	}
+	bounds := chunk(n)
+	partials := MapRange(len(bounds)-1, func(c int) T {
+		return fold(bounds[c], bounds[c+1], identity, g, f)
+	})
+
+	// Combine adjacent partial results until only one remains.
+	for len(partials) > 1 {
+		partials = MapRange((len(partials)+1)/2, func(i int) T {
+			if 2*i+1 == len(partials) {
+				return partials[2*i]
+			}
+			return f(partials[2*i], partials[2*i+1])
+		})
+	}
+	if len(partials) == 0 {
+		return identity
+	}
+	return partials[0]
+}
+
+// ReduceMap combines the values of a map, in parallel, using an associative
+// and commutative function (maps have no order). The identity must be an
+// identity of the function, and is returned for empty maps.
+func ReduceMap[K comparable, V any](xs map[K]V, identity V, f func(V, V) V) V {
+	keys := keysOf(xs)
+	return ReduceRange(len(keys), identity, func(i int) V {
+		return xs[keys[i]]
+	}, f)
+}
+
+// Scan computes the inclusive prefix combination of the elements of a slice,
+// in parallel, using an associative function. The i-th result is the
+// combination of the first i+1 elements. The identity must be an identity of
+// the function.
+func Scan[T any](xs []T, identity T, f func(T, T) T) []T {
+	return ScanRange(len(xs), identity, func(i int) T {
+		return xs[i]
+	}, f)
+}
+
+// ScanRange maps each int in the range [0, n) to a value, and computes the
+// inclusive prefix combination of these values, in parallel, using an
+// associative function (see `Scan`). If n is not positive, the range is empty.
+func ScanRange[T any](n int, identity T, g func(int) T, f func(T, T) T) []T {
+	if n <= 0 {
+		return []T{}
+	}
+	bounds := chunk(n)
+
+	// Find the combination of each chunk, and from these find the value that
+	// precedes each chunk.
+	totals := MapRange(len(bounds)-1, func(c int) T {
+		return fold(bounds[c], bounds[c+1], identity, g, f)
+	})
+	offsets := make([]T, len(totals))
+	acc := identity
+	for c, total := range totals {
+		offsets[c] = acc
+		acc = f(acc, total)
+	}
+
+	ys := make([]T, n)
+	ForAllRange(len(offsets), func(c int) {
+		acc := offsets[c]
+		for i := bounds[c]; i < bounds[c+1]; i++ {
+			acc = f(acc, g(i))
+			ys[i] = acc
+		}
+	})
+	return ys
+}
+
+// fold the values of the ints in the range [lo, hi) sequentially.
+func fold[T any](lo, hi int, identity T, g func(int) T, f func(T, T) T) T {
+	acc := identity
+	for i := lo; i < hi; i++ {
+		acc = f(acc, g(i))
+	}
+	return acc
+}
+
+// chunk splits the range [0, n) into contiguous chunks, a few per CPU, and
+// returns the boundaries of the chunks. The c-th chunk is the range
+// [bounds[c], bounds[c+1]).
+func chunk(n int) []int {
+	chunks := 4 * runtime.NumCPU()
+	if chunks > n {
+		chunks = n
+	}
+	if chunks == 0 {
+		return []int{0}
+	}
+	bounds := make([]int, chunks+1)
+	for c := range bounds {
+		bounds[c] = c * n / chunks
+	}
+	return bounds
+}
+
+// keysOf returns the keys of a map in an unspecified order.
+func keysOf[K comparable, V any](xs map[K]V) []K {
+	keys := make([]K, 0, len(xs))
+	for k := range xs {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,114 @@
+package co_test
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/co"
+)
+
+var _ = Describe("Combinators", func() {
+
+	add := func(x, y int) int { return x + y }
+	concat := func(x, y string) string { return x + y }
+
+	Context("when mapping", func() {
+
+		It("should map slices in order", func() {
+			xs := []int{1, 2, 3, 4, 5}
+			Expect(Map(xs, func(x int) string { return fmt.Sprint(x * x) })).To(Equal([]string{"1", "4", "9", "16", "25"}))
+		})
+
+		It("should map ranges in order", func() {
+			Expect(MapRange(5, func(i int) int { return 2 * i })).To(Equal([]int{0, 2, 4, 6, 8}))
+		})
+
+		It("should map maps by key", func() {
+			xs := map[string]int{"a": 1, "b": 2}
+			Expect(MapMap(xs, func(k string, v int) string { return strings.Repeat(k, v) })).To(Equal(map[string]string{"a": "a", "b": "bb"}))
+		})
+
+		It("should map empty iterators", func() {
+			Expect(Map([]int{}, func(x int) int { return x })).To(BeEmpty())
+			Expect(MapRange(0, func(i int) int { return i })).To(BeEmpty())
+			Expect(MapRange(-1, func(i int) int { return i })).To(BeEmpty())
+		})
+	})
+
+	Context("when filtering", func() {
+
+		It("should filter slices in order", func() {
+			xs := []int{5, 4, 3, 2, 1}
+			Expect(Filter(xs, func(x int) bool { return x%2 == 1 })).To(Equal([]int{5, 3, 1}))
+		})
+
+		It("should filter ranges in order", func() {
+			Expect(FilterRange(10, func(i int) bool { return i%3 == 0 })).To(Equal([]int{0, 3, 6, 9}))
+		})
+
+		It("should filter empty ranges", func() {
+			Expect(FilterRange(0, func(int) bool { return true })).To(BeEmpty())
+			Expect(FilterRange(-1, func(int) bool { return true })).To(BeEmpty())
+		})
+
+		It("should filter maps", func() {
+			xs := map[string]int{"a": 1, "b": 2, "c": 3}
+			Expect(FilterMap(xs, func(k string, v int) bool { return k == "a" || v == 3 })).To(Equal(map[string]int{"a": 1, "c": 3}))
+		})
+	})
+
+	Context("when reducing", func() {
+
+		It("should reduce slices", func() {
+			xs := MapRange(1000, func(i int) int { return i })
+			Expect(Reduce(xs, 0, add)).To(Equal(499500))
+		})
+
+		It("should preserve the order of non-commutative functions", func() {
+			xs := MapRange(100, func(i int) string { return fmt.Sprint(i % 10) })
+			Expect(Reduce(xs, "", concat)).To(Equal(strings.Repeat("0123456789", 10)))
+		})
+
+		It("should reduce ranges", func() {
+			Expect(ReduceRange(10, 0, func(i int) int { return i * i }, add)).To(Equal(285))
+		})
+
+		It("should reduce maps", func() {
+			xs := map[string]int{"a": 1, "b": 2, "c": 3}
+			Expect(ReduceMap(xs, 0, add)).To(Equal(6))
+		})
+
+		It("should return the identity for empty iterators", func() {
+			Expect(Reduce([]int{}, 42, add)).To(Equal(42))
+			Expect(ReduceMap(map[string]int{}, 42, add)).To(Equal(42))
+			Expect(ReduceRange(-1, 42, func(i int) int { return i }, add)).To(Equal(42))
+		})
+	})
+
+	Context("when scanning", func() {
+
+		It("should scan slices", func() {
+			xs := MapRange(1000, func(i int) int { return i })
+			ys := Scan(xs, 0, add)
+			for i, y := range ys {
+				Expect(y).To(Equal(i * (i + 1) / 2))
+			}
+		})
+
+		It("should preserve the order of non-commutative functions", func() {
+			xs := []string{"a", "b", "c", "d"}
+			Expect(Scan(xs, "", concat)).To(Equal([]string{"a", "ab", "abc", "abcd"}))
+		})
+
+		It("should scan ranges", func() {
+			Expect(ScanRange(5, 1, func(i int) int { return i + 1 }, func(x, y int) int { return x * y })).To(Equal([]int{1, 2, 6, 24, 120}))
+		})
+
+		It("should scan empty iterators", func() {
+			Expect(Scan([]int{}, 0, add)).To(BeEmpty())
+			Expect(ScanRange(-1, 0, func(i int) int { return i }, add)).To(BeEmpty())
+		})
+	})
+})
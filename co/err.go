@@ -0,0 +1,205 @@
+package co
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Errors is a collection of errors that occurred in different iterations. The
+// errors are in the order of the iterations in which they occurred.
+type Errors []error
+
+// Error implements the `error` interface.
+func (errs Errors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%v errors: %v", len(errs), strings.Join(msgs, "; "))
+}
+
+// PanicError is returned in place of an error when an iteration panics. It
+// holds the value that was recovered, and the stack trace of the goroutine at
+// the time of the panic.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+// Error implements the `error` interface.
+func (err PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", err.Value, err.Stack)
+}
+
+// ParBeginErr runs multiple functions on background goroutines, using at most
+// `opts.Limit` goroutines (by default, one goroutine per function). Each
+// function is given a context that is cancelled when the given context is
+// cancelled, or when a function fails and `opts.FailFast` is true. Panics are
+// recovered and returned as a `PanicError`. This function blocks until all
+// goroutines have terminated.
+func ParBeginErr(ctx context.Context, opts Options, fs ...func(context.Context) error) error {
+	limit := opts.Limit
+	if limit < 1 {
+		limit = len(fs)
+	}
+	return run(ctx, opts.FailFast, len(fs), limit, opts.Grain, func(ctx context.Context, i int) error {
+		return fs[i](ctx)
+	})
+}
+
+// ForAllErr uses an iterator to execute an iterand function on each value
+// returned by the iterator, using at most `opts.Limit` goroutines (by default,
+// one goroutine per CPU). An iterator can be an array, a slice, a map, or an
+// int, as with `ForAll`. The iterand function must accept a context as its
+// first argument, the value returned by the iterator as its second argument,
+// and must return an error. The context is cancelled when the given context is
+// cancelled, or when an iteration fails and `opts.FailFast` is true. Panics
+// are recovered and returned as a `PanicError`. This function blocks until all
+// goroutines have terminated.
+func ForAllErr(ctx context.Context, opts Options, iter interface{}, f interface{}) error {
+	funTy := reflect.TypeOf(f)
+	if funTy == nil || funTy.Kind() != reflect.Func {
+		panic(fmt.Sprintf("forallerr error: expected func got %T", f))
+	}
+	if funTy.NumIn() != 2 || funTy.In(0) != contextType || funTy.NumOut() != 1 || funTy.Out(0) != errorType {
+		panic(fmt.Sprintf("forallerr error: expected func(context.Context, T) error got %T", f))
+	}
+	fun := reflect.ValueOf(f)
+
+	var num int
+	var arg func(i int) reflect.Value
+
+	switch reflect.TypeOf(iter).Kind() {
+
+	case reflect.Array, reflect.Slice:
+		num = reflect.ValueOf(iter).Len()
+		arg = func(i int) reflect.Value { return reflect.ValueOf(i) }
+
+	case reflect.Map:
+		keys := reflect.ValueOf(iter).MapKeys()
+		num = len(keys)
+		arg = func(i int) reflect.Value { return keys[i] }
+
+	case reflect.Int:
+		num = int(reflect.ValueOf(iter).Int())
+		arg = func(i int) reflect.Value { return reflect.ValueOf(i) }
+
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		panic(fmt.Sprintf("forallerr error: expected int got %T", iter))
+
+	default:
+		panic(fmt.Sprintf("forallerr error: expected iterator got %T", iter))
+	}
+
+	limit := opts.Limit
+	if limit < 1 {
+		limit = runtime.NumCPU()
+	}
+	return run(ctx, opts.FailFast, num, limit, opts.Grain, func(ctx context.Context, i int) error {
+		err, _ := fun.Call([]reflect.Value{reflect.ValueOf(ctx), arg(i)})[0].Interface().(error)
+		return err
+	})
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// run executes `f` for every integer in the range [0, n) using at most `limit`
+// goroutines. Each goroutine takes the next `grain` unstarted iterations until
+// there are none left, or until the context is done. A range that is empty (n
+// is not positive) has no iterations, and never fails.
+func run(ctx context.Context, failFast bool, n, limit, grain int, f func(context.Context, int) error) error {
+	if n <= 0 {
+		return nil
+	}
+	innerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if grain < 1 {
+		grain = 1
+	}
+	if max := (n + grain - 1) / grain; limit > max {
+		limit = max
+	}
+
+	errs := make([]error, n)
+	var firstErr error
+	var firstErrOnce sync.Once
+
+	next, started := int64(0), int64(0)
+	var wg sync.WaitGroup
+	wg.Add(limit)
+	for w := 0; w < limit; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				start := int(atomic.AddInt64(&next, int64(grain))) - grain
+				for i := start; i < start+grain && i < n; i++ {
+					if innerCtx.Err() != nil {
+						return
+					}
+					atomic.AddInt64(&started, 1)
+					if err := try(innerCtx, i, f); err != nil {
+						errs[i] = err
+						if failFast {
+							firstErrOnce.Do(func() { firstErr = err })
+							cancel()
+						}
+					}
+				}
+				if start+grain >= n {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failFast && firstErr != nil {
+		return firstErr
+	}
+	collected := Errors{}
+	for _, err := range errs {
+		if err != nil {
+			collected = append(collected, err)
+		}
+	}
+	if len(collected) > 0 {
+		return collected
+	}
+	if int(started) < n {
+		// Iterations were skipped because the context was cancelled.
+		return ctx.Err()
+	}
+	return nil
+}
+
+// try executes one iteration, recovering from a panic and returning it as a
+// `PanicError`.
+func try(ctx context.Context, i int, f func(context.Context, int) error) error {
+	return protect(func() error {
+		return f(ctx, i)
+	})
+}
+
+// protect executes a function, recovering from a panic and returning it as a
+// `PanicError`.
+func protect(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return f()
+}
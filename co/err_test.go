@@ -0,0 +1,156 @@
+package co_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/co"
+)
+
+var _ = Describe("Error propagation", func() {
+
+	Context("when using parbeginerr", func() {
+
+		It("should return nil when no function fails", func() {
+			n := int64(0)
+			err := ParBeginErr(context.Background(), Options{}, func(context.Context) error {
+				atomic.AddInt64(&n, 1)
+				return nil
+			}, func(context.Context) error {
+				atomic.AddInt64(&n, 1)
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(int64(2)))
+		})
+
+		It("should collect all errors in order", func() {
+			errA, errB := errors.New("a"), errors.New("b")
+			err := ParBeginErr(context.Background(), Options{}, func(context.Context) error {
+				time.Sleep(10 * time.Millisecond)
+				return errA
+			}, func(context.Context) error {
+				return nil
+			}, func(context.Context) error {
+				return errB
+			})
+			Expect(err).To(Equal(Errors{errA, errB}))
+		})
+
+		It("should recover panics with a stack trace", func() {
+			err := ParBeginErr(context.Background(), Options{}, func(context.Context) error {
+				panic("oops")
+			})
+			Expect(err).To(HaveLen(1))
+			panicErr, ok := err.(Errors)[0].(PanicError)
+			Expect(ok).To(BeTrue())
+			Expect(panicErr.Value).To(Equal("oops"))
+			Expect(string(panicErr.Stack)).To(ContainSubstring("err_test.go"))
+		})
+
+		It("should cancel other functions on the first error when failing fast", func() {
+			errA := errors.New("a")
+			err := ParBeginErr(context.Background(), Options{FailFast: true}, func(context.Context) error {
+				return errA
+			}, func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})
+			Expect(err).To(Equal(errA))
+		})
+
+		It("should not exceed the limit", func() {
+			running, max := int64(0), int64(0)
+			fs := make([]func(context.Context) error, 20)
+			for i := range fs {
+				fs[i] = func(context.Context) error {
+					n := atomic.AddInt64(&running, 1)
+					defer atomic.AddInt64(&running, -1)
+					for m := atomic.LoadInt64(&max); n > m; m = atomic.LoadInt64(&max) {
+						atomic.CompareAndSwapInt64(&max, m, n)
+					}
+					time.Sleep(time.Millisecond)
+					return nil
+				}
+			}
+			Expect(ParBeginErr(context.Background(), Options{Limit: 3}, fs...)).To(Succeed())
+			Expect(max).To(BeNumerically("<=", 3))
+		})
+	})
+
+	Context("when using forallerr loops", func() {
+
+		It("should iterate over slices", func() {
+			n := int64(0)
+			xs := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+			err := ForAllErr(context.Background(), Options{}, xs, func(_ context.Context, i int) error {
+				atomic.AddInt64(&n, int64(xs[i]))
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(int64(55)))
+		})
+
+		It("should iterate over maps", func() {
+			n := int64(0)
+			xs := map[string]int{"1": 1, "2": 2, "3": 3}
+			err := ForAllErr(context.Background(), Options{Limit: 2}, xs, func(_ context.Context, key string) error {
+				atomic.AddInt64(&n, int64(xs[key]))
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(int64(6)))
+		})
+
+		It("should collect the errors of all iterations", func() {
+			err := ForAllErr(context.Background(), Options{}, 10, func(_ context.Context, i int) error {
+				if i%5 == 0 {
+					return errors.New("multiple of five")
+				}
+				return nil
+			})
+			Expect(err).To(HaveLen(2))
+		})
+
+		It("should not start more iterations after the first error when failing fast", func() {
+			n := int64(0)
+			err := ForAllErr(context.Background(), Options{Limit: 1, FailFast: true}, 10, func(_ context.Context, i int) error {
+				atomic.AddInt64(&n, 1)
+				if i == 2 {
+					return errors.New("two")
+				}
+				return nil
+			})
+			Expect(err).To(MatchError("two"))
+			Expect(n).To(Equal(int64(3)))
+		})
+
+		It("should do nothing when the range is empty", func() {
+			for _, n := range []int{0, -1} {
+				err := ForAllErr(context.Background(), Options{}, n, func(context.Context, int) error {
+					return errors.New("iterated")
+				})
+				Expect(err).ToNot(HaveOccurred())
+			}
+		})
+
+		It("should return the context error when iterations are skipped", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err := ForAllErr(ctx, Options{}, 10, func(context.Context, int) error {
+				return nil
+			})
+			Expect(err).To(Equal(context.Canceled))
+		})
+
+		It("should panic when the iterand has the wrong signature", func() {
+			Expect(func() {
+				ForAllErr(context.Background(), Options{}, 10, func(int) {})
+			}).To(Panic())
+		})
+	})
+})
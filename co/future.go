@@ -0,0 +1,223 @@
+package co
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is the error of a future returned by `Timeout` when the original
+// future does not complete in time.
+var ErrTimeout = errors.New("timeout")
+
+// ErrNoFutures is the error of a future returned by `Any`, or `Race`, when it
+// is not given any futures.
+var ErrNoFutures = errors.New("no futures")
+
+// A Future is the result of an asynchronous computation. It completes exactly
+// once, with either a value or an error.
+type Future[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// Async runs a function on a background goroutine and returns a future that
+// completes with its result. Panics are recovered and the future completes
+// with a `PanicError`.
+func Async[T any](f func() (T, error)) *Future[T] {
+	future := newFuture[T]()
+	go func() {
+		var value T
+		err := protect(func() (err error) {
+			value, err = f()
+			return err
+		})
+		future.complete(value, err)
+	}()
+	return future
+}
+
+// Resolve returns a future that has already completed with the given value
+// and error.
+func Resolve[T any](value T, err error) *Future[T] {
+	future := newFuture[T]()
+	future.complete(value, err)
+	return future
+}
+
+// A Promise completes a future from outside of it, for example from a callback,
+// or from the handler of a task. Its future completes with the result of the
+// first call to `Complete`; later calls have no effect. A promise is safe for
+// concurrent use.
+type Promise[T any] struct {
+	future *Future[T]
+	once   *sync.Once
+}
+
+// NewPromise returns a promise with a future that has not completed.
+func NewPromise[T any]() *Promise[T] {
+	return &Promise[T]{future: newFuture[T](), once: new(sync.Once)}
+}
+
+// Future returns the future that is completed by the promise.
+func (promise *Promise[T]) Future() *Future[T] {
+	return promise.future
+}
+
+// Complete the future of the promise with a value and an error. It returns
+// false if the future has already been completed, in which case the value and
+// error are discarded.
+func (promise *Promise[T]) Complete(value T, err error) bool {
+	completed := false
+	promise.once.Do(func() {
+		promise.future.complete(value, err)
+		completed = true
+	})
+	return completed
+}
+
+// Await blocks until the future completes and returns its result, or until
+// the context is done and returns the error of the context.
+func (future *Future[T]) Await(ctx context.Context) (T, error) {
+	select {
+	case <-future.done:
+		return future.value, future.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Done returns a channel that is closed when the future completes.
+func (future *Future[T]) Done() <-chan struct{} {
+	return future.done
+}
+
+// OnComplete calls the given function, on a background goroutine, with the
+// result of the future once it completes.
+func (future *Future[T]) OnComplete(f func(T, error)) {
+	go func() {
+		<-future.done
+		f(future.value, future.err)
+	}()
+}
+
+// Then returns a future that completes with the result of applying a function
+// to the value of the given future. If the given future completes with an
+// error, the function is not called and the returned future completes with the
+// same error.
+func Then[T, U any](future *Future[T], f func(T) (U, error)) *Future[U] {
+	return Async(func() (U, error) {
+		value, err := future.Await(context.Background())
+		if err != nil {
+			var zero U
+			return zero, err
+		}
+		return f(value)
+	})
+}
+
+// All returns a future that completes with the values of all of the given
+// futures, in order, once they have all completed. If any of the futures
+// completes with an error, the returned future completes with that error
+// without waiting for the others.
+func All[T any](futures ...*Future[T]) *Future[[]T] {
+	all := newFuture[[]T]()
+	go func() {
+		completions := make(chan int, len(futures))
+		for i, future := range futures {
+			i, future := i, future
+			future.OnComplete(func(T, error) { completions <- i })
+		}
+		values := make([]T, len(futures))
+		for remaining := len(futures); remaining > 0; remaining-- {
+			i := <-completions
+			if futures[i].err != nil {
+				all.complete(nil, futures[i].err)
+				return
+			}
+			values[i] = futures[i].value
+		}
+		all.complete(values, nil)
+	}()
+	return all
+}
+
+// Any returns a future that completes with the value of the first of the
+// given futures to complete without an error. If all of the futures complete
+// with an error, the returned future completes with all of their errors as
+// `Errors`, in order.
+func Any[T any](futures ...*Future[T]) *Future[T] {
+	if len(futures) == 0 {
+		var zero T
+		return Resolve(zero, ErrNoFutures)
+	}
+	first := newFuture[T]()
+	go func() {
+		completions := make(chan int, len(futures))
+		for i, future := range futures {
+			i, future := i, future
+			future.OnComplete(func(T, error) { completions <- i })
+		}
+		errs := make(Errors, len(futures))
+		for remaining := len(futures); remaining > 0; remaining-- {
+			i := <-completions
+			if futures[i].err == nil {
+				first.complete(futures[i].value, nil)
+				return
+			}
+			errs[i] = futures[i].err
+		}
+		var zero T
+		first.complete(zero, errs)
+	}()
+	return first
+}
+
+// Race returns a future that completes with the result of the first of the
+// given futures to complete, whether or not it completed with an error.
+func Race[T any](futures ...*Future[T]) *Future[T] {
+	if len(futures) == 0 {
+		var zero T
+		return Resolve(zero, ErrNoFutures)
+	}
+	race := newFuture[T]()
+	completions := make(chan int, len(futures))
+	for i, future := range futures {
+		i, future := i, future
+		future.OnComplete(func(T, error) { completions <- i })
+	}
+	go func() {
+		i := <-completions
+		race.complete(futures[i].value, futures[i].err)
+	}()
+	return race
+}
+
+// Timeout returns a future that completes with the result of the given future
+// if it completes within the given duration, and with `ErrTimeout` otherwise.
+func Timeout[T any](future *Future[T], d time.Duration) *Future[T] {
+	return Async(func() (T, error) {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-future.done:
+			return future.value, future.err
+		case <-timer.C:
+			var zero T
+			return zero, ErrTimeout
+		}
+	})
+}
+
+func newFuture[T any]() *Future[T] {
+	return &Future[T]{done: make(chan struct{})}
+}
+
+// complete the future with a value and an error. It must only be called once.
+func (future *Future[T]) complete(value T, err error) {
+	future.value, future.err = value, err
+	close(future.done)
+}
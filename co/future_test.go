@@ -0,0 +1,171 @@
+package co_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/co"
+)
+
+var _ = Describe("Futures", func() {
+
+	after := func(d time.Duration, value int, err error) *Future[int] {
+		return Async(func() (int, error) {
+			time.Sleep(d)
+			return value, err
+		})
+	}
+
+	Context("when awaiting a future", func() {
+
+		It("should return the result of the computation", func() {
+			value, err := after(10*time.Millisecond, 42, nil).Await(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(42))
+		})
+
+		It("should return the error of the computation", func() {
+			errA := errors.New("a")
+			_, err := after(0, 0, errA).Await(context.Background())
+			Expect(err).To(Equal(errA))
+		})
+
+		It("should recover panics", func() {
+			_, err := Async(func() (int, error) { panic("oops") }).Await(context.Background())
+			Expect(err).To(BeAssignableToTypeOf(PanicError{}))
+		})
+
+		It("should return early when the context is done", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			_, err := after(time.Second, 42, nil).Await(ctx)
+			Expect(err).To(Equal(context.DeadlineExceeded))
+		})
+	})
+
+	Context("when completing a future with a promise", func() {
+
+		It("should complete the future with the first result only", func() {
+			promise := NewPromise[int]()
+			future := promise.Future()
+			Consistently(future.Done(), 10*time.Millisecond).ShouldNot(BeClosed())
+
+			completed := make(chan bool, 1)
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				completed <- promise.Complete(42, nil)
+			}()
+			value, err := future.Await(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(42))
+			Expect(<-completed).To(BeTrue())
+
+			Expect(promise.Complete(0, errors.New("late"))).To(BeFalse())
+			value, err = future.Await(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(42))
+		})
+
+		It("should be safe to complete concurrently", func() {
+			promise := NewPromise[int]()
+			completed := make(chan bool, 10)
+			for i := 0; i < 10; i++ {
+				i := i
+				go func() { completed <- promise.Complete(i, nil) }()
+			}
+			n := 0
+			for i := 0; i < 10; i++ {
+				if <-completed {
+					n++
+				}
+			}
+			Expect(n).To(Equal(1))
+		})
+
+		It("should complete the future with an error", func() {
+			promise := NewPromise[string]()
+			errA := errors.New("a")
+			promise.Complete("", errA)
+			_, err := Then(promise.Future(), func(s string) (int, error) { return len(s), nil }).Await(context.Background())
+			Expect(err).To(Equal(errA))
+		})
+	})
+
+	Context("when chaining futures", func() {
+
+		It("should apply the function to the value", func() {
+			future := Then(after(0, 21, nil), func(x int) (string, error) {
+				return "forty two", nil
+			})
+			Expect(future.Await(context.Background())).To(Equal("forty two"))
+		})
+
+		It("should not apply the function to an error", func() {
+			errA := errors.New("a")
+			called := false
+			_, err := Then(after(0, 0, errA), func(x int) (int, error) {
+				called = true
+				return x, nil
+			}).Await(context.Background())
+			Expect(err).To(Equal(errA))
+			Expect(called).To(BeFalse())
+		})
+	})
+
+	Context("when combining futures", func() {
+
+		It("should wait for all futures in order", func() {
+			values, err := All(after(20*time.Millisecond, 1, nil), after(0, 2, nil), after(10*time.Millisecond, 3, nil)).Await(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(Equal([]int{1, 2, 3}))
+		})
+
+		It("should fail all futures on the first error", func() {
+			errA := errors.New("a")
+			_, err := All(after(time.Second, 1, nil), after(0, 0, errA)).Await(context.Background())
+			Expect(err).To(Equal(errA))
+		})
+
+		It("should return any successful future", func() {
+			errA := errors.New("a")
+			value, err := Any(after(0, 0, errA), after(20*time.Millisecond, 2, nil)).Await(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal(2))
+		})
+
+		It("should return all errors when no future is successful", func() {
+			errA, errB := errors.New("a"), errors.New("b")
+			_, err := Any(after(10*time.Millisecond, 0, errA), after(0, 0, errB)).Await(context.Background())
+			Expect(err).To(Equal(Errors{errA, errB}))
+		})
+
+		It("should return the first future to complete in a race", func() {
+			errA := errors.New("a")
+			_, err := Race(after(0, 0, errA), after(time.Second, 2, nil)).Await(context.Background())
+			Expect(err).To(Equal(errA))
+		})
+
+		It("should fail when there are no futures", func() {
+			_, err := Race[int]().Await(context.Background())
+			Expect(err).To(Equal(ErrNoFutures))
+			_, err = Any[int]().Await(context.Background())
+			Expect(err).To(Equal(ErrNoFutures))
+			Expect(All[int]().Await(context.Background())).To(BeEmpty())
+		})
+	})
+
+	Context("when timing out futures", func() {
+
+		It("should return the result when the future completes in time", func() {
+			Expect(Timeout(after(0, 42, nil), time.Second).Await(context.Background())).To(Equal(42))
+		})
+
+		It("should return a timeout error when the future does not complete in time", func() {
+			_, err := Timeout(after(time.Second, 42, nil), 10*time.Millisecond).Await(context.Background())
+			Expect(err).To(Equal(ErrTimeout))
+		})
+	})
+})
@@ -0,0 +1,206 @@
+package co
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrSkip can be returned by a stage of a pipeline to drop an item, instead of
+// passing it to the next stage. It does not cause the pipeline to fail, even
+// when it is wrapped by another error.
+var ErrSkip = errors.New("skip")
+
+// A Pipeline is a sequence of stages that items flow through concurrently.
+// Each stage transforms items using a number of goroutines, and stages are
+// connected by bounded buffers, so that a slow stage applies back-pressure to
+// the stages before it. Pipelines are built by chaining calls to `Stage`, and
+// can be run any number of times.
+type Pipeline struct {
+	stages  []stage
+	ordered bool
+}
+
+// stage is one stage of a pipeline.
+type stage struct {
+	f           func(context.Context, interface{}) (interface{}, error)
+	parallelism int
+	buffer      int
+}
+
+// item is an item that flows through a pipeline. The sequence number is the
+// position of the item in the source, and is used to restore the order of the
+// items at the sink. Skipped items continue to flow through the pipeline (but
+// are not transformed) so that the sink does not wait for them.
+type item struct {
+	seq   uint64
+	value interface{}
+	skip  bool
+}
+
+// NewPipeline returns a pipeline with no stages. Running a pipeline with no
+// stages passes items from the source directly to the sink.
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// Stage appends a stage to the pipeline and returns the pipeline. The stage
+// transforms each item using the given function, running `parallelism`
+// goroutines (at least one), and buffering at most `buffer` transformed items
+// for the next stage.
+func (p *Pipeline) Stage(parallelism, buffer int, f func(context.Context, interface{}) (interface{}, error)) *Pipeline {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	if buffer < 0 {
+		buffer = 0
+	}
+	p.stages = append(p.stages, stage{f: f, parallelism: parallelism, buffer: buffer})
+	return p
+}
+
+// Ordered makes the pipeline deliver items to the sink in the same order that
+// they were received from the source, and returns the pipeline. By default,
+// items are delivered in the order that they finish the last stage. Items that
+// finish out of order are held until the items before them are delivered, so
+// the number of items in the pipeline is limited to what its stages can run
+// and buffer; a slow item applies back-pressure to the source instead of
+// letting the items after it pile up.
+func (p *Pipeline) Ordered() *Pipeline {
+	p.ordered = true
+	return p
+}
+
+// Run the pipeline, reading items from the source until it is closed, and
+// passing the transformed items to the sink. The first stage, or sink, to
+// return an error (other than `ErrSkip`) or panic stops the pipeline and
+// cancels the context given to all stages. This function blocks until all
+// items have been delivered to the sink, or until the pipeline fails, or until
+// the context is done. It returns the first failure (panics are returned as a
+// `PanicError`), or the error of the context.
+func (p *Pipeline) Run(ctx context.Context, source <-chan interface{}, sink func(interface{}) error) error {
+	return WithScope(ctx, func(s *Scope) error {
+		ctx := s.Context()
+
+		// When ordered, every item takes a slot before it is read from the
+		// source, and gives it back when it leaves the pipeline, so that the
+		// items held for reordering are bounded.
+		var slots chan struct{}
+		if p.ordered {
+			slots = make(chan struct{}, p.window())
+		}
+
+		items := make(chan item)
+		s.Go(func(ctx context.Context) error {
+			defer close(items)
+			for seq := uint64(0); ; seq++ {
+				if slots != nil {
+					select {
+					case slots <- struct{}{}:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				select {
+				case value, ok := <-source:
+					if !ok {
+						return nil
+					}
+					select {
+					case items <- item{seq: seq, value: value}:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		})
+
+		in := items
+		for _, st := range p.stages {
+			in = p.spawn(s, st, in)
+		}
+
+		if !p.ordered {
+			for it := range in {
+				if it.skip {
+					continue
+				}
+				if err := sink(it.value); err != nil {
+					return err
+				}
+			}
+			return ctx.Err()
+		}
+
+		// Hold items that finish out of order until all of the items before
+		// them have been delivered.
+		pending := map[uint64]item{}
+		next := uint64(0)
+		for it := range in {
+			pending[it.seq] = it
+			for it, ok := pending[next]; ok; it, ok = pending[next] {
+				delete(pending, next)
+				next++
+				<-slots
+				if it.skip {
+					continue
+				}
+				if err := sink(it.value); err != nil {
+					return err
+				}
+			}
+		}
+		return ctx.Err()
+	})
+}
+
+// window returns the number of items that can be in an ordered pipeline at
+// once: as many as its stages can run and buffer, and at least one.
+func (p *Pipeline) window() int {
+	window := 1
+	for _, st := range p.stages {
+		window += st.parallelism + st.buffer
+	}
+	return window
+}
+
+// spawn the goroutines for a stage in the scope, and return the buffer to
+// which the stage writes its transformed items. The buffer is closed once all
+// of the goroutines for the stage have terminated.
+func (p *Pipeline) spawn(s *Scope, st stage, in <-chan item) chan item {
+	out := make(chan item, st.buffer)
+	var wg sync.WaitGroup
+	wg.Add(st.parallelism)
+	for i := 0; i < st.parallelism; i++ {
+		s.Go(func(ctx context.Context) error {
+			defer wg.Done()
+			for it := range in {
+				if !it.skip {
+					value, err := st.f(ctx, it.value)
+					switch {
+					case err == nil:
+						it.value = value
+					case errors.Is(err, ErrSkip):
+						it.value, it.skip = nil, true
+					default:
+						return err
+					}
+				}
+				select {
+				case out <- it:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+	}
+	s.Go(func(context.Context) error {
+		wg.Wait()
+		close(out)
+		return nil
+	})
+	return out
+}
@@ -0,0 +1,165 @@
+package co_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/co"
+)
+
+var _ = Describe("Pipelines", func() {
+
+	source := func(n int) <-chan interface{} {
+		xs := make(chan interface{}, n)
+		for i := 0; i < n; i++ {
+			xs <- i
+		}
+		close(xs)
+		return xs
+	}
+
+	jitter := func(ctx context.Context, x interface{}) (interface{}, error) {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+		return x, nil
+	}
+
+	double := func(_ context.Context, x interface{}) (interface{}, error) {
+		return 2 * x.(int), nil
+	}
+
+	collect := func(ys *[]int) func(interface{}) error {
+		return func(y interface{}) error {
+			*ys = append(*ys, y.(int))
+			return nil
+		}
+	}
+
+	It("should pass items through every stage", func() {
+		ys := []int{}
+		p := NewPipeline().Stage(4, 1, jitter).Stage(2, 0, double)
+		Expect(p.Run(context.Background(), source(100), collect(&ys))).To(Succeed())
+		sort.Ints(ys)
+		Expect(ys).To(Equal(MapRange(100, func(i int) int { return 2 * i })))
+	})
+
+	It("should preserve the order of items when ordered", func() {
+		ys := []int{}
+		p := NewPipeline().Stage(8, 8, jitter).Stage(4, 0, double).Stage(8, 2, jitter).Ordered()
+		Expect(p.Run(context.Background(), source(100), collect(&ys))).To(Succeed())
+		Expect(ys).To(Equal(MapRange(100, func(i int) int { return 2 * i })))
+	})
+
+	It("should apply back-pressure to the source when an ordered item is slow", func() {
+		release := make(chan struct{})
+		p := NewPipeline().Stage(2, 1, func(_ context.Context, x interface{}) (interface{}, error) {
+			if x.(int) == 0 {
+				<-release
+			}
+			return x, nil
+		}).Ordered()
+
+		xs := make(chan interface{})
+		sent := int64(0)
+		go func() {
+			defer close(xs)
+			for i := 0; i < 100; i++ {
+				xs <- i
+				atomic.AddInt64(&sent, 1)
+			}
+		}()
+		ys := []int{}
+		errs := make(chan error, 1)
+		go func() { errs <- p.Run(context.Background(), xs, collect(&ys)) }()
+
+		// The first item stalls the stage, so the items after it cannot be
+		// delivered, and only enough items to fill the pipeline are read.
+		Eventually(func() int64 { return atomic.LoadInt64(&sent) }).Should(Equal(int64(4)))
+		Consistently(func() int64 { return atomic.LoadInt64(&sent) }, 50*time.Millisecond).Should(Equal(int64(4)))
+
+		close(release)
+		Eventually(errs).Should(Receive(BeNil()))
+		Expect(ys).To(Equal(MapRange(100, func(i int) int { return i })))
+	})
+
+	It("should drop skipped items", func() {
+		ys := []int{}
+		p := NewPipeline().Stage(4, 0, func(_ context.Context, x interface{}) (interface{}, error) {
+			if x.(int)%2 == 1 {
+				return nil, ErrSkip
+			}
+			return x, nil
+		}).Stage(4, 0, jitter).Ordered()
+		Expect(p.Run(context.Background(), source(10), collect(&ys))).To(Succeed())
+		Expect(ys).To(Equal([]int{0, 2, 4, 6, 8}))
+	})
+
+	It("should drop items that are skipped with a wrapped error", func() {
+		ys := []int{}
+		p := NewPipeline().Stage(4, 0, func(_ context.Context, x interface{}) (interface{}, error) {
+			if x.(int) >= 3 {
+				return nil, fmt.Errorf("too big: %w", ErrSkip)
+			}
+			return x, nil
+		}).Ordered()
+		Expect(p.Run(context.Background(), source(10), collect(&ys))).To(Succeed())
+		Expect(ys).To(Equal([]int{0, 1, 2}))
+	})
+
+	It("should limit the number of goroutines in a stage", func() {
+		running, max := int64(0), int64(0)
+		p := NewPipeline().Stage(3, 0, func(_ context.Context, x interface{}) (interface{}, error) {
+			n := atomic.AddInt64(&running, 1)
+			defer atomic.AddInt64(&running, -1)
+			for m := atomic.LoadInt64(&max); n > m; m = atomic.LoadInt64(&max) {
+				atomic.CompareAndSwapInt64(&max, m, n)
+			}
+			time.Sleep(time.Millisecond)
+			return x, nil
+		})
+		Expect(p.Run(context.Background(), source(20), func(interface{}) error { return nil })).To(Succeed())
+		Expect(max).To(BeNumerically("<=", 3))
+	})
+
+	It("should stop at the first error", func() {
+		errA := errors.New("a")
+		n := int64(0)
+		p := NewPipeline().Stage(1, 0, func(_ context.Context, x interface{}) (interface{}, error) {
+			if x.(int) == 5 {
+				return nil, errA
+			}
+			return x, nil
+		})
+		err := p.Run(context.Background(), source(100), func(interface{}) error {
+			atomic.AddInt64(&n, 1)
+			return nil
+		})
+		Expect(err).To(Equal(errA))
+		Expect(n).To(BeNumerically("<=", 5))
+	})
+
+	It("should stop when the sink fails", func() {
+		errA := errors.New("a")
+		err := NewPipeline().Stage(2, 0, double).Run(context.Background(), source(100), func(interface{}) error {
+			return errA
+		})
+		Expect(err).To(Equal(errA))
+	})
+
+	It("should stop when the context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		xs := make(chan interface{})
+		go func() {
+			xs <- 1
+			cancel()
+		}()
+		err := NewPipeline().Stage(1, 0, double).Run(ctx, xs, func(interface{}) error { return nil })
+		Expect(err).To(Equal(context.Canceled))
+	})
+})
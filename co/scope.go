@@ -0,0 +1,69 @@
+package co
+
+import (
+	"context"
+	"sync"
+)
+
+// A Scope is a group of goroutines whose lifetimes are bound by a call to
+// `WithScope`. All goroutines spawned in a scope share a context that is
+// cancelled when any of them fails, and are guaranteed to have terminated
+// before `WithScope` returns.
+type Scope struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wg      sync.WaitGroup
+	errOnce sync.Once
+	err     error
+}
+
+// WithScope creates a scope with a context derived from the given context,
+// and passes it to the given function. The function, and any goroutine spawned
+// in the scope, fails if it returns an error or panics; the first failure
+// cancels the context of the scope and is returned (panics are returned as a
+// `PanicError`). This function blocks until the given function has returned
+// and all goroutines spawned in the scope have terminated.
+func WithScope(ctx context.Context, f func(s *Scope) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Scope{ctx: ctx, cancel: cancel}
+	defer cancel()
+
+	s.fail(protect(func() error {
+		return f(s)
+	}))
+	s.wg.Wait()
+	return s.err
+}
+
+// Go spawns a goroutine in the scope. The goroutine is given the context of
+// the scope, and should return promptly once it is cancelled. Go can be called
+// from any goroutine in the scope, but must not be called after the call to
+// `WithScope` has returned.
+func (s *Scope) Go(f func(context.Context) error) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.fail(protect(func() error {
+			return f(s.ctx)
+		}))
+	}()
+}
+
+// Context returns the context of the scope. It is cancelled when the first
+// goroutine in the scope fails, or when the parent context is cancelled.
+func (s *Scope) Context() context.Context {
+	return s.ctx
+}
+
+// fail records the first error that occurs in the scope and cancels the
+// context of the scope. Nil errors are ignored.
+func (s *Scope) fail(err error) {
+	if err == nil {
+		return
+	}
+	s.errOnce.Do(func() {
+		s.err = err
+		s.cancel()
+	})
+}
@@ -0,0 +1,108 @@
+package co_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/co"
+)
+
+var _ = Describe("Scopes", func() {
+
+	It("should join all goroutines before returning", func() {
+		n := int64(0)
+		err := WithScope(context.Background(), func(s *Scope) error {
+			for i := 0; i < 10; i++ {
+				s.Go(func(context.Context) error {
+					time.Sleep(10 * time.Millisecond)
+					atomic.AddInt64(&n, 1)
+					return nil
+				})
+			}
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(atomic.LoadInt64(&n)).To(Equal(int64(10)))
+	})
+
+	It("should allow goroutines to spawn more goroutines", func() {
+		n := int64(0)
+		var spawn func(s *Scope, depth int) func(context.Context) error
+		spawn = func(s *Scope, depth int) func(context.Context) error {
+			return func(context.Context) error {
+				atomic.AddInt64(&n, 1)
+				if depth > 0 {
+					s.Go(spawn(s, depth-1))
+					s.Go(spawn(s, depth-1))
+				}
+				return nil
+			}
+		}
+		err := WithScope(context.Background(), func(s *Scope) error {
+			s.Go(spawn(s, 4))
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(atomic.LoadInt64(&n)).To(Equal(int64(31)))
+	})
+
+	It("should cancel all goroutines and return the first failure", func() {
+		errA := errors.New("a")
+		cancelled := int64(0)
+		err := WithScope(context.Background(), func(s *Scope) error {
+			for i := 0; i < 5; i++ {
+				s.Go(func(ctx context.Context) error {
+					<-ctx.Done()
+					atomic.AddInt64(&cancelled, 1)
+					return ctx.Err()
+				})
+			}
+			s.Go(func(context.Context) error {
+				return errA
+			})
+			return nil
+		})
+		Expect(err).To(Equal(errA))
+		Expect(atomic.LoadInt64(&cancelled)).To(Equal(int64(5)))
+	})
+
+	It("should capture panics", func() {
+		err := WithScope(context.Background(), func(s *Scope) error {
+			s.Go(func(context.Context) error {
+				panic("oops")
+			})
+			return nil
+		})
+		Expect(err).To(BeAssignableToTypeOf(PanicError{}))
+		Expect(err.(PanicError).Value).To(Equal("oops"))
+	})
+
+	It("should fail when the scope function fails", func() {
+		errA := errors.New("a")
+		err := WithScope(context.Background(), func(s *Scope) error {
+			s.Go(func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			})
+			return errA
+		})
+		Expect(err).To(Equal(errA))
+	})
+
+	It("should cancel the scope when the parent context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		err := WithScope(ctx, func(s *Scope) error {
+			s.Go(func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			})
+			cancel()
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
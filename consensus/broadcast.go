@@ -0,0 +1,239 @@
+package consensus
+
+import (
+	"github.com/renproject/phi/task"
+)
+
+// Initial is the message that the origin of a reliable broadcast sends to all
+// nodes.
+type Initial struct {
+	Origin  ID
+	Seq     uint64
+	Message task.Message
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Initial) IsMessage() {}
+
+// Echo is the message that nodes send to all nodes when they receive the
+// `Initial` message of a reliable broadcast.
+type Echo struct {
+	Origin  ID
+	Seq     uint64
+	Message task.Message
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Echo) IsMessage() {}
+
+// Ready is the message that nodes send to all nodes when they know that enough
+// nodes have echoed a reliable broadcast for it to be delivered.
+type Ready struct {
+	Origin  ID
+	Seq     uint64
+	Message task.Message
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Ready) IsMessage() {}
+
+// BroadcastOptions are passed when constructing a `ReliableBroadcast` handler.
+// The `Peers` are all of the other nodes. `Faults` is the number of Byzantine
+// nodes that are tolerated, which must be less than a third of all nodes; if
+// it is not positive, the largest number that is tolerated is used.
+//
+// Messages are compared using the value returned by `Digest`. If it is nil,
+// messages are compared directly, so they must be comparable (with `==`).
+type BroadcastOptions struct {
+	ID        ID
+	Peers     []ID
+	Faults    int
+	Transport task.Sender
+	Deliver   task.Sender
+	Digest    func(task.Message) interface{}
+}
+
+// ReliableBroadcast is a `task.Handler` that implements Bracha's reliable
+// broadcast. When it receives a `Broadcast` message, it sends the message to
+// all nodes. The nodes echo the message to each other, and then declare that
+// they are ready to deliver it, and only deliver it once enough nodes are
+// ready. This guarantees that, even if the origin (or any other node) is
+// Byzantine, either all of the correct nodes deliver the same message, or none
+// of them deliver anything. If the origin is correct, all of the correct nodes
+// deliver its message.
+//
+// Unlike `Gossip`, reliable broadcast requires every node to be able to send
+// to every other node, and it does not tolerate lost messages (other than
+// messages to and from faulty nodes).
+type ReliableBroadcast struct {
+	opts      BroadcastOptions
+	peers     []ID
+	faults    int
+	seq       uint64
+	delivered map[ID]*seen
+	instances map[instanceKey]*instance
+}
+
+type instanceKey struct {
+	origin ID
+	seq    uint64
+}
+
+// instance is the state of one broadcast that has not been delivered. Each
+// node is only counted once for echoes and readies, for the first message that
+// it sends.
+type instance struct {
+	initial, echoed, readied bool
+	values                   map[interface{}]task.Message
+	echoes, readies          map[ID]struct{}
+	echoCount, readyCount    map[interface{}]int
+}
+
+// NewReliableBroadcast returns a `ReliableBroadcast` handler. It panics if the
+// number of faults that are tolerated is too large for the number of nodes.
+func NewReliableBroadcast(opts BroadcastOptions) *ReliableBroadcast {
+	peers := others(opts.ID, opts.Peers)
+	n := len(peers) + 1
+	faults := opts.Faults
+	if faults <= 0 {
+		faults = (n - 1) / 3
+	}
+	if 3*faults >= n {
+		panic("broadcast error: too many faults for the number of nodes")
+	}
+	return &ReliableBroadcast{
+		opts:      opts,
+		peers:     peers,
+		faults:    faults,
+		delivered: map[ID]*seen{},
+		instances: map[instanceKey]*instance{},
+	}
+}
+
+// Handle implements the `task.Handler` interface. Messages other than
+// `Broadcast` messages, and envelopes containing `Initial`, `Echo`, and `Ready`
+// messages, are ignored.
+func (rb *ReliableBroadcast) Handle(_ task.Task, m task.Message) {
+	switch m := m.(type) {
+	case Broadcast:
+		rb.seq++
+		rb.send(Initial{Origin: rb.opts.ID, Seq: rb.seq, Message: m.Message})
+	case Envelope:
+		rb.receive(m.From, m.Message)
+	}
+}
+
+// send a message to all nodes, including this one.
+func (rb *ReliableBroadcast) send(m task.Message) {
+	for _, to := range rb.peers {
+		rb.opts.Transport.Send(Envelope{From: rb.opts.ID, To: to, Message: m})
+	}
+	rb.receive(rb.opts.ID, m)
+}
+
+func (rb *ReliableBroadcast) receive(from ID, m task.Message) {
+	switch m := m.(type) {
+	case Initial:
+		// Only the origin can send the initial message.
+		inst := rb.instance(m.Origin, m.Seq)
+		if inst == nil || from != m.Origin || inst.initial {
+			return
+		}
+		inst.initial = true
+		if !inst.echoed {
+			inst.echoed = true
+			rb.send(Echo(m))
+		}
+
+	case Echo:
+		inst := rb.instance(m.Origin, m.Seq)
+		if inst == nil || !inst.count(inst.echoes, inst.echoCount, from, rb.digest(m.Message), m.Message) {
+			return
+		}
+		// Once more than (n+f)/2 nodes have echoed the same message, no other
+		// message can be echoed by as many, so it is safe to be ready.
+		if d := rb.digest(m.Message); inst.echoCount[d] > (len(rb.peers)+1+rb.faults)/2 && !inst.readied {
+			inst.readied = true
+			rb.send(Ready(m))
+		}
+
+	case Ready:
+		inst := rb.instance(m.Origin, m.Seq)
+		if inst == nil {
+			return
+		}
+		d := rb.digest(m.Message)
+		if !inst.count(inst.readies, inst.readyCount, from, d, m.Message) {
+			return
+		}
+		// At least one correct node is ready, so join it.
+		if inst.readyCount[d] > rb.faults && !inst.readied {
+			inst.readied = true
+			rb.send(Ready(m))
+		}
+		// At least f+1 correct nodes are ready, and they will make all other
+		// correct nodes ready too, so it is safe to deliver.
+		if inst.readyCount[d] > 2*rb.faults {
+			rb.deliver(m.Origin, m.Seq, inst.values[d])
+		}
+	}
+}
+
+// instance returns the state of a broadcast, or nil if it has already been
+// delivered.
+func (rb *ReliableBroadcast) instance(origin ID, seq uint64) *instance {
+	if s, ok := rb.delivered[origin]; ok && s.has(seq) {
+		return nil
+	}
+	key := instanceKey{origin: origin, seq: seq}
+	inst, ok := rb.instances[key]
+	if !ok {
+		inst = &instance{
+			values:     map[interface{}]task.Message{},
+			echoes:     map[ID]struct{}{},
+			readies:    map[ID]struct{}{},
+			echoCount:  map[interface{}]int{},
+			readyCount: map[interface{}]int{},
+		}
+		rb.instances[key] = inst
+	}
+	return inst
+}
+
+func (rb *ReliableBroadcast) deliver(origin ID, seq uint64, m task.Message) {
+	s, ok := rb.delivered[origin]
+	if !ok {
+		s = &seen{}
+		rb.delivered[origin] = s
+	}
+	if !s.add(seq) {
+		// Sending a ready message can deliver the broadcast before the
+		// sender has finished handling the message that made it ready.
+		return
+	}
+	delete(rb.instances, instanceKey{origin: origin, seq: seq})
+	if rb.opts.Deliver != nil {
+		rb.opts.Deliver.Send(Delivered{Origin: origin, Seq: seq, Message: m})
+	}
+}
+
+func (rb *ReliableBroadcast) digest(m task.Message) interface{} {
+	if rb.opts.Digest != nil {
+		return rb.opts.Digest(m)
+	}
+	return m
+}
+
+// count the message from a node, unless the node has already been counted. It
+// returns false if the node had already been counted.
+func (inst *instance) count(from map[ID]struct{}, counts map[interface{}]int, id ID, digest interface{}, m task.Message) bool {
+	if _, ok := from[id]; ok {
+		return false
+	}
+	from[id] = struct{}{}
+	counts[digest]++
+	if _, ok := inst.values[digest]; !ok {
+		inst.values[digest] = m
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package consensus_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/consensus"
+
+	"github.com/renproject/phi/task"
+)
+
+// batch is a message that cannot be compared with `==`.
+type batch []int
+
+func (batch) IsMessage() {}
+
+var _ = Describe("Reliable broadcast", func() {
+
+	var sim *Sim
+	var inboxes map[ID]*inbox
+
+	// setup n honest nodes, and f nodes that are controlled by the test.
+	setup := func(opts SimOptions, n, f int) {
+		sim = NewSim(opts)
+		inboxes = map[ID]*inbox{}
+		ids := make([]ID, n+f)
+		for i := range ids {
+			ids[i] = ID(i)
+		}
+		for i := 0; i < n; i++ {
+			inboxes[ID(i)] = newInbox()
+			sim.Add(ID(i), NewReliableBroadcast(BroadcastOptions{
+				ID:        ID(i),
+				Peers:     ids,
+				Transport: sim,
+				Deliver:   inboxes[ID(i)],
+				Digest: func(m task.Message) interface{} {
+					return fmt.Sprint(m)
+				},
+			}))
+		}
+		for i := n; i < n+f; i++ {
+			sim.Add(ID(i), newInbox())
+		}
+	}
+
+	// byzantine sends a message from a faulty node to some nodes.
+	byzantine := func(from ID, m task.Message, to ...ID) {
+		for _, id := range to {
+			sim.Send(Envelope{From: from, To: id, Message: m})
+		}
+	}
+
+	Context("when the origin is correct", func() {
+		It("should deliver its messages to every correct node", func() {
+			setup(SimOptions{Seed: 1, Duplicate: 0.2, Reorder: true}, 4, 0)
+			sim.Input(0, Broadcast{Message: msg(1)})
+			sim.Input(1, Broadcast{Message: batch{1, 2}})
+			sim.Input(0, Broadcast{Message: msg(2)})
+			sim.Flush()
+			for _, in := range inboxes {
+				Expect(in.get()).To(ConsistOf(
+					Delivered{Origin: 0, Seq: 1, Message: msg(1)},
+					Delivered{Origin: 0, Seq: 2, Message: msg(2)},
+					Delivered{Origin: 1, Seq: 1, Message: batch{1, 2}},
+				))
+			}
+		})
+
+		It("should tolerate a faulty node that is silent", func() {
+			setup(SimOptions{}, 6, 1)
+			sim.Crash(6)
+			sim.Input(2, Broadcast{Message: msg(1)})
+			sim.Flush()
+			for _, in := range inboxes {
+				Expect(in.get()).To(Equal([]task.Message{Delivered{Origin: 2, Seq: 1, Message: msg(1)}}))
+			}
+		})
+	})
+
+	Context("when the origin is faulty", func() {
+		It("should deliver the same message to every correct node", func() {
+			setup(SimOptions{Seed: 2, Reorder: true}, 3, 1)
+			byzantine(3, Initial{Origin: 3, Seq: 1, Message: msg(1)}, 0, 1)
+			byzantine(3, Initial{Origin: 3, Seq: 1, Message: msg(2)}, 2)
+			byzantine(3, Echo{Origin: 3, Seq: 1, Message: msg(1)}, 0, 1)
+			byzantine(3, Echo{Origin: 3, Seq: 1, Message: msg(2)}, 2)
+			sim.Flush()
+			for _, in := range inboxes {
+				Expect(in.get()).To(Equal([]task.Message{Delivered{Origin: 3, Seq: 1, Message: msg(1)}}))
+			}
+		})
+
+		It("should not deliver anything if the correct nodes do not agree", func() {
+			setup(SimOptions{}, 5, 2)
+			byzantine(5, Initial{Origin: 5, Seq: 1, Message: msg(1)}, 0, 1)
+			byzantine(5, Initial{Origin: 5, Seq: 1, Message: msg(2)}, 2, 3, 4)
+			for _, from := range []ID{5, 6} {
+				byzantine(from, Echo{Origin: 5, Seq: 1, Message: msg(1)}, 0, 1, 2, 3, 4)
+				byzantine(from, Ready{Origin: 5, Seq: 1, Message: msg(1)}, 0, 1, 2, 3, 4)
+			}
+			sim.Flush()
+			for _, in := range inboxes {
+				Expect(in.get()).To(BeEmpty())
+			}
+		})
+
+		It("should ignore initial messages that are not from the origin", func() {
+			setup(SimOptions{}, 4, 1)
+			byzantine(4, Initial{Origin: 0, Seq: 1, Message: msg(1)}, 0, 1, 2, 3)
+			sim.Flush()
+			for _, in := range inboxes {
+				Expect(in.get()).To(BeEmpty())
+			}
+		})
+	})
+
+	Context("when there are too many faults", func() {
+		It("should panic", func() {
+			Expect(func() {
+				NewReliableBroadcast(BroadcastOptions{ID: 0, Peers: []ID{1, 2}, Faults: 1})
+			}).To(Panic())
+		})
+	})
+})
@@ -0,0 +1,155 @@
+// Package consensus provides building blocks for distributed protocols, as
+// reusable `task.Handler`s: gossip broadcast with deduplication, reliable
+// (echo) broadcast that tolerates Byzantine nodes, and Raft-style leader
+// election and log replication.
+//
+// Nodes do not talk to each other directly. Instead, every message between
+// nodes is wrapped in an `Envelope` and sent to a transport, which is any
+// `task.Sender` that delivers envelopes to the node that they are addressed
+// to. A `Network` is a transport that delivers envelopes to tasks in the same
+// process, and a `Sim` is a transport that runs the nodes deterministically,
+// in a single goroutine, with injected network faults, so that protocols can
+// be tested reproducibly.
+//
+// Handlers in this package do not use timers. Protocols that need to measure
+// time (such as Raft) count `Tick` messages instead, which must be sent to
+// them periodically.
+package consensus
+
+import (
+	"sync"
+
+	"github.com/renproject/phi/task"
+)
+
+// ID identifies a node.
+type ID uint
+
+// None is an ID that does not belong to any node. It is used when a node does
+// not know the ID of another node (for example, when there is no leader).
+// Nodes must not use it as their ID.
+const None = ^ID(0)
+
+// Envelope is a message that is sent from one node to another. Handlers in
+// this package send envelopes to their transport, and expect the transport to
+// deliver envelopes that are addressed to them.
+type Envelope struct {
+	From, To ID
+	Message  task.Message
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Envelope) IsMessage() {}
+
+// Tick is the message that drives time in handlers that need it. Timeouts are
+// measured in ticks, so the tick interval determines how long they are.
+type Tick struct{}
+
+// IsMessage implements the `task.Message` interface.
+func (Tick) IsMessage() {}
+
+// Delivered is the message sent to the `Deliver` sender of a broadcast handler
+// when a broadcast message is delivered. The message with a given `Origin` and
+// `Seq` is delivered at most once.
+type Delivered struct {
+	Origin  ID
+	Seq     uint64
+	Message task.Message
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Delivered) IsMessage() {}
+
+// A Network is a transport that delivers envelopes to tasks (or any other
+// senders) in the same process. Envelopes addressed to nodes that have not
+// been added are rejected, as are envelopes that the addressed node rejects.
+// It is safe for concurrent use.
+type Network struct {
+	mu    *sync.RWMutex
+	nodes map[ID]task.Sender
+}
+
+// NewNetwork returns an empty `Network`.
+func NewNetwork() *Network {
+	return &Network{mu: new(sync.RWMutex), nodes: map[ID]task.Sender{}}
+}
+
+// Add a node to the network. Envelopes addressed to the ID are sent to the
+// node, replacing any node that was previously added with the same ID.
+func (network *Network) Add(id ID, node task.Sender) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	network.nodes[id] = node
+}
+
+// Remove a node from the network.
+func (network *Network) Remove(id ID) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	delete(network.nodes, id)
+}
+
+// Send implements the `task.Sender` interface. Messages that are not
+// envelopes are rejected.
+func (network *Network) Send(m task.Message) bool {
+	env, ok := m.(Envelope)
+	if !ok {
+		return false
+	}
+	network.mu.RLock()
+	node, ok := network.nodes[env.To]
+	network.mu.RUnlock()
+	return ok && node.Send(env)
+}
+
+// seen is a set of sequence numbers, starting from one. It only stores the
+// sequence numbers that are above the highest contiguous sequence number that
+// has been added, so when sequence numbers are added roughly in order, it
+// uses a constant amount of memory.
+type seen struct {
+	floor uint64
+	above map[uint64]struct{}
+}
+
+// add a sequence number to the set. It returns false if the sequence number
+// was already in the set.
+func (s *seen) add(seq uint64) bool {
+	if s.has(seq) {
+		return false
+	}
+	if s.above == nil {
+		s.above = map[uint64]struct{}{}
+	}
+	s.above[seq] = struct{}{}
+	for {
+		if _, ok := s.above[s.floor+1]; !ok {
+			break
+		}
+		delete(s.above, s.floor+1)
+		s.floor++
+	}
+	return true
+}
+
+// has returns true if the sequence number is in the set.
+func (s *seen) has(seq uint64) bool {
+	if seq <= s.floor {
+		return true
+	}
+	_, ok := s.above[seq]
+	return ok
+}
+
+// others returns the IDs, without the given ID or duplicates.
+func others(id ID, ids []ID) []ID {
+	set := make(map[ID]struct{}, len(ids))
+	result := make([]ID, 0, len(ids))
+	for _, other := range ids {
+		if _, ok := set[other]; ok || other == id {
+			continue
+		}
+		set[other] = struct{}{}
+		result = append(result, other)
+	}
+	return result
+}
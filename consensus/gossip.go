@@ -0,0 +1,116 @@
+package consensus
+
+import (
+	"math/rand"
+
+	"github.com/renproject/phi/task"
+)
+
+// Broadcast is the message that makes a broadcast handler broadcast a message
+// to all nodes (including itself).
+type Broadcast struct {
+	Message task.Message
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Broadcast) IsMessage() {}
+
+// Rumor is the message that gossip nodes forward to each other. The `Origin`
+// and `Seq` identify the broadcast, so that nodes can ignore rumors that they
+// have already seen.
+type Rumor struct {
+	Origin  ID
+	Seq     uint64
+	Message task.Message
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Rumor) IsMessage() {}
+
+// GossipOptions are passed when constructing a `Gossip` handler. The `Peers`
+// are the nodes that the node forwards rumors to; they do not need to include
+// every node, as long as every node can be reached by following peers. If
+// `Fanout` is positive, rumors are only forwarded to that many peers, chosen
+// at random (using a source seeded with `Seed` and the ID of the node), which
+// reduces the number of messages at the cost of the broadcast only reaching
+// every node with high probability.
+type GossipOptions struct {
+	ID        ID
+	Peers     []ID
+	Transport task.Sender
+	Deliver   task.Sender
+	Fanout    int
+	Seed      int64
+}
+
+// Gossip is a `task.Handler` that broadcasts messages by flooding. When it
+// receives a `Broadcast` message, it delivers the message to its `Deliver`
+// sender, and sends it to its peers as a `Rumor`. When it receives a rumor
+// that it has not seen before, it delivers the message and forwards the rumor
+// to its peers (except the one that it received the rumor from). Gossip
+// tolerates lost messages and crashed nodes as long as there is a path of
+// working links between nodes, but not Byzantine nodes (see
+// `ReliableBroadcast`).
+//
+// Rumors that have been seen are remembered using a set of sequence numbers
+// per origin, which stays small when the rumors from each origin are seen
+// roughly in order.
+type Gossip struct {
+	opts  GossipOptions
+	peers []ID
+	rand  *rand.Rand
+	seq   uint64
+	seen  map[ID]*seen
+}
+
+// NewGossip returns a `Gossip` handler.
+func NewGossip(opts GossipOptions) *Gossip {
+	return &Gossip{
+		opts:  opts,
+		peers: others(opts.ID, opts.Peers),
+		rand:  rand.New(rand.NewSource(opts.Seed + int64(opts.ID))),
+		seen:  map[ID]*seen{},
+	}
+}
+
+// Handle implements the `task.Handler` interface. Messages other than
+// `Broadcast` messages, and envelopes containing rumors, are ignored.
+func (gossip *Gossip) Handle(_ task.Task, m task.Message) {
+	switch m := m.(type) {
+	case Broadcast:
+		gossip.seq++
+		gossip.receive(gossip.opts.ID, Rumor{Origin: gossip.opts.ID, Seq: gossip.seq, Message: m.Message})
+	case Envelope:
+		if rumor, ok := m.Message.(Rumor); ok {
+			gossip.receive(m.From, rumor)
+		}
+	}
+}
+
+func (gossip *Gossip) receive(from ID, rumor Rumor) {
+	s, ok := gossip.seen[rumor.Origin]
+	if !ok {
+		s = &seen{}
+		gossip.seen[rumor.Origin] = s
+	}
+	if !s.add(rumor.Seq) {
+		return
+	}
+	if gossip.opts.Deliver != nil {
+		gossip.opts.Deliver.Send(Delivered{Origin: rumor.Origin, Seq: rumor.Seq, Message: rumor.Message})
+	}
+
+	targets := make([]ID, 0, len(gossip.peers))
+	for _, peer := range gossip.peers {
+		if peer != from && peer != rumor.Origin {
+			targets = append(targets, peer)
+		}
+	}
+	if fanout := gossip.opts.Fanout; fanout > 0 && fanout < len(targets) {
+		gossip.rand.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+		targets = targets[:fanout]
+	}
+	for _, to := range targets {
+		gossip.opts.Transport.Send(Envelope{From: gossip.opts.ID, To: to, Message: rumor})
+	}
+}
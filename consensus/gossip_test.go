@@ -0,0 +1,141 @@
+package consensus_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/consensus"
+
+	"github.com/renproject/phi/task"
+)
+
+// ring returns the neighbours of each node in a ring.
+func ring(n int) map[ID][]ID {
+	peers := map[ID][]ID{}
+	for i := 0; i < n; i++ {
+		peers[ID(i)] = []ID{ID((i + n - 1) % n), ID((i + 1) % n)}
+	}
+	return peers
+}
+
+// complete returns the peers of each node in a complete graph.
+func complete(n int) map[ID][]ID {
+	peers := map[ID][]ID{}
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			peers[ID(i)] = append(peers[ID(i)], ID(j))
+		}
+	}
+	return peers
+}
+
+var _ = Describe("Gossip", func() {
+
+	gossip := func(sim *Sim, peers map[ID][]ID, fanout int) map[ID]*inbox {
+		inboxes := map[ID]*inbox{}
+		for id, ps := range peers {
+			inboxes[id] = newInbox()
+			sim.Add(id, NewGossip(GossipOptions{
+				ID:        id,
+				Peers:     ps,
+				Transport: sim,
+				Deliver:   inboxes[id],
+				Fanout:    fanout,
+			}))
+		}
+		return inboxes
+	}
+
+	Context("when broadcasting over an unreliable network", func() {
+		It("should deliver every message exactly once to every node", func() {
+			sim := NewSim(SimOptions{Seed: 1, Duplicate: 0.5, Reorder: true})
+			inboxes := gossip(sim, ring(10), 0)
+			for i := 0; i < 3; i++ {
+				sim.Input(ID(i), Broadcast{Message: msg(i)})
+				sim.Input(ID(i), Broadcast{Message: msg(10 + i)})
+			}
+			sim.Flush()
+
+			for _, in := range inboxes {
+				Expect(in.get()).To(ConsistOf(
+					Delivered{Origin: 0, Seq: 1, Message: msg(0)},
+					Delivered{Origin: 0, Seq: 2, Message: msg(10)},
+					Delivered{Origin: 1, Seq: 1, Message: msg(1)},
+					Delivered{Origin: 1, Seq: 2, Message: msg(11)},
+					Delivered{Origin: 2, Seq: 1, Message: msg(2)},
+					Delivered{Origin: 2, Seq: 2, Message: msg(12)},
+				))
+			}
+		})
+
+		It("should reach every node that there is a path to", func() {
+			sim := NewSim(SimOptions{})
+			inboxes := gossip(sim, ring(6), 0)
+
+			sim.Partition([]ID{0, 1, 2}, []ID{3, 4, 5})
+			sim.Input(0, Broadcast{Message: msg(1)})
+			sim.Flush()
+			Expect(inboxes[3].get()).To(BeEmpty())
+			Expect(inboxes[5].get()).To(BeEmpty())
+			Expect(inboxes[2].get()).To(HaveLen(1))
+
+			// Crashing one node of the ring leaves a path to every
+			// other node.
+			sim.Heal()
+			sim.Crash(1)
+			sim.Input(0, Broadcast{Message: msg(2)})
+			sim.Flush()
+			for id, in := range inboxes {
+				if id != 1 {
+					Expect(in.get()).To(ContainElement(Delivered{Origin: 0, Seq: 2, Message: msg(2)}))
+				}
+			}
+		})
+	})
+
+	Context("when the fanout is limited", func() {
+		It("should send fewer messages and still reach every node", func() {
+			sim := NewSim(SimOptions{Seed: 1})
+			inboxes := gossip(sim, complete(20), 4)
+			sim.Input(0, Broadcast{Message: msg(1)})
+			Expect(sim.Pending()).To(Equal(4))
+			n := sim.Flush()
+			Expect(n).To(BeNumerically("<", 19*19))
+			for _, in := range inboxes {
+				Expect(in.get()).To(HaveLen(1))
+			}
+		})
+	})
+
+	Context("when running in tasks", func() {
+		It("should deliver every message to every node", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			network := NewNetwork()
+			tasks := map[ID]task.Task{}
+			inboxes := map[ID]*inbox{}
+			for id, peers := range complete(5) {
+				inboxes[id] = newInbox()
+				t := task.New(NewGossip(GossipOptions{
+					ID:        id,
+					Peers:     peers,
+					Transport: network,
+					Deliver:   inboxes[id],
+				}), task.Options{Cap: 16})
+				tasks[id] = t
+				network.Add(id, t)
+				go t.Run(ctx)
+			}
+
+			Expect(tasks[2].Send(Broadcast{Message: msg(1)})).To(BeTrue())
+			for _, in := range inboxes {
+				Eventually(in.get, time.Second).Should(Equal([]task.Message{
+					Delivered{Origin: 2, Seq: 1, Message: msg(1)},
+				}))
+			}
+		})
+	})
+})
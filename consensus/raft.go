@@ -0,0 +1,538 @@
+package consensus
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+
+	"github.com/renproject/phi/task"
+)
+
+// ErrNotLeader is the error of a `Proposed` message when a command is proposed
+// to a node that is not the leader.
+var ErrNotLeader = errors.New("not leader")
+
+const (
+	// DefaultElectionTicks is the default number of ticks that a follower
+	// waits to hear from a leader before starting an election.
+	DefaultElectionTicks = 10
+
+	// DefaultHeartbeatTicks is the default number of ticks between the
+	// heartbeats of a leader.
+	DefaultHeartbeatTicks = 1
+)
+
+// Role is the role of a Raft node.
+type Role uint8
+
+const (
+	// Follower nodes replicate the log of the leader.
+	Follower Role = iota
+	// Candidate nodes are trying to become the leader.
+	Candidate
+	// Leader nodes accept commands and replicate them to the followers.
+	Leader
+)
+
+// String implements the `fmt.Stringer` interface.
+func (role Role) String() string {
+	switch role {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	default:
+		return "unknown"
+	}
+}
+
+// Entry is an entry in the log of a Raft node. The first entry that a leader
+// appends to the log has a nil command; such entries are not applied.
+type Entry struct {
+	Term    uint64
+	Command task.Message
+}
+
+// Propose is the message that proposes a command to be appended to the log. If
+// `Reply` is not nil, a `Proposed` message is sent to it. Proposed commands are
+// not guaranteed to be committed (for example, if the leader crashes before it
+// has replicated them), so clients should wait for them to be committed, and
+// propose them again if they are not.
+type Propose struct {
+	Command task.Message
+	Reply   task.Sender
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Propose) IsMessage() {}
+
+// Proposed is the reply to a `Propose` message. If the node was not the
+// leader, `Err` is `ErrNotLeader`, and `Leader` is the leader that the node
+// knows of (or `None`). Otherwise, `Index` and `Term` are where the command was
+// appended to the log.
+type Proposed struct {
+	Command task.Message
+	Index   uint64
+	Term    uint64
+	Leader  ID
+	Err     error
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Proposed) IsMessage() {}
+
+// Committed is the message sent to the `Apply` sender of a Raft node when an
+// entry is committed. Entries are sent in order of their index, and all nodes
+// commit the same command at each index.
+type Committed struct {
+	Index   uint64
+	Term    uint64
+	Command task.Message
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Committed) IsMessage() {}
+
+// LeaderChanged is the message sent to the `Events` sender of a Raft node when
+// the node learns of a new leader (including itself).
+type LeaderChanged struct {
+	Node   ID
+	Term   uint64
+	Leader ID
+}
+
+// IsMessage implements the `task.Message` interface.
+func (LeaderChanged) IsMessage() {}
+
+// RequestVote is the message that candidates send to request votes.
+type RequestVote struct {
+	Term      uint64
+	LastIndex uint64
+	LastTerm  uint64
+}
+
+// IsMessage implements the `task.Message` interface.
+func (RequestVote) IsMessage() {}
+
+// Vote is the reply to a `RequestVote` message.
+type Vote struct {
+	Term    uint64
+	Granted bool
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Vote) IsMessage() {}
+
+// AppendEntries is the message that leaders send to replicate their log, and
+// as a heartbeat.
+type AppendEntries struct {
+	Term      uint64
+	PrevIndex uint64
+	PrevTerm  uint64
+	Entries   []Entry
+	Commit    uint64
+}
+
+// IsMessage implements the `task.Message` interface.
+func (AppendEntries) IsMessage() {}
+
+// AppendResult is the reply to an `AppendEntries` message. If it was
+// successful, `Match` is the index of the last entry that the follower knows to
+// match the log of the leader. Otherwise, it is a hint of where the leader
+// should try next.
+type AppendResult struct {
+	Term    uint64
+	Success bool
+	Match   uint64
+}
+
+// IsMessage implements the `task.Message` interface.
+func (AppendResult) IsMessage() {}
+
+// RaftOptions are passed when constructing a `Raft` handler. The `Peers` are
+// all of the other nodes in the cluster. Committed entries are sent to `Apply`,
+// which must accept them (entries that it rejects are not sent again), and
+// `LeaderChanged` messages are sent to `Events`; both are optional.
+//
+// Followers start an election if they do not hear from a leader for a random
+// number of ticks between `ElectionTicks` and twice that, and leaders send
+// heartbeats every `HeartbeatTicks` ticks. The random numbers are generated
+// from `Seed` and the ID of the node, so that nodes with the same seed have
+// different election timeouts.
+type RaftOptions struct {
+	ID             ID
+	Peers          []ID
+	Transport      task.Sender
+	Apply          task.Sender
+	Events         task.Sender
+	ElectionTicks  int
+	HeartbeatTicks int
+	Seed           int64
+}
+
+// RaftStatus is a snapshot of the state of a Raft node.
+type RaftStatus struct {
+	ID        ID
+	Role      Role
+	Term      uint64
+	Leader    ID
+	LastIndex uint64
+	Commit    uint64
+}
+
+// Raft is a `task.Handler` that implements Raft leader election and log
+// replication. It must be sent `Tick` messages periodically, and commands are
+// proposed by sending it `Propose` messages. Once a majority of the nodes have
+// a command in their log, it is committed, and sent to the `Apply` sender of
+// every node.
+//
+// The state of the node is kept in memory, so a node that restarts must
+// rejoin the cluster as a new node. Membership changes and log compaction are
+// not supported.
+type Raft struct {
+	opts  RaftOptions
+	peers []ID
+	rand  *rand.Rand
+
+	mu       *sync.Mutex
+	role     Role
+	term     uint64
+	votedFor ID
+	leader   ID
+	log      []Entry
+	commit   uint64
+	applied  uint64
+	elapsed  int
+	timeout  int
+	votes    map[ID]struct{}
+	next     map[ID]uint64
+	match    map[ID]uint64
+}
+
+// NewRaft returns a `Raft` handler for a node that starts as a follower.
+func NewRaft(opts RaftOptions) *Raft {
+	if opts.ElectionTicks <= 0 {
+		opts.ElectionTicks = DefaultElectionTicks
+	}
+	if opts.HeartbeatTicks <= 0 {
+		opts.HeartbeatTicks = DefaultHeartbeatTicks
+	}
+	raft := &Raft{
+		opts:     opts,
+		peers:    others(opts.ID, opts.Peers),
+		rand:     rand.New(rand.NewSource(opts.Seed + int64(opts.ID))),
+		mu:       new(sync.Mutex),
+		votedFor: None,
+		leader:   None,
+		// The log starts with a sentinel entry, so that the first entry
+		// has index one.
+		log: []Entry{{}},
+	}
+	raft.resetTimeout()
+	return raft
+}
+
+// Status returns the current state of the node. It is safe to call
+// concurrently with `Handle`.
+func (raft *Raft) Status() RaftStatus {
+	raft.mu.Lock()
+	defer raft.mu.Unlock()
+	return RaftStatus{
+		ID:        raft.opts.ID,
+		Role:      raft.role,
+		Term:      raft.term,
+		Leader:    raft.leader,
+		LastIndex: raft.lastIndex(),
+		Commit:    raft.commit,
+	}
+}
+
+// Handle implements the `task.Handler` interface. Messages other than `Tick`
+// and `Propose` messages, and envelopes containing Raft messages, are
+// ignored.
+func (raft *Raft) Handle(_ task.Task, m task.Message) {
+	raft.mu.Lock()
+	defer raft.mu.Unlock()
+
+	switch m := m.(type) {
+	case Tick:
+		raft.tick()
+	case Propose:
+		raft.propose(m)
+	case Envelope:
+		switch msg := m.Message.(type) {
+		case RequestVote:
+			raft.requestVote(m.From, msg)
+		case Vote:
+			raft.vote(m.From, msg)
+		case AppendEntries:
+			raft.appendEntries(m.From, msg)
+		case AppendResult:
+			raft.appendResult(m.From, msg)
+		}
+	}
+}
+
+func (raft *Raft) tick() {
+	raft.elapsed++
+	if raft.role == Leader {
+		if raft.elapsed >= raft.opts.HeartbeatTicks {
+			raft.elapsed = 0
+			for _, peer := range raft.peers {
+				raft.replicate(peer)
+			}
+		}
+		return
+	}
+	if raft.elapsed >= raft.timeout {
+		raft.campaign()
+	}
+}
+
+func (raft *Raft) propose(m Propose) {
+	if raft.role != Leader {
+		raft.reply(m.Reply, Proposed{Command: m.Command, Leader: raft.leader, Err: ErrNotLeader})
+		return
+	}
+	raft.log = append(raft.log, Entry{Term: raft.term, Command: m.Command})
+	raft.reply(m.Reply, Proposed{Command: m.Command, Index: raft.lastIndex(), Term: raft.term, Leader: raft.opts.ID})
+	for _, peer := range raft.peers {
+		raft.replicate(peer)
+	}
+	raft.advance()
+}
+
+// campaign to become the leader in the next term.
+func (raft *Raft) campaign() {
+	raft.term++
+	raft.role = Candidate
+	raft.votedFor = raft.opts.ID
+	raft.leader = None
+	raft.votes = map[ID]struct{}{raft.opts.ID: {}}
+	raft.resetTimeout()
+	if raft.quorum(len(raft.votes)) {
+		raft.becomeLeader()
+		return
+	}
+	for _, peer := range raft.peers {
+		raft.send(peer, RequestVote{Term: raft.term, LastIndex: raft.lastIndex(), LastTerm: raft.lastTerm()})
+	}
+}
+
+func (raft *Raft) becomeLeader() {
+	raft.role = Leader
+	raft.leader = raft.opts.ID
+	raft.elapsed = 0
+	raft.next = map[ID]uint64{}
+	raft.match = map[ID]uint64{}
+	for _, peer := range raft.peers {
+		raft.next[peer] = raft.lastIndex() + 1
+	}
+	raft.emit(LeaderChanged{Node: raft.opts.ID, Term: raft.term, Leader: raft.opts.ID})
+
+	// Entries from earlier terms can only be committed once an entry from
+	// the current term is, so append an empty entry straight away.
+	raft.log = append(raft.log, Entry{Term: raft.term})
+	for _, peer := range raft.peers {
+		raft.replicate(peer)
+	}
+	raft.advance()
+}
+
+// observe the term of a message, and step down if it is newer.
+func (raft *Raft) observe(term uint64) {
+	if term <= raft.term {
+		return
+	}
+	raft.term = term
+	raft.role = Follower
+	raft.votedFor = None
+	raft.leader = None
+	raft.votes = nil
+	raft.resetTimeout()
+}
+
+func (raft *Raft) requestVote(from ID, m RequestVote) {
+	raft.observe(m.Term)
+	upToDate := m.LastTerm > raft.lastTerm() || (m.LastTerm == raft.lastTerm() && m.LastIndex >= raft.lastIndex())
+	granted := m.Term == raft.term && (raft.votedFor == None || raft.votedFor == from) && upToDate
+	if granted {
+		raft.votedFor = from
+		raft.elapsed = 0
+	}
+	raft.send(from, Vote{Term: raft.term, Granted: granted})
+}
+
+func (raft *Raft) vote(from ID, m Vote) {
+	raft.observe(m.Term)
+	if raft.role != Candidate || m.Term != raft.term || !m.Granted {
+		return
+	}
+	raft.votes[from] = struct{}{}
+	if raft.quorum(len(raft.votes)) {
+		raft.becomeLeader()
+	}
+}
+
+func (raft *Raft) appendEntries(from ID, m AppendEntries) {
+	raft.observe(m.Term)
+	if m.Term < raft.term {
+		raft.send(from, AppendResult{Term: raft.term})
+		return
+	}
+	raft.role = Follower
+	raft.elapsed = 0
+	if raft.leader != from {
+		raft.leader = from
+		raft.emit(LeaderChanged{Node: raft.opts.ID, Term: raft.term, Leader: from})
+	}
+
+	if m.PrevIndex > raft.lastIndex() {
+		raft.send(from, AppendResult{Term: raft.term, Match: raft.lastIndex()})
+		return
+	}
+	if raft.log[m.PrevIndex].Term != m.PrevTerm {
+		raft.send(from, AppendResult{Term: raft.term, Match: m.PrevIndex - 1})
+		return
+	}
+	for i, entry := range m.Entries {
+		index := m.PrevIndex + 1 + uint64(i)
+		if index <= raft.lastIndex() {
+			if raft.log[index].Term == entry.Term {
+				continue
+			}
+			// Conflicting entries are never committed, so they can be
+			// removed.
+			raft.log = raft.log[:index]
+		}
+		raft.log = append(raft.log, entry)
+	}
+
+	match := m.PrevIndex + uint64(len(m.Entries))
+	if commit := min(m.Commit, match); commit > raft.commit {
+		raft.commit = commit
+		raft.apply()
+	}
+	raft.send(from, AppendResult{Term: raft.term, Success: true, Match: match})
+}
+
+func (raft *Raft) appendResult(from ID, m AppendResult) {
+	raft.observe(m.Term)
+	if raft.role != Leader || m.Term != raft.term {
+		return
+	}
+	if m.Success {
+		if m.Match > raft.match[from] {
+			raft.match[from] = m.Match
+		}
+		raft.next[from] = raft.match[from] + 1
+		if raft.advance() {
+			// Tell the followers about the commit straight away, instead
+			// of waiting for the next heartbeat.
+			for _, peer := range raft.peers {
+				raft.replicate(peer)
+			}
+		} else if raft.next[from] <= raft.lastIndex() {
+			raft.replicate(from)
+		}
+		return
+	}
+
+	// Back off to the hint, but never below the entries that are known to
+	// match.
+	next := m.Match + 1
+	if next >= raft.next[from] {
+		next = raft.next[from] - 1
+	}
+	if next <= raft.match[from] {
+		next = raft.match[from] + 1
+	}
+	raft.next[from] = next
+	raft.replicate(from)
+}
+
+// replicate the log to a follower, starting from the next entry that it needs.
+func (raft *Raft) replicate(to ID) {
+	prev := raft.next[to] - 1
+	raft.send(to, AppendEntries{
+		Term:      raft.term,
+		PrevIndex: prev,
+		PrevTerm:  raft.log[prev].Term,
+		// The entries are copied, because the log can be truncated and
+		// overwritten while the message is in flight.
+		Entries: append([]Entry(nil), raft.log[prev+1:]...),
+		Commit:  raft.commit,
+	})
+}
+
+// advance the commit index to the latest entry from the current term that a
+// majority of the nodes have. It returns true if the commit index changed.
+func (raft *Raft) advance() bool {
+	for index := raft.lastIndex(); index > raft.commit && raft.log[index].Term == raft.term; index-- {
+		count := 1
+		for _, peer := range raft.peers {
+			if raft.match[peer] >= index {
+				count++
+			}
+		}
+		if raft.quorum(count) {
+			raft.commit = index
+			raft.apply()
+			return true
+		}
+	}
+	return false
+}
+
+// apply the entries that have been committed, but not applied.
+func (raft *Raft) apply() {
+	for raft.applied < raft.commit {
+		raft.applied++
+		entry := raft.log[raft.applied]
+		if entry.Command != nil && raft.opts.Apply != nil {
+			raft.opts.Apply.Send(Committed{Index: raft.applied, Term: entry.Term, Command: entry.Command})
+		}
+	}
+}
+
+func (raft *Raft) quorum(n int) bool {
+	return n > (len(raft.peers)+1)/2
+}
+
+func (raft *Raft) lastIndex() uint64 {
+	return uint64(len(raft.log) - 1)
+}
+
+func (raft *Raft) lastTerm() uint64 {
+	return raft.log[len(raft.log)-1].Term
+}
+
+func (raft *Raft) resetTimeout() {
+	raft.elapsed = 0
+	raft.timeout = raft.opts.ElectionTicks + raft.rand.Intn(raft.opts.ElectionTicks)
+}
+
+func (raft *Raft) send(to ID, m task.Message) {
+	raft.opts.Transport.Send(Envelope{From: raft.opts.ID, To: to, Message: m})
+}
+
+func (raft *Raft) reply(to task.Sender, m task.Message) {
+	if to != nil {
+		to.Send(m)
+	}
+}
+
+func (raft *Raft) emit(m task.Message) {
+	if raft.opts.Events != nil {
+		raft.opts.Events.Send(m)
+	}
+}
+
+func min(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,229 @@
+package consensus_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/consensus"
+
+	"github.com/renproject/phi/task"
+)
+
+var _ = Describe("Raft", func() {
+
+	var sim *Sim
+	var nodes map[ID]*Raft
+	var applied map[ID]*inbox
+	var events *inbox
+
+	setup := func(opts SimOptions, n int) {
+		sim = NewSim(opts)
+		nodes = map[ID]*Raft{}
+		applied = map[ID]*inbox{}
+		events = newInbox()
+		ids := make([]ID, n)
+		for i := range ids {
+			ids[i] = ID(i)
+		}
+		for _, id := range ids {
+			applied[id] = newInbox()
+			nodes[id] = NewRaft(RaftOptions{
+				ID:        id,
+				Peers:     ids,
+				Transport: sim,
+				Apply:     applied[id],
+				Events:    events,
+			})
+			sim.Add(id, nodes[id])
+		}
+	}
+
+	run := func(ticks int) {
+		for i := 0; i < ticks; i++ {
+			sim.Tick()
+			sim.Flush()
+		}
+	}
+
+	// leader returns the leader with the highest term, or `None`.
+	leader := func(ids ...ID) ID {
+		result, term := None, uint64(0)
+		for _, id := range ids {
+			if status := nodes[id].Status(); status.Role == Leader && status.Term >= term {
+				result, term = id, status.Term
+			}
+		}
+		return result
+	}
+
+	commands := func(id ID) []task.Message {
+		commands := []task.Message{}
+		for _, m := range applied[id].get() {
+			commands = append(commands, m.(Committed).Command)
+		}
+		return commands
+	}
+
+	// consistent checks that every node has applied a prefix of the same
+	// sequence of entries, and returns the longest sequence.
+	consistent := func() []task.Message {
+		longest := []task.Message{}
+		for id := range nodes {
+			if entries := applied[id].get(); len(entries) > len(longest) {
+				longest = entries
+			}
+		}
+		for id := range nodes {
+			entries := applied[id].get()
+			Expect(entries).To(Equal(longest[:len(entries)]))
+		}
+		return longest
+	}
+
+	// leaders checks that there was at most one leader in each term.
+	leaders := func() {
+		byTerm := map[uint64]ID{}
+		for _, m := range events.get() {
+			ev := m.(LeaderChanged)
+			if leader, ok := byTerm[ev.Term]; ok {
+				Expect(ev.Leader).To(Equal(leader))
+			}
+			byTerm[ev.Term] = ev.Leader
+		}
+	}
+
+	Context("when the network is reliable", func() {
+		It("should elect one leader", func() {
+			setup(SimOptions{}, 5)
+			run(2 * DefaultElectionTicks)
+
+			id := leader(sim.Nodes()...)
+			Expect(id).ToNot(Equal(None))
+			for _, node := range nodes {
+				status := node.Status()
+				Expect(status.Leader).To(Equal(id))
+				Expect(status.Term).To(Equal(nodes[id].Status().Term))
+				if status.ID != id {
+					Expect(status.Role).To(Equal(Follower))
+				}
+			}
+			Expect(events.get()).To(ContainElement(LeaderChanged{Node: id, Term: nodes[id].Status().Term, Leader: id}))
+			leaders()
+		})
+
+		It("should replicate and apply commands in order", func() {
+			setup(SimOptions{}, 3)
+			run(2 * DefaultElectionTicks)
+			id := leader(sim.Nodes()...)
+			follower := (id + 1) % 3
+
+			replies := newInbox()
+			sim.Input(follower, Propose{Command: msg(0), Reply: replies})
+			Expect(replies.get()).To(Equal([]task.Message{
+				Proposed{Command: msg(0), Leader: id, Err: ErrNotLeader},
+			}))
+
+			for i := 1; i <= 5; i++ {
+				sim.Input(id, Propose{Command: msg(i)})
+			}
+			sim.Flush()
+			for node := range nodes {
+				Expect(commands(node)).To(Equal([]task.Message{msg(1), msg(2), msg(3), msg(4), msg(5)}))
+			}
+
+			// The first entry in the log is the empty entry of the leader.
+			status := nodes[id].Status()
+			Expect(status.LastIndex).To(Equal(uint64(6)))
+			Expect(status.Commit).To(Equal(uint64(6)))
+			Expect(applied[follower].get()[0]).To(Equal(Committed{Index: 2, Term: status.Term, Command: msg(1)}))
+		})
+
+		It("should run a cluster with a single node", func() {
+			setup(SimOptions{}, 1)
+			run(2 * DefaultElectionTicks)
+			Expect(leader(0)).To(Equal(ID(0)))
+
+			replies := newInbox()
+			sim.Input(0, Propose{Command: msg(1), Reply: replies})
+			Expect(replies.get()).To(Equal([]task.Message{
+				Proposed{Command: msg(1), Index: 2, Term: 1, Leader: 0},
+			}))
+			Expect(commands(0)).To(Equal([]task.Message{msg(1)}))
+		})
+	})
+
+	Context("when the leader is partitioned", func() {
+		It("should elect a new leader, and discard uncommitted commands", func() {
+			setup(SimOptions{}, 5)
+			run(2 * DefaultElectionTicks)
+			old := leader(sim.Nodes()...)
+			sim.Input(old, Propose{Command: msg(1)})
+			sim.Flush()
+
+			// Isolate the leader with one follower, so that it cannot commit.
+			minority := []ID{old, (old + 1) % 5}
+			majority := []ID{(old + 2) % 5, (old + 3) % 5, (old + 4) % 5}
+			sim.Partition(minority, majority)
+			sim.Input(old, Propose{Command: msg(2)})
+			run(3 * DefaultElectionTicks)
+
+			current := leader(majority...)
+			Expect(current).ToNot(Equal(None))
+			Expect(nodes[current].Status().Term).To(BeNumerically(">", nodes[old].Status().Term))
+			sim.Input(current, Propose{Command: msg(3)})
+			run(1)
+			for _, id := range majority {
+				Expect(commands(id)).To(Equal([]task.Message{msg(1), msg(3)}))
+			}
+			Expect(commands(old)).To(Equal([]task.Message{msg(1)}))
+
+			sim.Heal()
+			run(3)
+			Expect(nodes[old].Status().Role).To(Equal(Follower))
+			Expect(nodes[old].Status().Leader).To(Equal(current))
+			for id := range nodes {
+				Expect(commands(id)).To(Equal([]task.Message{msg(1), msg(3)}))
+			}
+			leaders()
+		})
+	})
+
+	Context("when the network is unreliable", func() {
+		It("should apply the same commands on every node", func() {
+			setup(SimOptions{Seed: 3, Loss: 0.2, Duplicate: 0.2, Reorder: true}, 5)
+			proposed := 0
+			for i := 0; i < 300; i++ {
+				if i%10 == 0 {
+					sim.Crash(ID(i / 10 % 5))
+				}
+				if i%10 == 5 {
+					sim.Recover(ID(i / 10 % 5))
+				}
+				if id := leader(sim.Nodes()...); id != None && i%3 == 0 {
+					sim.Input(id, Propose{Command: msg(proposed)})
+					proposed++
+				}
+				run(1)
+			}
+			sim.Heal()
+			for id := range nodes {
+				sim.Recover(id)
+			}
+			run(5 * DefaultElectionTicks)
+
+			Expect(len(consistent())).To(BeNumerically(">", proposed/2))
+			for id := range nodes {
+				Expect(nodes[id].Status().Commit).To(Equal(nodes[leader(sim.Nodes()...)].Status().Commit))
+			}
+			leaders()
+		})
+	})
+
+	Context("when printing roles", func() {
+		It("should return their names", func() {
+			Expect(Follower.String()).To(Equal("follower"))
+			Expect(Candidate.String()).To(Equal("candidate"))
+			Expect(Leader.String()).To(Equal("leader"))
+			Expect(Role(3).String()).To(Equal("unknown"))
+		})
+	})
+})
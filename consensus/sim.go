@@ -0,0 +1,187 @@
+package consensus
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/renproject/phi/task"
+)
+
+// SimOptions are passed when constructing a `Sim`. Every envelope that is sent
+// is lost with probability `Loss`, and (if it is not lost) duplicated with
+// probability `Duplicate`. If `Reorder` is true, pending envelopes are
+// delivered in a random order, instead of the order in which they were sent.
+// All random choices are made using a source seeded with `Seed`, so a
+// simulation with the same options and inputs always runs the same way.
+type SimOptions struct {
+	Seed      int64
+	Loss      float64
+	Duplicate float64
+	Reorder   bool
+}
+
+// A Sim is a transport that simulates a network of nodes deterministically.
+// Envelopes that are sent to it are not delivered until `Step` (or `Flush`) is
+// called, and they are delivered by calling the handler of the addressed node
+// directly, in the calling goroutine. Because the handlers are not run by
+// tasks, they are given a nil `task.Task`, and must not use it.
+//
+// Network faults can be injected by setting `SimOptions`, partitioning the
+// network, and crashing nodes. A Sim is not safe for concurrent use, but
+// handlers can (and should) send envelopes to it while they are being called
+// by it.
+type Sim struct {
+	opts    SimOptions
+	rand    *rand.Rand
+	nodes   map[ID]task.Handler
+	pending []Envelope
+	groups  map[ID]int
+	crashed map[ID]bool
+}
+
+// NewSim returns a `Sim` with no nodes.
+func NewSim(opts SimOptions) *Sim {
+	return &Sim{
+		opts:    opts,
+		rand:    rand.New(rand.NewSource(opts.Seed)),
+		nodes:   map[ID]task.Handler{},
+		groups:  map[ID]int{},
+		crashed: map[ID]bool{},
+	}
+}
+
+// Add a node to the simulation.
+func (sim *Sim) Add(id ID, node task.Handler) {
+	sim.nodes[id] = node
+}
+
+// Nodes returns the IDs of the nodes in the simulation, in ascending order.
+func (sim *Sim) Nodes() []ID {
+	ids := make([]ID, 0, len(sim.nodes))
+	for id := range sim.nodes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Send implements the `task.Sender` interface. The envelope is queued for
+// delivery, unless it is lost, or its sender and recipient cannot communicate.
+// Like a real network, lost envelopes are accepted. Messages that are not
+// envelopes, or that are addressed to unknown nodes, are rejected.
+func (sim *Sim) Send(m task.Message) bool {
+	env, ok := m.(Envelope)
+	if !ok {
+		return false
+	}
+	if _, ok := sim.nodes[env.To]; !ok {
+		return false
+	}
+	if !sim.connected(env.From, env.To) || sim.roll(sim.opts.Loss) {
+		return true
+	}
+	sim.pending = append(sim.pending, env)
+	if sim.roll(sim.opts.Duplicate) {
+		sim.pending = append(sim.pending, env)
+	}
+	return true
+}
+
+// Input sends a message directly to the handler of a node, as if it had been
+// sent to the task of the node by something other than another node (for
+// example, a client). It returns false if the node does not exist or has
+// crashed.
+func (sim *Sim) Input(id ID, m task.Message) bool {
+	node, ok := sim.nodes[id]
+	if !ok || sim.crashed[id] {
+		return false
+	}
+	node.Handle(nil, m)
+	return true
+}
+
+// Tick sends a `Tick` to every node that has not crashed, in ascending order
+// of ID.
+func (sim *Sim) Tick() {
+	for _, id := range sim.Nodes() {
+		sim.Input(id, Tick{})
+	}
+}
+
+// Step delivers one pending envelope. Envelopes addressed to nodes that have
+// crashed, or that cannot communicate with the sender (because the network
+// was partitioned after the envelope was sent), are dropped instead. It
+// returns false if there were no pending envelopes.
+func (sim *Sim) Step() bool {
+	if len(sim.pending) == 0 {
+		return false
+	}
+	i := 0
+	if sim.opts.Reorder {
+		i = sim.rand.Intn(len(sim.pending))
+	}
+	env := sim.pending[i]
+	copy(sim.pending[i:], sim.pending[i+1:])
+	sim.pending[len(sim.pending)-1] = Envelope{}
+	sim.pending = sim.pending[:len(sim.pending)-1]
+
+	if sim.connected(env.From, env.To) {
+		sim.Input(env.To, env)
+	}
+	return true
+}
+
+// Flush delivers pending envelopes, including the envelopes that are sent
+// while doing so, until there are none left. It returns the number of
+// envelopes that were delivered (or dropped).
+func (sim *Sim) Flush() int {
+	n := 0
+	for sim.Step() {
+		n++
+	}
+	return n
+}
+
+// Pending returns the number of envelopes waiting to be delivered.
+func (sim *Sim) Pending() int {
+	return len(sim.pending)
+}
+
+// Partition the network into groups of nodes. Nodes can only communicate with
+// nodes in the same group. Nodes that are not in any of the groups form a
+// group of their own. Partitioning the network replaces any previous
+// partition.
+func (sim *Sim) Partition(groups ...[]ID) {
+	sim.groups = map[ID]int{}
+	for i, group := range groups {
+		for _, id := range group {
+			sim.groups[id] = i + 1
+		}
+	}
+}
+
+// Heal the network, so that all nodes can communicate with each other again.
+func (sim *Sim) Heal() {
+	sim.groups = map[ID]int{}
+}
+
+// Crash a node. A crashed node does not receive any messages (including
+// ticks), and envelopes that it sent, but that have not been delivered, are
+// dropped.
+func (sim *Sim) Crash(id ID) {
+	sim.crashed[id] = true
+}
+
+// Recover a node that has crashed. The node keeps the state that it had when it
+// crashed, as if it had been paused.
+func (sim *Sim) Recover(id ID) {
+	delete(sim.crashed, id)
+}
+
+func (sim *Sim) connected(from, to ID) bool {
+	return !sim.crashed[from] && !sim.crashed[to] && sim.groups[from] == sim.groups[to]
+}
+
+func (sim *Sim) roll(p float64) bool {
+	return p > 0 && sim.rand.Float64() < p
+}
@@ -0,0 +1,185 @@
+package consensus_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/consensus"
+
+	"github.com/renproject/phi/task"
+)
+
+// inbox is a `task.Sender` and `task.Handler` that records the messages that
+// it receives.
+type inbox struct {
+	mu       *sync.Mutex
+	messages []task.Message
+}
+
+func newInbox() *inbox {
+	return &inbox{mu: new(sync.Mutex)}
+}
+
+func (in *inbox) Send(m task.Message) bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.messages = append(in.messages, m)
+	return true
+}
+
+func (in *inbox) Handle(_ task.Task, m task.Message) {
+	in.Send(m)
+}
+
+func (in *inbox) get() []task.Message {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return append([]task.Message{}, in.messages...)
+}
+
+// msg is the message that is broadcast by the tests.
+type msg int
+
+func (msg) IsMessage() {}
+
+func envelope(from, to ID, m int) Envelope {
+	return Envelope{From: from, To: to, Message: msg(m)}
+}
+
+var _ = Describe("Simulations", func() {
+
+	var sim *Sim
+	var a, b, c *inbox
+
+	setup := func(opts SimOptions) {
+		sim = NewSim(opts)
+		a, b, c = newInbox(), newInbox(), newInbox()
+		sim.Add(0, a)
+		sim.Add(1, b)
+		sim.Add(2, c)
+	}
+
+	Context("when sending envelopes", func() {
+		It("should deliver them in order when stepped", func() {
+			setup(SimOptions{})
+			Expect(sim.Nodes()).To(Equal([]ID{0, 1, 2}))
+			Expect(sim.Send(envelope(0, 1, 1))).To(BeTrue())
+			Expect(sim.Send(envelope(0, 1, 2))).To(BeTrue())
+			Expect(b.get()).To(BeEmpty())
+			Expect(sim.Pending()).To(Equal(2))
+
+			Expect(sim.Step()).To(BeTrue())
+			Expect(b.get()).To(Equal([]task.Message{envelope(0, 1, 1)}))
+			Expect(sim.Flush()).To(Equal(1))
+			Expect(b.get()).To(Equal([]task.Message{envelope(0, 1, 1), envelope(0, 1, 2)}))
+			Expect(sim.Step()).To(BeFalse())
+		})
+
+		It("should reject messages that are not envelopes or have unknown recipients", func() {
+			setup(SimOptions{})
+			Expect(sim.Send(msg(1))).To(BeFalse())
+			Expect(sim.Send(envelope(0, 3, 1))).To(BeFalse())
+			Expect(sim.Pending()).To(Equal(0))
+		})
+
+		It("should send inputs and ticks directly to the nodes", func() {
+			setup(SimOptions{})
+			Expect(sim.Input(0, msg(1))).To(BeTrue())
+			Expect(sim.Input(3, msg(1))).To(BeFalse())
+			sim.Tick()
+			Expect(a.get()).To(Equal([]task.Message{msg(1), Tick{}}))
+			Expect(b.get()).To(Equal([]task.Message{Tick{}}))
+		})
+	})
+
+	Context("when injecting faults", func() {
+		It("should lose and duplicate envelopes", func() {
+			setup(SimOptions{Loss: 1})
+			Expect(sim.Send(envelope(0, 1, 1))).To(BeTrue())
+			Expect(sim.Pending()).To(Equal(0))
+
+			setup(SimOptions{Duplicate: 1})
+			sim.Send(envelope(0, 1, 1))
+			sim.Flush()
+			Expect(b.get()).To(HaveLen(2))
+		})
+
+		It("should reorder envelopes deterministically", func() {
+			order := func(seed int64) []task.Message {
+				setup(SimOptions{Seed: seed, Reorder: true})
+				for i := 0; i < 20; i++ {
+					sim.Send(envelope(0, 1, i))
+				}
+				sim.Flush()
+				return b.get()
+			}
+			first := order(1)
+			Expect(first).To(HaveLen(20))
+			Expect(order(1)).To(Equal(first))
+			Expect(order(2)).ToNot(Equal(first))
+		})
+
+		It("should only deliver envelopes within partitions", func() {
+			setup(SimOptions{})
+			sim.Partition([]ID{0, 1})
+			sim.Send(envelope(0, 1, 1))
+			sim.Send(envelope(0, 2, 2))
+			sim.Send(envelope(2, 0, 3))
+			sim.Flush()
+			Expect(a.get()).To(BeEmpty())
+			Expect(b.get()).To(Equal([]task.Message{envelope(0, 1, 1)}))
+			Expect(c.get()).To(BeEmpty())
+
+			// Envelopes in flight are dropped when the network is
+			// partitioned.
+			sim.Send(envelope(1, 0, 4))
+			sim.Partition([]ID{0}, []ID{1})
+			sim.Flush()
+			Expect(a.get()).To(BeEmpty())
+
+			sim.Heal()
+			sim.Send(envelope(2, 0, 5))
+			sim.Flush()
+			Expect(a.get()).To(Equal([]task.Message{envelope(2, 0, 5)}))
+		})
+
+		It("should not deliver anything to crashed nodes", func() {
+			setup(SimOptions{})
+			sim.Send(envelope(0, 1, 1))
+			sim.Crash(1)
+			sim.Send(envelope(0, 1, 2))
+			sim.Flush()
+			sim.Tick()
+			Expect(sim.Input(1, msg(3))).To(BeFalse())
+			Expect(b.get()).To(BeEmpty())
+
+			sim.Recover(1)
+			sim.Send(envelope(0, 1, 4))
+			sim.Flush()
+			Expect(b.get()).To(Equal([]task.Message{envelope(0, 1, 4)}))
+		})
+	})
+})
+
+var _ = Describe("Networks", func() {
+	It("should route envelopes to tasks", func() {
+		network := NewNetwork()
+		recipient := newInbox()
+		t := task.New(recipient, task.Options{Cap: 1})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go t.Run(ctx)
+
+		network.Add(1, t)
+		Expect(network.Send(msg(1))).To(BeFalse())
+		Expect(network.Send(envelope(0, 2, 1))).To(BeFalse())
+		Expect(network.Send(envelope(0, 1, 1))).To(BeTrue())
+		Eventually(recipient.get, time.Second).Should(Equal([]task.Message{envelope(0, 1, 1)}))
+
+		network.Remove(1)
+		Expect(network.Send(envelope(0, 1, 2))).To(BeFalse())
+	})
+})
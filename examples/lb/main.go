@@ -31,10 +31,13 @@ func main() {
 	user, done := NewUser(lbTask, n)
 	userTask := phi.New(&user, userOpts)
 
-	// Run the tasks
-	ctx := context.Background()
-	go lbTask.Run(ctx)
-	go userTask.Run(ctx)
+	// Run the tasks together
+	ctx, cancel := context.WithCancel(context.Background())
+	group := phi.NewGroup(lbTask, userTask)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- group.Wait(ctx)
+	}()
 
 	// Send requests to the user
 	start := time.Now()
@@ -48,6 +51,12 @@ func main() {
 	// Wait until the user has finished
 	<-done
 
+	// Stop the tasks, and wait for them to exit
+	cancel()
+	if err := <-stopped; err != nil {
+		panic(err)
+	}
+
 	// Execution should take just over 1 second
 	elapsed := time.Since(start)
 	if elapsed > 2*time.Second {
@@ -17,6 +17,7 @@ import (
 	"time"
 
 	"github.com/renproject/phi"
+	"github.com/renproject/phi/topology"
 )
 
 func main() {
@@ -50,15 +51,19 @@ func main() {
 	for i, player := range players {
 		playerMap[player.ID()] = playerTasks[i]
 	}
-	router, results := NewRouter(ringTopology(numPlayers), playerMap)
+	router, results := NewRouter(topology.Ring(int(numPlayers)), playerMap)
 	routerTask := phi.New(&router, routerOpts)
 
-	// Start the tasks
-	ctx := context.Background()
+	// Start the tasks together
+	ctx, cancel := context.WithCancel(context.Background())
+	group := phi.NewGroup(routerTask)
 	for _, player := range playerTasks {
-		go player.Run(ctx)
+		group.Add(player)
 	}
-	go routerTask.Run(ctx)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- group.Wait(ctx)
+	}()
 
 	// Send the initial message
 	routerTask.Send(BeginRouter{})
@@ -71,20 +76,10 @@ func main() {
 		fmt.Println("Failed!")
 		os.Exit(1)
 	}
-}
 
-func ringTopology(numPlayers uint) map[uint][]uint {
-	routeTable := map[uint][]uint{}
-	for i := uint(0); i < numPlayers; i++ {
-		var prev, next uint
-		if i == 0 {
-			prev, next = numPlayers-1, 1
-		} else if i == numPlayers-1 {
-			prev, next = numPlayers-2, 0
-		} else {
-			prev, next = i-1, i+1
-		}
-		routeTable[i] = []uint{prev, next}
+	// Stop the tasks, and wait for them to exit
+	cancel()
+	if err := <-stopped; err != nil {
+		panic(err)
 	}
-	return routeTable
 }
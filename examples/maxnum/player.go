@@ -32,19 +32,23 @@ func (player *Player) Handle(_ phi.Task, message phi.Message) {
 	case Begin:
 		message.Responder <- PlayerNum{from: player.id, player: player.id, num: player.num}
 	case PlayerNum:
-		if _, ok := player.seen[message.player]; !ok {
-			player.seen[message.player] = message.num
-			message.from = player.id
-			if message.num > player.currentMax {
-				player.currentMax = message.num
-			}
-			if uint(len(player.seen)) == player.players {
-				done := Done{player: player.id, max: player.currentMax}
-				message.Responder <- phi.Messages{message, done}
-			}
-			message.Responder <- message
+		// The router reads exactly one response, so exactly one response must
+		// be written, otherwise the player blocks forever
+		if _, ok := player.seen[message.player]; ok {
+			message.Responder <- phi.Messages{}
+			return
 		}
-		message.Responder <- phi.Messages{}
+		player.seen[message.player] = message.num
+		message.from = player.id
+		if message.num > player.currentMax {
+			player.currentMax = message.num
+		}
+		if uint(len(player.seen)) == player.players {
+			done := Done{player: player.id, max: player.currentMax}
+			message.Responder <- phi.Messages{message, done}
+			return
+		}
+		message.Responder <- message
 	default:
 		panic(fmt.Sprintf("unexpected message type %T", message))
 	}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/renproject/phi"
+	"github.com/renproject/phi/topology"
 )
 
 // Result contains the information relevant to a completed execution of the
@@ -17,7 +18,7 @@ type Result struct {
 // A Router is responsible for routing messages between the players.
 type Router struct {
 	players             map[uint]phi.Task
-	routeTable          map[uint][]uint
+	routeTable          topology.Graph
 	result, resultsSeen uint
 	resultWriter        chan Result
 	terminated          bool
@@ -32,7 +33,7 @@ type Router struct {
 // be sent to index 0. For this to be the case, index 0 will need to be an
 // element of `routeTable[1]`. For the algorithm to terminate, it is required
 // that the network is connected.
-func NewRouter(routeTable map[uint][]uint, players map[uint]phi.Task) (Router, chan Result) {
+func NewRouter(routeTable topology.Graph, players map[uint]phi.Task) (Router, chan Result) {
 	resultWriter := make(chan Result, 1)
 	return Router{
 		players:      players,
@@ -3,6 +3,7 @@ package main
 
 import (
 	"context"
+	"log"
 	"os"
 	"time"
 
@@ -10,17 +11,22 @@ import (
 )
 
 func main() {
+	// Log runtime events, such as dropped messages, from all tasks
+	phi.SetDefaultLogger(phi.NewStdLogger(log.Default(), phi.LevelInfo))
+
 	// Create the pinger and ponger tasks
-	opts := phi.Options{Cap: 1}
 	ponger := NewPonger()
-	pongerTask := phi.New(&ponger, opts)
+	pongerTask := phi.New(&ponger, phi.Options{Name: "ponger", Cap: 1})
 	pinger, done := NewPerpetualPinger(pongerTask, 10)
-	pingerTask := phi.New(&pinger, opts)
+	pingerTask := phi.New(&pinger, phi.Options{Name: "pinger", Cap: 1})
 
-	// Run the tasks
-	ctx := context.Background()
-	go pingerTask.Run(ctx)
-	go pongerTask.Run(ctx)
+	// Run the tasks together
+	ctx, cancel := context.WithCancel(context.Background())
+	group := phi.NewGroup(pingerTask, pongerTask)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- group.Wait(ctx)
+	}()
 
 	// Start the communication and run for some time
 	pingerTask.Send(Begin{})
@@ -30,6 +36,11 @@ func main() {
 	case <-time.After(time.Second):
 		os.Exit(1)
 	case <-done:
-		os.Exit(0)
+	}
+
+	// Stop the tasks, and wait for them to exit
+	cancel()
+	if err := <-stopped; err != nil {
+		panic(err)
 	}
 }
@@ -37,11 +37,12 @@ func main() {
 
 	// Start the tasks. Notice that a resolver is just a `phi.Sender`, and
 	// hence does not need to be (and indeed cannot be) run.
-	ctx := context.Background()
-	go aTask.Run(ctx)
-	go bTask.Run(ctx)
-	go cTask.Run(ctx)
-	go userTask.Run(ctx)
+	ctx, cancel := context.WithCancel(context.Background())
+	group := phi.NewGroup(aTask, bTask, cTask, userTask)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- group.Wait(ctx)
+	}()
 
 	// Send a message that should be routed to each of the three destinations.
 	var ok bool
@@ -64,8 +65,13 @@ func main() {
 		if !result {
 			os.Exit(1)
 		}
-		return
 	case <-time.After(time.Second):
 		os.Exit(1)
 	}
+
+	// Stop the tasks, and wait for them to exit
+	cancel()
+	if err := <-stopped; err != nil {
+		panic(err)
+	}
 }
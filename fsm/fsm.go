@@ -0,0 +1,393 @@
+// Package fsm provides a `task.Handler` whose behaviour is defined by a finite
+// state machine. Instead of tracking flags to decide how to react to a
+// message, the behaviour of the handler is declared per state, as a set of
+// transitions from message types to states.
+package fsm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/renproject/phi/task"
+)
+
+// Policy determines what a `Machine` does with a message that the current
+// state has no transition for.
+type Policy int
+
+const (
+	// DropUnhandled messages.
+	DropUnhandled Policy = iota
+	// PanicUnhandled messages.
+	PanicUnhandled
+)
+
+// Start is a message that makes a `Machine` enter its initial state. Sending
+// it is optional; a machine that has not been started will enter its initial
+// state before handling its first message. It is useful when the initial state
+// has an entry action, or a timeout, that should not wait for another message.
+// Once the machine has started, the message is ignored.
+type Start struct{}
+
+// IsMessage implements the `task.Message` interface.
+func (Start) IsMessage() {}
+
+// timeout is the message that a `Machine` sends to itself when the timeout of
+// a state expires. The generation identifies the entry into the state that
+// started the timeout, so that timeouts from earlier entries are ignored.
+type timeout struct {
+	machine    interface{}
+	generation uint64
+}
+
+// IsMessage implements the `task.Message` interface.
+func (timeout) IsMessage() {}
+
+// timeoutRetry is how long a `Machine` waits before resending a timeout that
+// its task did not accept (because its buffer was full).
+const timeoutRetry = 10 * time.Millisecond
+
+// A transition from one state to another, on receipt of a message.
+type transition[S comparable] struct {
+	msgType reflect.Type
+
+	// The state to transition to. For dynamic transitions, the `decide`
+	// function chooses the state, and the targets are only used to draw the
+	// state graph.
+	to      S
+	action  func(task.Task, task.Message)
+	decide  func(task.Task, task.Message) S
+	targets []S
+}
+
+// state is the definition of the behaviour of a `Machine` in one state.
+type state[S comparable] struct {
+	name        S
+	transitions []*transition[S]
+	byType      map[reflect.Type]*transition[S]
+	enter, exit func(task.Task)
+
+	// The state is left for the timeout state if no transition to another
+	// state happens within the timeout duration.
+	timeout      time.Duration
+	timeoutState S
+}
+
+// A Machine is a `task.Handler` defined by a finite state machine with states
+// of type `S`. In each state, messages are handled by the transition declared
+// for their type in that state. A transition runs its action and then, if the
+// state changes, the exit action of the old state and the entry action of the
+// new state. Transitions to the current state do not run the exit and entry
+// actions, but a timeout to the current state starts the timeout again.
+//
+// Like any stateful handler, a machine must only be used by a task with a
+// `Scale` less than 2. It must not be shared between tasks. Only `Current` is
+// safe to call from other goroutines.
+type Machine[S comparable] struct {
+	initial     S
+	states      []*state[S]
+	byName      map[S]*state[S]
+	clock       task.Clock
+	policy      Policy
+	onUnhandled func(task.Task, S, task.Message)
+
+	// The current state is only changed by the task of the machine, but it is
+	// guarded so that it can be read from other goroutines.
+	mu      *sync.Mutex
+	started bool
+	current S
+
+	// The generation is incremented every time a state is entered, and stop
+	// is closed when the state is left, to cancel its timeout.
+	generation uint64
+	stop       chan struct{}
+}
+
+// New returns a `Machine` that starts in the initial state. By default,
+// unhandled messages are dropped, and timeouts are measured by the
+// `task.SystemClock`.
+func New[S comparable](initial S) *Machine[S] {
+	machine := &Machine[S]{
+		initial: initial,
+		byName:  map[S]*state[S]{},
+		clock:   task.SystemClock,
+		policy:  DropUnhandled,
+		mu:      new(sync.Mutex),
+		current: initial,
+	}
+	machine.State(initial)
+	return machine
+}
+
+// WithClock sets the clock used to measure the timeouts of states.
+func (machine *Machine[S]) WithClock(clock task.Clock) *Machine[S] {
+	machine.clock = clock
+	return machine
+}
+
+// Unhandled sets the policy for messages that the current state has no
+// transition for. If `f` is not nil, it is called with each unhandled message
+// (and the current state) before the policy is applied.
+func (machine *Machine[S]) Unhandled(policy Policy, f func(self task.Task, current S, m task.Message)) *Machine[S] {
+	machine.policy = policy
+	machine.onUnhandled = f
+	return machine
+}
+
+// State returns a builder that declares the behaviour of the machine in a
+// state. Declaring the same state more than once adds to its behaviour.
+func (machine *Machine[S]) State(name S) *StateBuilder[S] {
+	def, ok := machine.byName[name]
+	if !ok {
+		def = &state[S]{name: name, byType: map[reflect.Type]*transition[S]{}}
+		machine.states = append(machine.states, def)
+		machine.byName[name] = def
+	}
+	return &StateBuilder[S]{machine: machine, state: def}
+}
+
+// Current returns the current state of the machine.
+func (machine *Machine[S]) Current() S {
+	machine.mu.Lock()
+	defer machine.mu.Unlock()
+	return machine.current
+}
+
+// Handle implements the `task.Handler` interface.
+func (machine *Machine[S]) Handle(self task.Task, m task.Message) {
+	if !machine.started {
+		machine.started = true
+		machine.enter(self, machine.lookup(machine.initial))
+	}
+
+	switch m := m.(type) {
+	case Start:
+		return
+	case timeout:
+		if m.machine != machine || m.generation != machine.generation {
+			// The timeout is from an earlier entry into the state, or from
+			// another machine, so it has expired.
+			return
+		}
+		def := machine.byName[machine.current]
+		if def.timeoutState == machine.current {
+			// The state has timed out to itself, so it is not left, but its
+			// timeout starts again.
+			machine.arm(self, def)
+			return
+		}
+		machine.transition(self, def.timeoutState)
+		return
+	}
+
+	def := machine.byName[machine.current]
+	t, ok := def.byType[reflect.TypeOf(m)]
+	if !ok {
+		if machine.onUnhandled != nil {
+			machine.onUnhandled(self, machine.current, m)
+		}
+		if machine.policy == PanicUnhandled {
+			panic(fmt.Sprintf("fsm error: unhandled message %T in state %v", m, machine.current))
+		}
+		return
+	}
+
+	to := t.to
+	if t.decide != nil {
+		to = t.decide(self, m)
+	} else if t.action != nil {
+		t.action(self, m)
+	}
+	machine.transition(self, to)
+}
+
+// transition to a state, running the exit action of the current state and the
+// entry action of the new state, unless they are the same state. The new state
+// is checked before the current state is left, so that a transition to an
+// undeclared state panics without leaving the machine half way between states.
+func (machine *Machine[S]) transition(self task.Task, to S) {
+	if to == machine.current {
+		return
+	}
+	next := machine.lookup(to)
+	def := machine.byName[machine.current]
+	machine.disarm()
+	if def.exit != nil {
+		def.exit(self)
+	}
+	machine.enter(self, next)
+}
+
+// enter a state, running its entry action and starting its timeout.
+func (machine *Machine[S]) enter(self task.Task, def *state[S]) {
+	machine.mu.Lock()
+	machine.current = def.name
+	machine.mu.Unlock()
+	machine.arm(self, def)
+	if def.enter != nil {
+		def.enter(self)
+	}
+}
+
+// lookup the definition of a state. It panics if the state has not been
+// declared.
+func (machine *Machine[S]) lookup(name S) *state[S] {
+	def, ok := machine.byName[name]
+	if !ok {
+		panic(fmt.Sprintf("fsm error: transition to undeclared state %v", name))
+	}
+	return def
+}
+
+// arm the timeout of a state, cancelling any earlier timeout. Timeouts from
+// earlier entries into the state, that have already been sent, are ignored
+// because the generation has changed.
+func (machine *Machine[S]) arm(self task.Task, def *state[S]) {
+	machine.disarm()
+	machine.generation++
+	if def.timeout > 0 {
+		machine.stop = make(chan struct{})
+		go machine.wait(self, def.timeout, timeout{machine: machine, generation: machine.generation}, machine.stop)
+	}
+}
+
+// disarm the timeout of the current state, if it has one.
+func (machine *Machine[S]) disarm() {
+	if machine.stop != nil {
+		close(machine.stop)
+		machine.stop = nil
+	}
+}
+
+// wait for a timeout to expire, and then send the timeout message to the task
+// of the machine, unless the state is left first, or the task terminates.
+func (machine *Machine[S]) wait(self task.Task, d time.Duration, m timeout, stop <-chan struct{}) {
+	select {
+	case <-stop:
+		return
+	case <-machine.clock.After(d):
+	}
+	task.Resend(machine.clock, self, m, timeoutRetry, stop)
+}
+
+// WriteDOT writes the state graph of the machine in the DOT language (used by
+// Graphviz). Each transition is an edge labelled with its message type; the
+// edges of dynamic transitions are dashed, and the edges of timeouts are
+// dotted.
+func (machine *Machine[S]) WriteDOT(w io.Writer) error {
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "digraph fsm {")
+	fmt.Fprintln(buf, "\t__start [shape=point];")
+	for _, def := range machine.states {
+		fmt.Fprintf(buf, "\t%v;\n", quote(def.name))
+	}
+	fmt.Fprintf(buf, "\t__start -> %v;\n", quote(machine.initial))
+	for _, def := range machine.states {
+		for _, t := range def.transitions {
+			label := quote(t.msgType.String())
+			if t.decide == nil {
+				fmt.Fprintf(buf, "\t%v -> %v [label=%v];\n", quote(def.name), quote(t.to), label)
+				continue
+			}
+			for _, to := range t.targets {
+				fmt.Fprintf(buf, "\t%v -> %v [label=%v, style=dashed];\n", quote(def.name), quote(to), label)
+			}
+		}
+		if def.timeout > 0 {
+			label := quote(fmt.Sprintf("after %v", def.timeout))
+			fmt.Fprintf(buf, "\t%v -> %v [label=%v, style=dotted];\n", quote(def.name), quote(def.timeoutState), label)
+		}
+	}
+	fmt.Fprintln(buf, "}")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// DOT returns the state graph of the machine in the DOT language (see
+// `WriteDOT`).
+func (machine *Machine[S]) DOT() string {
+	buf := new(bytes.Buffer)
+	machine.WriteDOT(buf)
+	return buf.String()
+}
+
+// quote a value as a DOT identifier.
+func quote(v interface{}) string {
+	return strconv.Quote(fmt.Sprint(v))
+}
+
+// A StateBuilder declares the behaviour of a `Machine` in one state. Its
+// methods return the builder, so that declarations can be chained.
+type StateBuilder[S comparable] struct {
+	machine *Machine[S]
+	state   *state[S]
+}
+
+// On declares a transition to a state on receipt of a message with the same
+// type as `msg` (the value of `msg` is ignored). The action is run with the
+// message before the transition happens, and can be nil.
+func (b *StateBuilder[S]) On(msg task.Message, to S, action func(self task.Task, m task.Message)) *StateBuilder[S] {
+	b.add(&transition[S]{msgType: reflect.TypeOf(msg), to: to, action: action})
+	return b
+}
+
+// OnFunc declares a transition on receipt of a message with the same type as
+// `msg` (the value of `msg` is ignored), where the state to transition to is
+// decided by a function of the message. The targets are the states that the
+// function can return; they are only used to draw the state graph.
+func (b *StateBuilder[S]) OnFunc(msg task.Message, decide func(self task.Task, m task.Message) S, targets ...S) *StateBuilder[S] {
+	b.add(&transition[S]{msgType: reflect.TypeOf(msg), decide: decide, targets: targets})
+	return b
+}
+
+// OnEnter sets the action that is run when the state is entered.
+func (b *StateBuilder[S]) OnEnter(f func(self task.Task)) *StateBuilder[S] {
+	b.state.enter = f
+	return b
+}
+
+// OnExit sets the action that is run when the state is left.
+func (b *StateBuilder[S]) OnExit(f func(self task.Task)) *StateBuilder[S] {
+	b.state.exit = f
+	return b
+}
+
+// Timeout declares a transition to a state if the state has not been left
+// within a duration of being entered. The timeout is delivered as a message
+// that the machine sends to its own task, so it is handled in order with
+// other messages. If the timeout is to the same state, the state is not left,
+// and the timeout starts again every time it expires.
+func (b *StateBuilder[S]) Timeout(d time.Duration, to S) *StateBuilder[S] {
+	b.state.timeout = d
+	b.state.timeoutState = to
+	return b
+}
+
+// State returns a builder for another state of the same machine, so that
+// declarations of different states can be chained.
+func (b *StateBuilder[S]) State(name S) *StateBuilder[S] {
+	return b.machine.State(name)
+}
+
+// add a transition to the state, replacing any earlier transition for the same
+// message type.
+func (b *StateBuilder[S]) add(t *transition[S]) {
+	if t.msgType == nil {
+		panic("fsm error: expected message got nil")
+	}
+	if old, ok := b.state.byType[t.msgType]; ok {
+		for i := range b.state.transitions {
+			if b.state.transitions[i] == old {
+				b.state.transitions[i] = t
+			}
+		}
+	} else {
+		b.state.transitions = append(b.state.transitions, t)
+	}
+	b.state.byType[t.msgType] = t
+}
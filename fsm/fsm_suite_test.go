@@ -0,0 +1,13 @@
+package fsm_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestFSM(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "FSM Suite")
+}
@@ -0,0 +1,344 @@
+package fsm_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/fsm"
+
+	"github.com/renproject/phi/task"
+)
+
+type turnstile int
+
+const (
+	locked turnstile = iota
+	unlocked
+	broken
+)
+
+func (t turnstile) String() string {
+	return [...]string{"locked", "unlocked", "broken"}[t]
+}
+
+type coin struct{ value int }
+
+func (coin) IsMessage() {}
+
+type push struct{}
+
+func (push) IsMessage() {}
+
+type kick struct{}
+
+func (kick) IsMessage() {}
+
+// fakeClock is a `task.Clock` that only advances when it is told to.
+type fakeClock struct {
+	mu      *sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{mu: new(sync.Mutex), now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+func (c *fakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// events is a log of the actions run by a machine. The machine runs on the
+// goroutine of its task, so the log is guarded for use by the tests.
+type events struct {
+	mu     *sync.Mutex
+	events []string
+}
+
+func newEvents() *events {
+	return &events{mu: new(sync.Mutex)}
+}
+
+func (e *events) add(event string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, event)
+}
+
+func (e *events) get() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string{}, e.events...)
+}
+
+// newTurnstile returns a machine that unlocks when it receives a coin with a
+// positive value, and locks again when it is pushed or after a second.
+func newTurnstile(evs *events) *Machine[turnstile] {
+	machine := New(locked)
+	machine.State(locked).
+		OnEnter(func(task.Task) { evs.add("enter locked") }).
+		OnExit(func(task.Task) { evs.add("exit locked") }).
+		OnFunc(coin{}, func(_ task.Task, m task.Message) turnstile {
+			evs.add("coin")
+			if m.(coin).value > 0 {
+				return unlocked
+			}
+			return locked
+		}, locked, unlocked).
+		On(push{}, locked, nil).
+		On(kick{}, broken, nil).
+		State(unlocked).
+		OnEnter(func(task.Task) { evs.add("enter unlocked") }).
+		OnExit(func(task.Task) { evs.add("exit unlocked") }).
+		On(push{}, locked, func(task.Task, task.Message) { evs.add("push") }).
+		Timeout(time.Second, locked)
+	machine.State(broken)
+	return machine
+}
+
+var _ = Describe("Finite state machines", func() {
+
+	Context("when handling messages directly", func() {
+
+		It("should enter the initial state before the first message", func() {
+			evs := newEvents()
+			machine := newTurnstile(evs)
+			Expect(machine.Current()).To(Equal(locked))
+			machine.Handle(nil, push{})
+			Expect(evs.get()).To(Equal([]string{"enter locked"}))
+		})
+
+		It("should run actions in order when the state changes", func() {
+			evs := newEvents()
+			machine := newTurnstile(evs)
+			machine.WithClock(newFakeClock())
+			machine.Handle(nil, coin{value: 1})
+			Expect(machine.Current()).To(Equal(unlocked))
+			machine.Handle(nil, push{})
+			Expect(machine.Current()).To(Equal(locked))
+			Expect(evs.get()).To(Equal([]string{
+				"enter locked", "coin", "exit locked", "enter unlocked",
+				"push", "exit unlocked", "enter locked",
+			}))
+		})
+
+		It("should not run the entry and exit actions when the state does not change", func() {
+			evs := newEvents()
+			machine := newTurnstile(evs)
+			machine.Handle(nil, coin{value: 0})
+			machine.Handle(nil, push{})
+			Expect(machine.Current()).To(Equal(locked))
+			Expect(evs.get()).To(Equal([]string{"enter locked", "coin"}))
+		})
+
+		It("should drop unhandled messages by default", func() {
+			machine := newTurnstile(newEvents())
+			machine.Handle(nil, kick{})
+			Expect(machine.Current()).To(Equal(broken))
+			Expect(func() { machine.Handle(nil, coin{value: 1}) }).ToNot(Panic())
+			Expect(machine.Current()).To(Equal(broken))
+		})
+
+		It("should apply the unhandled message policy", func() {
+			var unhandled []task.Message
+			machine := newTurnstile(newEvents()).Unhandled(PanicUnhandled, func(_ task.Task, current turnstile, m task.Message) {
+				Expect(current).To(Equal(broken))
+				unhandled = append(unhandled, m)
+			})
+			machine.Handle(nil, kick{})
+			Expect(func() { machine.Handle(nil, coin{value: 1}) }).To(Panic())
+			Expect(unhandled).To(Equal([]task.Message{coin{value: 1}}))
+		})
+
+		It("should panic when transitioning to an undeclared state", func() {
+			exited := false
+			machine := New("a")
+			machine.State("a").
+				OnExit(func(task.Task) { exited = true }).
+				On(push{}, "b", nil)
+			Expect(func() { machine.Handle(nil, push{}) }).To(Panic())
+
+			// The machine does not leave the current state.
+			Expect(exited).To(BeFalse())
+			Expect(machine.Current()).To(Equal("a"))
+		})
+	})
+
+	Context("when running in a task", func() {
+
+		It("should transition when a state times out", func() {
+			evs := newEvents()
+			clock := newFakeClock()
+			machine := newTurnstile(evs).WithClock(clock)
+			t := task.New(machine, task.Options{Cap: 10})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			Expect(t.Send(coin{value: 1})).To(BeTrue())
+			Eventually(clock.Waiters).Should(Equal(1))
+			clock.Advance(time.Second)
+			Eventually(evs.get).Should(ContainElement("exit unlocked"))
+			Expect(evs.get()).To(Equal([]string{
+				"enter locked", "coin", "exit locked", "enter unlocked",
+				"exit unlocked", "enter locked",
+			}))
+		})
+
+		It("should ignore timeouts from states that have been left", func() {
+			evs := newEvents()
+			clock := newFakeClock()
+			machine := newTurnstile(evs).WithClock(clock)
+			t := task.New(machine, task.Options{Cap: 10})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			// Leave the unlocked state before its timeout, and enter it again.
+			Expect(t.Send(coin{value: 1})).To(BeTrue())
+			Expect(t.Send(push{})).To(BeTrue())
+			Expect(t.Send(coin{value: 1})).To(BeTrue())
+			Eventually(evs.get).Should(HaveLen(10))
+			Eventually(clock.Waiters).Should(Equal(2))
+
+			// Both timeouts expire, but only the timeout of the second entry
+			// locks the turnstile.
+			clock.Advance(time.Second)
+			Eventually(evs.get).Should(HaveLen(12))
+			Consistently(evs.get).Should(HaveLen(12))
+		})
+
+		It("should start the timeout again when a state times out to itself", func() {
+			evs := newEvents()
+			clock := newFakeClock()
+			machine := New("polling").WithClock(clock)
+			machine.State("polling").
+				OnEnter(func(task.Task) { evs.add("enter polling") }).
+				Timeout(time.Second, "polling")
+			t := task.New(machine, task.Options{Cap: 10})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			Expect(t.Send(Start{})).To(BeTrue())
+			for i := 0; i < 3; i++ {
+				Eventually(clock.Waiters).Should(Equal(1))
+				clock.Advance(time.Second)
+			}
+			Eventually(clock.Waiters).Should(Equal(1))
+			Expect(machine.Current()).To(Equal("polling"))
+			Expect(evs.get()).To(Equal([]string{"enter polling"}))
+		})
+
+		It("should read the current state while the task is running", func() {
+			machine := newTurnstile(newEvents())
+			t := task.New(machine, task.Options{Cap: 10})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			Expect(machine.Current()).To(Equal(locked))
+			Expect(t.Send(coin{value: 1})).To(BeTrue())
+			Eventually(machine.Current).Should(Equal(unlocked))
+			Expect(t.Send(push{})).To(BeTrue())
+			Eventually(machine.Current).Should(Equal(locked))
+		})
+
+		It("should stop resending a timeout once the task has terminated", func() {
+			clock := newFakeClock()
+			t := task.New(newTurnstile(newEvents()).WithClock(clock), task.Options{Cap: 1})
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				t.Run(ctx)
+			}()
+
+			Expect(t.Send(coin{value: 1})).To(BeTrue())
+			Eventually(clock.Waiters).Should(Equal(1))
+			cancel()
+			Eventually(done).Should(BeClosed())
+
+			// The buffer of the task is full, so the timeout cannot be sent,
+			// and it is not retried because the task has terminated.
+			Expect(t.Send(push{})).To(BeTrue())
+			clock.Advance(time.Second)
+			Consistently(clock.Waiters).Should(Equal(0))
+		})
+
+		It("should enter the initial state when started", func() {
+			evs := newEvents()
+			t := task.New(newTurnstile(evs), task.Options{Cap: 10})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			Expect(t.Send(Start{})).To(BeTrue())
+			Expect(t.Send(Start{})).To(BeTrue())
+			Eventually(evs.get).Should(Equal([]string{"enter locked"}))
+			Consistently(evs.get).Should(Equal([]string{"enter locked"}))
+		})
+	})
+
+	Context("when exporting the state graph", func() {
+
+		It("should write every state and transition", func() {
+			dot := newTurnstile(newEvents()).DOT()
+			Expect(strings.Split(strings.TrimSpace(dot), "\n")).To(Equal([]string{
+				`digraph fsm {`,
+				`	__start [shape=point];`,
+				`	"locked";`,
+				`	"unlocked";`,
+				`	"broken";`,
+				`	__start -> "locked";`,
+				`	"locked" -> "locked" [label="fsm_test.coin", style=dashed];`,
+				`	"locked" -> "unlocked" [label="fsm_test.coin", style=dashed];`,
+				`	"locked" -> "locked" [label="fsm_test.push"];`,
+				`	"locked" -> "broken" [label="fsm_test.kick"];`,
+				`	"unlocked" -> "locked" [label="fsm_test.push"];`,
+				`	"unlocked" -> "locked" [label="after 1s", style=dotted];`,
+				`}`,
+			}))
+		})
+	})
+})
@@ -0,0 +1,189 @@
+// Package health checks the liveness and readiness of tasks, and reports them
+// over HTTP in the JSON format used by health checks (described by the "Health
+// Check Response Format for HTTP APIs" draft, with the media type
+// `application/health+json`).
+//
+// A task is live while it is running and making progress; that is, while it
+// is not stuck with messages in its buffer. A task is ready when it is live,
+// and its handler is ready (see `task.ReadyHandler`).
+package health
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/renproject/phi/task"
+)
+
+// Result is the result of a check.
+type Result string
+
+const (
+	// Passing is the result of a healthy check.
+	Passing Result = "pass"
+	// Warning is the result of a check that is healthy, but is at risk of
+	// becoming unhealthy (for example, because its buffer is full).
+	Warning Result = "warn"
+	// Failing is the result of an unhealthy check.
+	Failing Result = "fail"
+)
+
+// Kinds of checks.
+const (
+	Liveness  = "liveness"
+	Readiness = "readiness"
+)
+
+// Check is the result of checking one task.
+type Check struct {
+	// Name is the name of the task, or "task-i" (where i is the position in
+	// which the task was added to the checker) if the task has no name.
+	Name string
+
+	Result Result
+
+	// Output explains the result, and is empty when the check passes.
+	Output string
+
+	// Status is the status of the task at the time of the check.
+	Status task.Status
+}
+
+// Report is the result of checking all tasks.
+type Report struct {
+	// Kind is either `Liveness` or `Readiness`.
+	Kind string
+
+	// Result is the worst result of all checks. If there are no checks, it is
+	// `Passing`.
+	Result Result
+
+	Checks []Check
+}
+
+// Options are passed when constructing a `Checker`. A task with messages in
+// its buffer that has not handled a message for `StallTimeout` is not live. If
+// `StallTimeout` is zero, the progress of tasks is not checked. The `Clock` is
+// used to measure the time since tasks last handled a message; if it is nil,
+// the `task.SystemClock` is used.
+type Options struct {
+	StallTimeout time.Duration
+	Clock        task.Clock
+}
+
+// A Checker checks the liveness and readiness of a set of tasks. It is safe
+// for concurrent use.
+type Checker struct {
+	opts Options
+
+	mu    *sync.Mutex
+	tasks []task.Task
+}
+
+// NewChecker returns a `Checker` that has no tasks.
+func NewChecker(opts Options) *Checker {
+	if opts.Clock == nil {
+		opts.Clock = task.SystemClock
+	}
+	return &Checker{
+		opts: opts,
+		mu:   new(sync.Mutex),
+	}
+}
+
+// Add tasks to the checker. Tasks that are not a `task.MonitoredTask` cannot
+// report their status, so their checks always fail.
+func (checker *Checker) Add(tasks ...task.Task) {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+	checker.tasks = append(checker.tasks, tasks...)
+}
+
+// Remove a task from the checker.
+func (checker *Checker) Remove(t task.Task) {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+	for i := range checker.tasks {
+		if checker.tasks[i] == t {
+			checker.tasks = append(checker.tasks[:i], checker.tasks[i+1:]...)
+			return
+		}
+	}
+}
+
+// Liveness checks whether or not each task is running and making progress.
+func (checker *Checker) Liveness() Report {
+	return checker.check(Liveness, func(status task.Status) (Result, string) {
+		return checker.live(status)
+	})
+}
+
+// Readiness checks whether or not each task is live and ready.
+func (checker *Checker) Readiness() Report {
+	return checker.check(Readiness, func(status task.Status) (Result, string) {
+		if result, output := checker.live(status); result == Failing {
+			return result, output
+		}
+		if status.NotReady != nil {
+			return Failing, fmt.Sprintf("not ready: %v", status.NotReady)
+		}
+		return Passing, ""
+	})
+}
+
+// check every task, and aggregate the results into a report.
+func (checker *Checker) check(kind string, f func(task.Status) (Result, string)) Report {
+	checker.mu.Lock()
+	tasks := append([]task.Task{}, checker.tasks...)
+	checker.mu.Unlock()
+
+	report := Report{Kind: kind, Result: Passing, Checks: make([]Check, len(tasks))}
+	for i, t := range tasks {
+		var status task.Status
+		result, output := Failing, "status not available"
+		if t, ok := t.(task.MonitoredTask); ok {
+			status = t.Status()
+			result, output = f(status)
+		}
+		name := status.Name
+		if name == "" {
+			name = fmt.Sprintf("task-%d", i)
+		}
+		report.Checks[i] = Check{Name: name, Result: result, Output: output, Status: status}
+		report.Result = worst(report.Result, result)
+	}
+	return report
+}
+
+// live checks whether or not a task is running and making progress.
+func (checker *Checker) live(status task.Status) (Result, string) {
+	switch {
+	case status.Terminated:
+		return Failing, fmt.Sprintf("terminated: %v", status.Reason)
+	case !status.Running:
+		return Failing, "not running"
+	}
+	if checker.opts.StallTimeout > 0 && status.Queued > 0 {
+		last := status.LastHandled
+		if last.Before(status.Started) {
+			last = status.Started
+		}
+		if idle := checker.opts.Clock.Now().Sub(last); idle >= checker.opts.StallTimeout {
+			return Failing, fmt.Sprintf("stalled: %v messages queued, none handled for %v", status.Queued, idle)
+		}
+	}
+	if status.Capacity > 0 && status.Queued >= status.Capacity {
+		return Warning, fmt.Sprintf("buffer full: %v messages queued", status.Queued)
+	}
+	return Passing, ""
+}
+
+// worst returns the worse of two results.
+func worst(a, b Result) Result {
+	rank := map[Result]int{Passing: 0, Warning: 1, Failing: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
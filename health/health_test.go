@@ -0,0 +1,273 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/health"
+
+	"github.com/renproject/phi/task"
+)
+
+type ping struct{}
+
+func (ping) IsMessage() {}
+
+// worker is a `task.ReadyHandler` that blocks on every message until it is
+// released, and is ready once it has been initialised.
+type worker struct {
+	mu      *sync.Mutex
+	err     error
+	release chan struct{}
+}
+
+func newWorker() *worker {
+	return &worker{mu: new(sync.Mutex), err: errors.New("initialising"), release: make(chan struct{})}
+}
+
+func (w *worker) Handle(task.Task, task.Message) {
+	<-w.release
+}
+
+func (w *worker) Ready() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *worker) initialise() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = nil
+}
+
+// fakeClock is a `task.Clock` that only advances when it is told to.
+type fakeClock struct {
+	mu  *sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	panic("unimplemented")
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// foreignTask is a `task.Task` that was not returned by `task.New`.
+type foreignTask struct{}
+
+func (foreignTask) Run(context.Context) {}
+
+func (foreignTask) Send(task.Message) bool { return true }
+
+var _ = Describe("Health checks", func() {
+
+	var clock *fakeClock
+	var w *worker
+	var t task.Task
+	var checker *Checker
+	var cancel context.CancelFunc
+	var done chan struct{}
+
+	BeforeEach(func() {
+		clock = &fakeClock{mu: new(sync.Mutex), now: time.Unix(1000, 0)}
+		w = newWorker()
+		t = task.New(w, task.Options{Name: "worker", Cap: 2, Clock: clock})
+		checker = NewChecker(Options{StallTimeout: time.Minute, Clock: clock})
+		checker.Add(t)
+	})
+
+	run := func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		go func() {
+			defer close(done)
+			t.Run(ctx)
+		}()
+		Eventually(func() bool { return t.(task.MonitoredTask).Status().Running }).Should(BeTrue())
+	}
+
+	AfterEach(func() {
+		if cancel != nil {
+			cancel()
+			cancel = nil
+		}
+		select {
+		case <-w.release:
+		default:
+			close(w.release)
+		}
+	})
+
+	Context("when checking liveness", func() {
+
+		It("should fail for tasks that are not running", func() {
+			report := checker.Liveness()
+			Expect(report.Result).To(Equal(Failing))
+			Expect(report.Checks).To(HaveLen(1))
+			Expect(report.Checks[0].Output).To(Equal("not running"))
+		})
+
+		It("should pass for tasks that are running", func() {
+			run()
+			report := checker.Liveness()
+			Expect(report.Result).To(Equal(Passing))
+			Expect(report.Checks[0].Name).To(Equal("worker"))
+			Expect(report.Checks[0].Status.Started).To(Equal(time.Unix(1000, 0)))
+		})
+
+		It("should fail for tasks that have terminated", func() {
+			run()
+			cancel()
+			Eventually(done).Should(BeClosed())
+			report := checker.Liveness()
+			Expect(report.Result).To(Equal(Failing))
+			Expect(report.Checks[0].Output).To(Equal("terminated: context canceled"))
+		})
+
+		It("should fail for tasks that are not making progress", func() {
+			run()
+			Expect(t.Send(ping{})).To(BeTrue())
+			Expect(t.Send(ping{})).To(BeTrue())
+			Eventually(func() int { return t.(task.MonitoredTask).Status().Queued }).Should(Equal(1))
+
+			clock.Advance(time.Minute - time.Second)
+			Expect(checker.Liveness().Result).To(Equal(Passing))
+			clock.Advance(time.Second)
+			report := checker.Liveness()
+			Expect(report.Result).To(Equal(Failing))
+			Expect(report.Checks[0].Output).To(Equal("stalled: 1 messages queued, none handled for 1m0s"))
+
+			close(w.release)
+			Eventually(func() uint64 { return t.(task.MonitoredTask).Status().Handled }).Should(Equal(uint64(2)))
+			Expect(t.(task.MonitoredTask).Status().LastHandled).To(Equal(time.Unix(1060, 0)))
+			Expect(checker.Liveness().Result).To(Equal(Passing))
+		})
+
+		It("should warn for tasks with a full buffer", func() {
+			run()
+			for i := 0; i < 3; i++ {
+				Expect(t.Send(ping{})).To(BeTrue())
+			}
+			Eventually(func() int { return t.(task.MonitoredTask).Status().Queued }).Should(Equal(2))
+			report := checker.Liveness()
+			Expect(report.Result).To(Equal(Warning))
+			Expect(report.Checks[0].Output).To(Equal("buffer full: 2 messages queued"))
+		})
+
+		It("should name tasks that do not have a name", func() {
+			checker.Add(task.New(newWorker(), task.Options{}))
+			report := checker.Liveness()
+			Expect(report.Checks[1].Name).To(Equal("task-1"))
+			checker.Remove(t)
+			Expect(checker.Liveness().Checks).To(HaveLen(1))
+		})
+	})
+
+	Context("when a task cannot report its status", func() {
+
+		It("should fail", func() {
+			checker.Remove(t)
+			checker.Add(foreignTask{})
+			report := checker.Liveness()
+			Expect(report.Result).To(Equal(Failing))
+			Expect(report.Checks[0].Output).To(Equal("status not available"))
+		})
+	})
+
+	Context("when checking readiness", func() {
+
+		It("should fail until the handler is ready", func() {
+			run()
+			report := checker.Readiness()
+			Expect(report.Result).To(Equal(Failing))
+			Expect(report.Checks[0].Output).To(Equal("not ready: initialising"))
+			w.initialise()
+			Expect(checker.Readiness().Result).To(Equal(Passing))
+		})
+
+		It("should fail for tasks that are not live", func() {
+			w.initialise()
+			Expect(checker.Readiness().Result).To(Equal(Failing))
+		})
+	})
+
+	Context("when serving over HTTP", func() {
+
+		get := func(url string) (int, string, map[string]interface{}) {
+			resp, err := http.Get(url)
+			Expect(err).ToNot(HaveOccurred())
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			var decoded map[string]interface{}
+			Expect(json.Unmarshal(body, &decoded)).To(Succeed())
+			return resp.StatusCode, resp.Header.Get("Content-Type"), decoded
+		}
+
+		It("should serve reports in the health check format", func() {
+			server := httptest.NewServer(checker.Handler())
+			defer server.Close()
+			run()
+
+			code, contentType, body := get(server.URL + "/live")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(contentType).To(Equal(ContentType))
+			Expect(body).To(Equal(map[string]interface{}{
+				"status": "pass",
+				"checks": map[string]interface{}{
+					"worker:liveness": []interface{}{map[string]interface{}{
+						"componentId":   "worker",
+						"componentType": "task",
+						"status":        "pass",
+						"observedValue": float64(0),
+						"observedUnit":  "messages",
+					}},
+				},
+			}))
+
+			code, _, body = get(server.URL + "/ready")
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+			Expect(body["status"]).To(Equal("fail"))
+			checks := body["checks"].(map[string]interface{})["worker:readiness"].([]interface{})
+			Expect(checks[0].(map[string]interface{})["output"]).To(Equal("not ready: initialising"))
+		})
+
+		It("should stop serving when the context is done", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			ctx, cancel := context.WithCancel(context.Background())
+			errs := make(chan error, 1)
+			go func() {
+				errs <- checker.Serve(ctx, listener)
+			}()
+
+			code, _, _ := get("http://" + listener.Addr().String() + "/live")
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+			cancel()
+			Eventually(errs).Should(Receive(BeNil()))
+			_, err = http.Get("http://" + listener.Addr().String() + "/live")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,113 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ContentType is the media type of health check responses.
+const ContentType = "application/health+json"
+
+// responseCheck is the JSON encoding of a `Check`.
+type responseCheck struct {
+	ComponentID   string `json:"componentId"`
+	ComponentType string `json:"componentType"`
+	Status        Result `json:"status"`
+	ObservedValue int    `json:"observedValue"`
+	ObservedUnit  string `json:"observedUnit"`
+	Time          string `json:"time,omitempty"`
+	Output        string `json:"output,omitempty"`
+}
+
+// response is the JSON encoding of a `Report`.
+type response struct {
+	Status Result                     `json:"status"`
+	Checks map[string][]responseCheck `json:"checks"`
+}
+
+// MarshalJSON implements the `json.Marshaler` interface. Each check is keyed
+// by the name of its task and the kind of the report (for example,
+// "worker:liveness"), and observes the number of queued messages. The time of
+// a check is the time at which its task last handled a message.
+func (report Report) MarshalJSON() ([]byte, error) {
+	resp := response{Status: report.Result, Checks: map[string][]responseCheck{}}
+	for _, check := range report.Checks {
+		c := responseCheck{
+			ComponentID:   check.Name,
+			ComponentType: "task",
+			Status:        check.Result,
+			ObservedValue: check.Status.Queued,
+			ObservedUnit:  "messages",
+			Output:        check.Output,
+		}
+		if !check.Status.LastHandled.IsZero() {
+			c.Time = check.Status.LastHandled.UTC().Format(time.RFC3339Nano)
+		}
+		key := check.Name + ":" + report.Kind
+		resp.Checks[key] = append(resp.Checks[key], c)
+	}
+	return json.Marshal(resp)
+}
+
+// Handler returns an `http.Handler` that serves the liveness report at "/live"
+// and the readiness report at "/ready". Reports that fail are served with the
+// status code 503 (Service Unavailable), and other reports with 200 (OK).
+func (checker *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/live", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, checker.Liveness())
+	})
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, checker.Readiness())
+	})
+	return mux
+}
+
+// serve a report.
+func serve(w http.ResponseWriter, report Report) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	if report.Result == Failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.Write(body)
+}
+
+// ListenAndServe serves the reports of the checker (see `Handler`) over HTTP
+// at an address (for example, "localhost:8080"). This function blocks until
+// the context is done, and then shuts down the server.
+func (checker *Checker) ListenAndServe(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return checker.Serve(ctx, listener)
+}
+
+// Serve the reports of the checker (see `Handler`) over HTTP to connections
+// accepted by a listener. This function blocks until the context is done, and
+// then shuts down the server (which closes the listener).
+func (checker *Checker) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{Handler: checker.Handler()}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		server.Shutdown(context.Background())
+		<-errs
+		return nil
+	}
+}
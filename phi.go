@@ -13,6 +13,9 @@ type (
 	// Messages is struct re-exported from package `task`.
 	Messages = task.Messages
 
+	// Atomic is a type re-exported from package `task`.
+	Atomic = task.Atomic
+
 	// Runner is an interface re-exported from package `task`.
 	Runner = task.Runner
 
@@ -28,8 +31,80 @@ type (
 	// Handler is an interface re-exported from package `task`.
 	Handler = task.Handler
 
+	// ErrorHandler is an interface re-exported from package `task`.
+	ErrorHandler = task.ErrorHandler
+
+	// BatchHandler is an interface re-exported from package `task`.
+	BatchHandler = task.BatchHandler
+
 	// Router is an interface re-exported from package `task`.
 	Router = task.Router
+
+	// ConcurrentRouter is an interface re-exported from package `task`.
+	ConcurrentRouter = task.ConcurrentRouter
+
+	// Clock is an interface re-exported from package `task`.
+	Clock = task.Clock
+
+	// Rate is a struct re-exported from package `task`.
+	Rate = task.Rate
+
+	// RateLimitOptions is a struct re-exported from package `task`.
+	RateLimitOptions = task.RateLimitOptions
+
+	// RateLimitedSender is a struct re-exported from package `task`.
+	RateLimitedSender = task.RateLimitedSender
+
+	// CircuitState is a type re-exported from package `task`.
+	CircuitState = task.CircuitState
+
+	// CircuitStateChanged is a struct re-exported from package `task`.
+	CircuitStateChanged = task.CircuitStateChanged
+
+	// CircuitBreakerOptions is a struct re-exported from package `task`.
+	CircuitBreakerOptions = task.CircuitBreakerOptions
+
+	// CircuitBreaker is a struct re-exported from package `task`.
+	CircuitBreaker = task.CircuitBreaker
+
+	// HandlerFunc is a type re-exported from package `task`.
+	HandlerFunc = task.HandlerFunc
+
+	// SenderFunc is a type re-exported from package `task`.
+	SenderFunc = task.SenderFunc
+
+	// Interceptor is a type re-exported from package `task`.
+	Interceptor = task.Interceptor
+
+	// SendInterceptor is a type re-exported from package `task`.
+	SendInterceptor = task.SendInterceptor
+
+	// Logger is an interface re-exported from package `task`.
+	Logger = task.Logger
+
+	// Level is a type re-exported from package `task`.
+	Level = task.Level
+
+	// BehaviourTask is an interface re-exported from package `task`.
+	BehaviourTask = task.BehaviourTask
+
+	// WatchableTask is an interface re-exported from package `task`.
+	WatchableTask = task.WatchableTask
+
+	// MonitoredTask is an interface re-exported from package `task`.
+	MonitoredTask = task.MonitoredTask
+
+	// Terminated is a struct re-exported from package `task`.
+	Terminated = task.Terminated
+
+	// LinkError is a struct re-exported from package `task`.
+	LinkError = task.LinkError
+
+	// Credits is a struct re-exported from package `task`.
+	Credits = task.Credits
+
+	// Group is a struct re-exported from package `task`.
+	Group = task.Group
 )
 
 var (
@@ -38,6 +113,83 @@ var (
 
 	// NewRouter is a function re-exported from package `task`.
 	NewRouter = task.NewRouter
+
+	// LatestByKey is a function re-exported from package `task`.
+	LatestByKey = task.LatestByKey
+
+	// ErrNotWatchable is an error re-exported from package `task`.
+	ErrNotWatchable = task.ErrNotWatchable
+
+	// ErrMaxDepthExceeded is an error re-exported from package `task`.
+	ErrMaxDepthExceeded = task.ErrMaxDepthExceeded
+
+	// NewRateLimitedSender is a function re-exported from package `task`.
+	NewRateLimitedSender = task.NewRateLimitedSender
+
+	// SystemClock is a value re-exported from package `task`.
+	SystemClock = task.SystemClock
+
+	// NewCircuitBreaker is a function re-exported from package `task`.
+	NewCircuitBreaker = task.NewCircuitBreaker
+
+	// Chain is a function re-exported from package `task`.
+	Chain = task.Chain
+
+	// ChainSend is a function re-exported from package `task`.
+	ChainSend = task.ChainSend
+
+	// LoggingInterceptor is a function re-exported from package `task`.
+	LoggingInterceptor = task.LoggingInterceptor
+
+	// LoggingSendInterceptor is a function re-exported from package `task`.
+	LoggingSendInterceptor = task.LoggingSendInterceptor
+
+	// RecoverInterceptor is a function re-exported from package `task`.
+	RecoverInterceptor = task.RecoverInterceptor
+
+	// SetDefaultLogger is a function re-exported from package `task`.
+	SetDefaultLogger = task.SetDefaultLogger
+
+	// DefaultLogger is a function re-exported from package `task`.
+	DefaultLogger = task.DefaultLogger
+
+	// NewStdLogger is a function re-exported from package `task`.
+	NewStdLogger = task.NewStdLogger
+
+	// NewCredits is a function re-exported from package `task`.
+	NewCredits = task.NewCredits
+
+	// NewGroup is a function re-exported from package `task`.
+	NewGroup = task.NewGroup
+
+	// Resend is a function re-exported from package `task`.
+	Resend = task.Resend
+)
+
+const (
+	// DefaultMaxDepth is a constant re-exported from package `task`.
+	DefaultMaxDepth = task.DefaultMaxDepth
+
+	// CircuitClosed is a constant re-exported from package `task`.
+	CircuitClosed = task.CircuitClosed
+
+	// CircuitOpen is a constant re-exported from package `task`.
+	CircuitOpen = task.CircuitOpen
+
+	// CircuitHalfOpen is a constant re-exported from package `task`.
+	CircuitHalfOpen = task.CircuitHalfOpen
+
+	// LevelDebug is a constant re-exported from package `task`.
+	LevelDebug = task.LevelDebug
+
+	// LevelInfo is a constant re-exported from package `task`.
+	LevelInfo = task.LevelInfo
+
+	// LevelWarn is a constant re-exported from package `task`.
+	LevelWarn = task.LevelWarn
+
+	// LevelError is a constant re-exported from package `task`.
+	LevelError = task.LevelError
 )
 
 // Package `co` re-exports
@@ -50,4 +202,56 @@ var (
 
 	// ForAll is a function re-exported from package `co`.
 	ForAll = co.ForAll
+
+	// ParBeginErr is a function re-exported from package `co`.
+	ParBeginErr = co.ParBeginErr
+
+	// ForAllErr is a function re-exported from package `co`.
+	ForAllErr = co.ForAllErr
+
+	// ForAllWith is a function re-exported from package `co`.
+	ForAllWith = co.ForAllWith
+
+	// ForAllRange is a function re-exported from package `co`.
+	ForAllRange = co.ForAllRange
+
+	// ForAllRangeWith is a function re-exported from package `co`.
+	ForAllRangeWith = co.ForAllRangeWith
+
+	// ParForAllRange is a function re-exported from package `co`.
+	ParForAllRange = co.ParForAllRange
+
+	// WithScope is a function re-exported from package `co`.
+	WithScope = co.WithScope
+
+	// ErrTimeout is an error re-exported from package `co`.
+	ErrTimeout = co.ErrTimeout
+
+	// ErrNoFutures is an error re-exported from package `co`.
+	ErrNoFutures = co.ErrNoFutures
+
+	// NewPipeline is a function re-exported from package `co`.
+	NewPipeline = co.NewPipeline
+
+	// ErrSkip is an error re-exported from package `co`.
+	ErrSkip = co.ErrSkip
+)
+
+// Package `co` re-exports
+type (
+	// CoOptions is a struct re-exported from package `co` (where it is named
+	// `Options`).
+	CoOptions = co.Options
+
+	// Errors is a type re-exported from package `co`.
+	Errors = co.Errors
+
+	// PanicError is a struct re-exported from package `co`.
+	PanicError = co.PanicError
+
+	// Scope is a struct re-exported from package `co`.
+	Scope = co.Scope
+
+	// Pipeline is a struct re-exported from package `co`.
+	Pipeline = co.Pipeline
 )
@@ -0,0 +1,454 @@
+// Package saga coordinates workflows that span multiple tasks. A saga is a
+// sequence of steps, each of which has an action and (optionally) a
+// compensating action. The steps of an instance of a saga run in order; if a
+// step fails, the compensating actions of the steps that have completed are
+// run in reverse order, so that the workflow is undone instead of being left
+// half done.
+//
+// A `Coordinator` is a `task.Handler` that runs the instances of one saga. It
+// tracks the progress of each instance, waits for replies from the tasks that
+// steps send requests to, fails steps that time out, and persists the state of
+// each instance in a `Store` so that instances can be resumed after a restart.
+package saga
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/renproject/phi/task"
+)
+
+var (
+	// ErrTimeout is the error of a step that did not receive a reply within
+	// its timeout.
+	ErrTimeout = errors.New("step timed out")
+
+	// ErrExists is the error of a `Rejected` message when an instance is
+	// started with the ID of an instance that is already running.
+	ErrExists = errors.New("instance already exists")
+)
+
+// Status is the status of an instance of a saga.
+type Status string
+
+const (
+	// Running instances are running their steps.
+	Running Status = "running"
+	// Compensating instances have had a step fail, and are running the
+	// compensating actions of the steps that completed.
+	Compensating Status = "compensating"
+	// Completed instances have completed all of their steps.
+	Completed Status = "completed"
+	// Compensated instances have had a step fail, and have run all of their
+	// compensating actions.
+	Compensated Status = "compensated"
+	// Failed instances have had a step fail, and then had a compensating
+	// action fail.
+	Failed Status = "failed"
+)
+
+// Instance is given to the actions of a step, and identifies the instance of
+// the saga that the step belongs to. The `Data` belongs to the instance, and
+// can be modified by the actions; it is persisted after every action. The
+// `Self` task is the task of the coordinator, which replies should be sent to
+// (see `Instance.Reply`).
+type Instance[D any] struct {
+	ID   string
+	Data *D
+	Self task.Task
+
+	step    int
+	attempt uint64
+}
+
+// Reply returns the message that completes (if `err` is nil) or fails the
+// current step of the instance, when it is sent to the coordinator. The value is
+// passed to the `OnReply` function of the step.
+func (inst Instance[D]) Reply(value interface{}, err error) Reply {
+	return Reply{ID: inst.ID, Step: inst.step, Attempt: inst.attempt, Value: value, Err: err}
+}
+
+// Step is a step in a saga. The `Action` is run when the step starts; if it
+// returns an error, the step fails. If `Await` is false, the step completes as
+// soon as the action returns. Otherwise, the step waits for a `Reply` (see
+// `Instance.Reply`), which is given to `OnReply`, if it is not nil, so that the
+// value of the reply can be stored in the data of the instance. If the step
+// does not receive a reply within the `Timeout` (when it is positive), it fails
+// with `ErrTimeout`.
+//
+// When a later step fails, `Compensate` is run to undo the step. Steps that did
+// not complete are not compensated. Both `Action` and `Compensate` can be nil.
+// After a restart, the action (or compensating action) that was running is run
+// again, so actions should be idempotent.
+type Step[D any] struct {
+	Name       string
+	Action     func(Instance[D]) error
+	Await      bool
+	Timeout    time.Duration
+	OnReply    func(data *D, value interface{}) error
+	Compensate func(Instance[D]) error
+}
+
+// Start is the message that starts an instance of a saga with some initial
+// data. If an instance with the same ID is already running, the message is
+// rejected (see `Rejected`).
+type Start[D any] struct {
+	ID   string
+	Data D
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Start[D]) IsMessage() {}
+
+// Resume is the message that makes a coordinator resume all of the instances
+// that it has persisted in its store, but that are not running (for example,
+// after a restart).
+type Resume struct{}
+
+// IsMessage implements the `task.Message` interface.
+func (Resume) IsMessage() {}
+
+// Reply is the message that completes, or fails, a step that is awaiting a
+// reply. Replies for steps that are no longer awaiting a reply (for example,
+// because they have timed out) are ignored.
+type Reply struct {
+	ID      string
+	Step    int
+	Attempt uint64
+	Value   interface{}
+	Err     error
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Reply) IsMessage() {}
+
+// Finished is the message sent to the `Events` sender of a coordinator when
+// an instance finishes. The `Err` is the error that made the instance
+// compensate, and is nil when the instance completed.
+type Finished struct {
+	ID     string
+	Status Status
+	Err    error
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Finished) IsMessage() {}
+
+// Rejected is the message sent to the `Events` sender of a coordinator when a
+// `Start` message is rejected. The `Err` is `ErrExists`, or the error of the
+// `Store` when it cannot tell whether or not the instance exists.
+type Rejected struct {
+	ID  string
+	Err error
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Rejected) IsMessage() {}
+
+// StoreError is the message sent to the `Events` sender of a coordinator when
+// its store returns an error. The instance keeps running, but might not be
+// resumed correctly after a restart.
+type StoreError struct {
+	ID  string
+	Err error
+}
+
+// IsMessage implements the `task.Message` interface.
+func (StoreError) IsMessage() {}
+
+// timeout is the message that a coordinator sends to itself when a step times
+// out.
+type timeout struct {
+	coordinator interface{}
+	id          string
+	step        int
+	attempt     uint64
+}
+
+// IsMessage implements the `task.Message` interface.
+func (timeout) IsMessage() {}
+
+// timeoutRetry is how long a coordinator waits before resending a timeout that
+// its task did not accept (because its buffer was full).
+const timeoutRetry = 10 * time.Millisecond
+
+// State is the persisted state of an instance of a saga. While the instance is
+// running, `Step` is the step that is running; while it is compensating, it is
+// the step that is being compensated. The `Attempt` identifies the run of the
+// step that replies are accepted for.
+type State[D any] struct {
+	ID      string `json:"id"`
+	Status  Status `json:"status"`
+	Step    int    `json:"step"`
+	Attempt uint64 `json:"attempt"`
+	Data    D      `json:"data"`
+	Err     string `json:"error,omitempty"`
+}
+
+// Options are passed when constructing a `Coordinator`. If the `Store` is not
+// nil, the state of every instance is persisted in it, as JSON, after every
+// action, and is deleted when the instance finishes. If `Events` is not nil,
+// `Finished`, `Rejected` and `StoreError` messages are sent to it (events that
+// it rejects are dropped). If `Clock` is nil, the `task.SystemClock` is used to
+// measure timeouts.
+type Options struct {
+	Store  Store
+	Events task.Sender
+	Clock  task.Clock
+}
+
+// A Coordinator is a `task.Handler` that runs instances of a saga with data of
+// type `D`. The data must be encodable as JSON when a `Store` is used. Like any
+// stateful handler, a coordinator must only be used by a task with a `Scale`
+// less than 2.
+type Coordinator[D any] struct {
+	steps     []Step[D]
+	opts      Options
+	instances map[string]*State[D]
+}
+
+// New returns a `Coordinator` for a saga with the given steps.
+func New[D any](steps []Step[D], opts Options) *Coordinator[D] {
+	if opts.Clock == nil {
+		opts.Clock = task.SystemClock
+	}
+	return &Coordinator[D]{
+		steps:     steps,
+		opts:      opts,
+		instances: map[string]*State[D]{},
+	}
+}
+
+// Handle implements the `task.Handler` interface.
+func (c *Coordinator[D]) Handle(self task.Task, m task.Message) {
+	switch m := m.(type) {
+	case Start[D]:
+		c.start(self, m)
+	case Reply:
+		c.reply(self, m)
+	case timeout:
+		c.timeout(self, m)
+	case Resume:
+		c.resume(self)
+	}
+}
+
+// start a new instance.
+func (c *Coordinator[D]) start(self task.Task, m Start[D]) {
+	if _, ok := c.instances[m.ID]; ok {
+		c.emit(Rejected{ID: m.ID, Err: ErrExists})
+		return
+	}
+	if c.opts.Store != nil {
+		_, err := c.opts.Store.Load(m.ID)
+		switch {
+		case err == nil:
+			// The instance was persisted, but has not been resumed.
+			c.emit(Rejected{ID: m.ID, Err: ErrExists})
+			return
+		case !errors.Is(err, ErrNotFound):
+			// The instance might have been persisted, so starting it could
+			// overwrite its state.
+			c.emit(Rejected{ID: m.ID, Err: err})
+			return
+		}
+	}
+	state := &State[D]{ID: m.ID, Status: Running, Data: m.Data}
+	c.instances[m.ID] = state
+	c.run(self, state)
+}
+
+// run the steps of an instance, starting from its current step, until a step
+// awaits a reply, a step fails, or all steps have completed.
+func (c *Coordinator[D]) run(self task.Task, state *State[D]) {
+	for state.Step < len(c.steps) {
+		step := c.steps[state.Step]
+		state.Attempt++
+		c.persist(state)
+
+		inst := c.instance(self, state)
+		if step.Action != nil {
+			if err := step.Action(inst); err != nil {
+				c.fail(self, state, err)
+				return
+			}
+		}
+		if step.Await {
+			c.persist(state)
+			if step.Timeout > 0 {
+				go c.wait(self, step.Timeout, timeout{coordinator: c, id: state.ID, step: state.Step, attempt: state.Attempt})
+			}
+			return
+		}
+		state.Step++
+	}
+	c.finish(state, Completed, nil)
+}
+
+// reply to a step that is awaiting a reply.
+func (c *Coordinator[D]) reply(self task.Task, m Reply) {
+	state, ok := c.awaiting(m.ID, m.Step, m.Attempt)
+	if !ok {
+		return
+	}
+	if m.Err != nil {
+		c.fail(self, state, m.Err)
+		return
+	}
+	if onReply := c.steps[state.Step].OnReply; onReply != nil {
+		if err := onReply(&state.Data, m.Value); err != nil {
+			c.fail(self, state, err)
+			return
+		}
+	}
+	state.Step++
+	c.run(self, state)
+}
+
+// timeout a step that is awaiting a reply.
+func (c *Coordinator[D]) timeout(self task.Task, m timeout) {
+	if m.coordinator != c {
+		return
+	}
+	state, ok := c.awaiting(m.id, m.step, m.attempt)
+	if !ok {
+		return
+	}
+	c.fail(self, state, ErrTimeout)
+}
+
+// awaiting returns the instance that is awaiting a reply for an attempt at a
+// step.
+func (c *Coordinator[D]) awaiting(id string, step int, attempt uint64) (*State[D], bool) {
+	state, ok := c.instances[id]
+	if !ok || state.Status != Running || state.Step != step || state.Attempt != attempt {
+		return nil, false
+	}
+	return state, true
+}
+
+// fail the current step of an instance, and compensate the steps that have
+// completed.
+func (c *Coordinator[D]) fail(self task.Task, state *State[D], err error) {
+	name := c.steps[state.Step].Name
+	if name == "" {
+		name = fmt.Sprint(state.Step)
+	}
+	state.Status = Compensating
+	state.Err = fmt.Sprintf("step %v: %v", name, err)
+	state.Step--
+	c.compensate(self, state)
+}
+
+// compensate the steps of an instance, starting from its current step, in
+// reverse order.
+func (c *Coordinator[D]) compensate(self task.Task, state *State[D]) {
+	status := Compensated
+	for ; state.Step >= 0; state.Step-- {
+		c.persist(state)
+		step := c.steps[state.Step]
+		if step.Compensate == nil {
+			continue
+		}
+		if err := step.Compensate(c.instance(self, state)); err != nil {
+			name := step.Name
+			if name == "" {
+				name = fmt.Sprint(state.Step)
+			}
+			state.Err = fmt.Sprintf("%v; compensating step %v: %v", state.Err, name, err)
+			status = Failed
+		}
+	}
+	c.finish(state, status, errors.New(state.Err))
+}
+
+// finish an instance, removing it from the coordinator and its store. The
+// final state is persisted before it is deleted, so that an instance whose
+// state cannot be deleted is resumed as finished, with the same error.
+func (c *Coordinator[D]) finish(state *State[D], status Status, err error) {
+	state.Status = status
+	delete(c.instances, state.ID)
+	if c.opts.Store != nil {
+		c.persist(state)
+		if err := c.opts.Store.Delete(state.ID); err != nil {
+			c.emit(StoreError{ID: state.ID, Err: err})
+		}
+	}
+	c.emit(Finished{ID: state.ID, Status: status, Err: err})
+}
+
+// resume the instances that have been persisted, but are not running.
+func (c *Coordinator[D]) resume(self task.Task) {
+	if c.opts.Store == nil {
+		return
+	}
+	ids, err := c.opts.Store.List()
+	if err != nil {
+		c.emit(StoreError{Err: err})
+		return
+	}
+	for _, id := range ids {
+		if _, ok := c.instances[id]; ok {
+			continue
+		}
+		data, err := c.opts.Store.Load(id)
+		if err != nil {
+			c.emit(StoreError{ID: id, Err: err})
+			continue
+		}
+		state := new(State[D])
+		if err := json.Unmarshal(data, state); err != nil {
+			c.emit(StoreError{ID: id, Err: err})
+			continue
+		}
+		c.instances[id] = state
+		switch state.Status {
+		case Running:
+			c.run(self, state)
+		case Compensating:
+			c.compensate(self, state)
+		default:
+			// The instance finished, but its state was not deleted.
+			var err error
+			if state.Err != "" {
+				err = errors.New(state.Err)
+			}
+			c.finish(state, state.Status, err)
+		}
+	}
+}
+
+// persist the state of an instance.
+func (c *Coordinator[D]) persist(state *State[D]) {
+	if c.opts.Store == nil {
+		return
+	}
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = c.opts.Store.Save(state.ID, data)
+	}
+	if err != nil {
+		c.emit(StoreError{ID: state.ID, Err: err})
+	}
+}
+
+// instance returns the instance given to the actions of its current step.
+func (c *Coordinator[D]) instance(self task.Task, state *State[D]) Instance[D] {
+	return Instance[D]{ID: state.ID, Data: &state.Data, Self: self, step: state.Step, attempt: state.Attempt}
+}
+
+// wait for a timeout to expire, and then send the timeout message to the task
+// of the coordinator, unless the task has terminated.
+func (c *Coordinator[D]) wait(self task.Task, d time.Duration, m timeout) {
+	<-c.opts.Clock.After(d)
+	task.Resend(c.opts.Clock, self, m, timeoutRetry, nil)
+}
+
+// emit an event.
+func (c *Coordinator[D]) emit(m task.Message) {
+	if c.opts.Events != nil {
+		c.opts.Events.Send(m)
+	}
+}
@@ -0,0 +1,378 @@
+package saga_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/saga"
+
+	"github.com/renproject/phi/task"
+)
+
+// order is the data of the saga used by the tests. It reserves stock, charges
+// a payment, and then ships the order.
+type order struct {
+	Item     string `json:"item"`
+	Reserved bool   `json:"reserved"`
+	Payment  string `json:"payment"`
+}
+
+// request is sent to the payment service, which replies with a payment ID.
+type request struct {
+	reply func(value interface{}, err error) task.Message
+	fail  bool
+}
+
+func (request) IsMessage() {}
+
+// service is a `task.Handler` that replies to requests, unless it is paused.
+type service struct {
+	coordinator task.Sender
+	paused      int32
+}
+
+func (s *service) pause(paused bool) {
+	if paused {
+		atomic.StoreInt32(&s.paused, 1)
+	} else {
+		atomic.StoreInt32(&s.paused, 0)
+	}
+}
+
+func (s *service) Handle(_ task.Task, m task.Message) {
+	req := m.(request)
+	if atomic.LoadInt32(&s.paused) == 1 {
+		return
+	}
+	if req.fail {
+		s.coordinator.Send(req.reply(nil, errors.New("card declined")))
+		return
+	}
+	s.coordinator.Send(req.reply("payment-1", nil))
+}
+
+// journal records the actions that the saga runs.
+type journal struct {
+	mu      *sync.Mutex
+	actions []string
+}
+
+func (j *journal) add(action string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.actions = append(j.actions, action)
+}
+
+func (j *journal) get() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]string{}, j.actions...)
+}
+
+// fakeClock is a `task.Clock` that only advances when it is told to.
+type fakeClock struct {
+	mu      *sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+func (c *fakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// brokenStore is a `Store` that cannot load any state.
+type brokenStore struct {
+	*MemoryStore
+}
+
+func (brokenStore) Load(string) ([]byte, error) {
+	return nil, errors.New("disk failed")
+}
+
+// events is a `task.Sender` that writes messages to a channel.
+type events chan task.Message
+
+func (evs events) Send(m task.Message) bool {
+	evs <- m
+	return true
+}
+
+var _ = Describe("Sagas", func() {
+
+	var j *journal
+	var svc *service
+	var svcTask task.Task
+	var evs events
+	var clock *fakeClock
+	var failShipping bool
+	var failRefund bool
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	steps := func() []Step[order] {
+		return []Step[order]{
+			{
+				Name: "reserve",
+				Action: func(inst Instance[order]) error {
+					j.add("reserve " + inst.Data.Item)
+					inst.Data.Reserved = true
+					return nil
+				},
+				Compensate: func(inst Instance[order]) error {
+					j.add("release " + inst.Data.Item)
+					inst.Data.Reserved = false
+					return nil
+				},
+			},
+			{
+				Name: "pay",
+				Action: func(inst Instance[order]) error {
+					j.add("pay")
+					svcTask.Send(request{reply: func(v interface{}, err error) task.Message {
+						return inst.Reply(v, err)
+					}, fail: inst.Data.Item == "declined"})
+					return nil
+				},
+				Await:   true,
+				Timeout: time.Second,
+				OnReply: func(data *order, value interface{}) error {
+					data.Payment = value.(string)
+					return nil
+				},
+				Compensate: func(inst Instance[order]) error {
+					j.add("refund " + inst.Data.Payment)
+					if failRefund {
+						return errors.New("refund failed")
+					}
+					return nil
+				},
+			},
+			{
+				Name: "ship",
+				Action: func(inst Instance[order]) error {
+					j.add("ship")
+					if failShipping {
+						return errors.New("no courier")
+					}
+					return nil
+				},
+			},
+		}
+	}
+
+	// run a coordinator in a task, and return the task.
+	run := func(opts Options) task.Task {
+		opts.Events = evs
+		opts.Clock = clock
+		t := task.New(New(steps(), opts), task.Options{Cap: 10})
+		svc.coordinator = t
+		go t.Run(ctx)
+		return t
+	}
+
+	expectFinished := func(id string, status Status) Finished {
+		var m task.Message
+		Eventually(evs).Should(Receive(&m))
+		finished, ok := m.(Finished)
+		Expect(ok).To(BeTrue(), "unexpected event %#v", m)
+		Expect(finished.ID).To(Equal(id))
+		Expect(finished.Status).To(Equal(status))
+		return finished
+	}
+
+	BeforeEach(func() {
+		j = &journal{mu: new(sync.Mutex)}
+		svc = &service{}
+		svcTask = task.New(svc, task.Options{Cap: 10})
+		evs = make(events, 10)
+		clock = &fakeClock{mu: new(sync.Mutex), now: time.Unix(0, 0)}
+		failShipping, failRefund = false, false
+		ctx, cancel = context.WithCancel(context.Background())
+		go svcTask.Run(ctx)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	Context("when every step succeeds", func() {
+
+		It("should complete the instance", func() {
+			store := NewMemoryStore()
+			t := run(Options{Store: store})
+			Expect(t.Send(Start[order]{ID: "1", Data: order{Item: "book"}})).To(BeTrue())
+
+			finished := expectFinished("1", Completed)
+			Expect(finished.Err).ToNot(HaveOccurred())
+			Expect(j.get()).To(Equal([]string{"reserve book", "pay", "ship"}))
+			Expect(store.List()).To(BeEmpty())
+		})
+
+		It("should reject instances that are already running", func() {
+			svc.pause(true)
+			t := run(Options{})
+			Expect(t.Send(Start[order]{ID: "1", Data: order{Item: "book"}})).To(BeTrue())
+			Expect(t.Send(Start[order]{ID: "1", Data: order{Item: "pen"}})).To(BeTrue())
+			Eventually(evs).Should(Receive(Equal(Rejected{ID: "1", Err: ErrExists})))
+		})
+	})
+
+	Context("when a step fails", func() {
+
+		It("should compensate the steps that completed in reverse order", func() {
+			failShipping = true
+			t := run(Options{})
+			Expect(t.Send(Start[order]{ID: "1", Data: order{Item: "book"}})).To(BeTrue())
+
+			finished := expectFinished("1", Compensated)
+			Expect(finished.Err).To(MatchError("step ship: no courier"))
+			Expect(j.get()).To(Equal([]string{
+				"reserve book", "pay", "ship", "refund payment-1", "release book",
+			}))
+		})
+
+		It("should compensate when a reply fails", func() {
+			t := run(Options{})
+			Expect(t.Send(Start[order]{ID: "1", Data: order{Item: "declined"}})).To(BeTrue())
+
+			finished := expectFinished("1", Compensated)
+			Expect(finished.Err).To(MatchError("step pay: card declined"))
+			Expect(j.get()).To(Equal([]string{"reserve declined", "pay", "release declined"}))
+		})
+
+		It("should compensate when a step times out", func() {
+			svc.pause(true)
+			t := run(Options{})
+			Expect(t.Send(Start[order]{ID: "1", Data: order{Item: "book"}})).To(BeTrue())
+			Eventually(clock.Waiters).Should(Equal(1))
+			clock.Advance(time.Second)
+
+			finished := expectFinished("1", Compensated)
+			Expect(finished.Err).To(MatchError("step pay: " + ErrTimeout.Error()))
+			Expect(j.get()).To(Equal([]string{"reserve book", "pay", "release book"}))
+		})
+
+		It("should fail when a compensation fails", func() {
+			failShipping, failRefund = true, true
+			t := run(Options{})
+			Expect(t.Send(Start[order]{ID: "1", Data: order{Item: "book"}})).To(BeTrue())
+
+			finished := expectFinished("1", Failed)
+			Expect(finished.Err).To(MatchError("step ship: no courier; compensating step pay: refund failed"))
+			Expect(j.get()).To(ContainElement("release book"))
+		})
+	})
+
+	Context("when the coordinator restarts", func() {
+
+		It("should resume persisted instances", func() {
+			dir, err := os.MkdirTemp("", "saga")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			store, err := NewFileStore(dir)
+			Expect(err).ToNot(HaveOccurred())
+
+			// Start an instance that waits for a payment that never arrives,
+			// and then stop the coordinator.
+			svc.pause(true)
+			coordinatorCtx, stop := context.WithCancel(ctx)
+			opts := Options{Store: store, Events: evs, Clock: clock}
+			t := task.New(New(steps(), opts), task.Options{Cap: 10})
+			svc.coordinator = t
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				t.Run(coordinatorCtx)
+			}()
+			Expect(t.Send(Start[order]{ID: "1", Data: order{Item: "book"}})).To(BeTrue())
+			Eventually(j.get).Should(Equal([]string{"reserve book", "pay"}))
+			stop()
+			Eventually(done).Should(BeClosed())
+			Expect(store.List()).To(Equal([]string{"1"}))
+
+			// A new coordinator retries the payment, and completes the
+			// instance.
+			svc.pause(false)
+			t = run(Options{Store: store})
+			Expect(t.Send(Resume{})).To(BeTrue())
+			expectFinished("1", Completed)
+			Expect(j.get()).To(Equal([]string{"reserve book", "pay", "pay", "ship"}))
+			Expect(store.List()).To(BeEmpty())
+		})
+
+		It("should reject instances that are persisted but not resumed", func() {
+			store := NewMemoryStore()
+			Expect(store.Save("1", []byte(`{"id":"1","status":"running","step":1,"attempt":1,"data":{"item":"book"}}`))).To(Succeed())
+			t := run(Options{Store: store})
+			Expect(t.Send(Start[order]{ID: "1"})).To(BeTrue())
+			Eventually(evs).Should(Receive(Equal(Rejected{ID: "1", Err: ErrExists})))
+		})
+
+		It("should reject instances when the store cannot load them", func() {
+			t := run(Options{Store: brokenStore{NewMemoryStore()}})
+			Expect(t.Send(Start[order]{ID: "1"})).To(BeTrue())
+			var m task.Message
+			Eventually(evs).Should(Receive(&m))
+			Expect(m).To(BeAssignableToTypeOf(Rejected{}))
+			Expect(m.(Rejected).Err).To(MatchError("disk failed"))
+			Expect(j.get()).To(BeEmpty())
+		})
+
+		It("should finish persisted instances that have finished with their error", func() {
+			store := NewMemoryStore()
+			Expect(store.Save("1", []byte(`{"id":"1","status":"failed","step":-1,"attempt":1,"data":{"item":"book"},"error":"step ship: no courier"}`))).To(Succeed())
+			Expect(store.Save("2", []byte(`{"id":"2","status":"completed","step":3,"attempt":1,"data":{"item":"book"}}`))).To(Succeed())
+			t := run(Options{Store: store})
+			Expect(t.Send(Resume{})).To(BeTrue())
+
+			finished := expectFinished("1", Failed)
+			Expect(finished.Err).To(MatchError("step ship: no courier"))
+			finished = expectFinished("2", Completed)
+			Expect(finished.Err).ToNot(HaveOccurred())
+			Expect(j.get()).To(BeEmpty())
+			Expect(store.List()).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,187 @@
+package saga
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned by a `Store` when it has no state for an instance.
+var ErrNotFound = errors.New("not found")
+
+// A Store persists the state of saga instances, so that they can be resumed
+// after a restart. States are opaque bytes, keyed by the ID of their instance.
+// Stores must be safe for concurrent use.
+type Store interface {
+	// Save the state of an instance, replacing any earlier state.
+	Save(id string, state []byte) error
+
+	// Load the state of an instance. It returns `ErrNotFound` if there is no
+	// state for the instance.
+	Load(id string) ([]byte, error)
+
+	// Delete the state of an instance. Deleting an instance that has no state
+	// is not an error.
+	Delete(id string) error
+
+	// List the IDs of all instances that have state, in lexical order.
+	List() ([]string, error)
+}
+
+// MemoryStore is a `Store` that keeps states in memory. It does not survive
+// restarts, so it is mostly useful for testing.
+type MemoryStore struct {
+	mu     *sync.Mutex
+	states map[string][]byte
+}
+
+// NewMemoryStore returns an empty `MemoryStore`.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mu:     new(sync.Mutex),
+		states: map[string][]byte{},
+	}
+}
+
+// Save implements the `Store` interface.
+func (store *MemoryStore) Save(id string, state []byte) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.states[id] = append([]byte{}, state...)
+	return nil
+}
+
+// Load implements the `Store` interface.
+func (store *MemoryStore) Load(id string) ([]byte, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	state, ok := store.states[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, state...), nil
+}
+
+// Delete implements the `Store` interface.
+func (store *MemoryStore) Delete(id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.states, id)
+	return nil
+}
+
+// List implements the `Store` interface.
+func (store *MemoryStore) List() ([]string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	ids := make([]string, 0, len(store.states))
+	for id := range store.states {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// The extension of the files written by a `FileStore`, and the prefix of its
+// temporary files.
+const (
+	fileExt    = ".json"
+	tempPrefix = ".tmp-"
+)
+
+// FileStore is a `Store` that keeps each state in a file in a directory. States
+// are written to a temporary file that is then renamed, so a crash while
+// saving never leaves a partially written state.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a `FileStore` that keeps states in a directory,
+// creating the directory if it does not exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Save implements the `Store` interface.
+func (store *FileStore) Save(id string, state []byte) error {
+	tmp, err := os.CreateTemp(store.dir, tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(state); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), store.path(id)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Load implements the `Store` interface.
+func (store *FileStore) Load(id string) ([]byte, error) {
+	state, err := os.ReadFile(store.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return state, err
+}
+
+// Delete implements the `Store` interface.
+func (store *FileStore) Delete(id string) error {
+	if err := os.Remove(store.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List implements the `Store` interface.
+func (store *FileStore) List() ([]string, error) {
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, tempPrefix) || !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		id, err := url.PathUnescape(strings.TrimSuffix(name, fileExt))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// path returns the path of the file for an instance. IDs are escaped so that
+// they are always valid file names, and so that they never begin with a dot
+// (and cannot be mistaken for temporary files).
+func (store *FileStore) path(id string) string {
+	name := url.PathEscape(id)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return filepath.Join(store.dir, name+fileExt)
+}
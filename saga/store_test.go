@@ -0,0 +1,83 @@
+package saga_test
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/saga"
+)
+
+var _ = Describe("Stores", func() {
+
+	testStore := func(newStore func() Store) {
+
+		It("should save, load, and delete states", func() {
+			store := newStore()
+			_, err := store.Load("a")
+			Expect(err).To(Equal(ErrNotFound))
+
+			Expect(store.Save("a", []byte("1"))).To(Succeed())
+			Expect(store.Save("a", []byte("2"))).To(Succeed())
+			state, err := store.Load("a")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(state).To(Equal([]byte("2")))
+
+			Expect(store.Delete("a")).To(Succeed())
+			Expect(store.Delete("a")).To(Succeed())
+			_, err = store.Load("a")
+			Expect(err).To(Equal(ErrNotFound))
+		})
+
+		It("should list states in order", func() {
+			store := newStore()
+			ids := []string{"", "../escape", ".hidden", "a/b", "b", "c d"}
+			for _, id := range []string{"c d", "b", "a/b", "../escape", ".hidden", ""} {
+				Expect(store.Save(id, []byte(id))).To(Succeed())
+			}
+			Expect(store.List()).To(Equal(ids))
+			for _, id := range ids {
+				Expect(store.Load(id)).To(Equal([]byte(id)))
+			}
+		})
+	}
+
+	Context("when using a memory store", func() {
+		testStore(func() Store {
+			return NewMemoryStore()
+		})
+	})
+
+	Context("when using a file store", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "saga")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		testStore(func() Store {
+			store, err := NewFileStore(dir)
+			Expect(err).ToNot(HaveOccurred())
+			return store
+		})
+
+		It("should keep states across stores", func() {
+			store, err := NewFileStore(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(store.Save("a", []byte("1"))).To(Succeed())
+
+			store, err = NewFileStore(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(store.Load("a")).To(Equal([]byte("1")))
+			entries, err := os.ReadDir(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,298 @@
+package stream
+
+import (
+	"time"
+
+	"github.com/renproject/phi/task"
+)
+
+// timer is the message that time-based operators send to themselves. The
+// generation identifies the timer, so that timers that have been replaced are
+// ignored.
+type timer struct {
+	generation uint64
+}
+
+// IsMessage implements the `task.Message` interface.
+func (timer) IsMessage() {}
+
+// Map returns a stream of the results of calling a function on every value of
+// a stream.
+func Map[T, U any](s *Stream[T], f func(T) U) *Stream[U] {
+	n := newNode(s.opts)
+	handler := task.HandlerFunc(func(_ task.Task, m task.Message) {
+		if n.ended {
+			return
+		}
+		switch m := m.(type) {
+		case item[T]:
+			n.emit(item[U]{value: f(m.value)})
+		case end:
+			n.finish(m.err)
+		}
+	})
+	return chain[T, U](s, n, handler)
+}
+
+// Filter returns a stream of the values of a stream for which a predicate is
+// true.
+func Filter[T any](s *Stream[T], pred func(T) bool) *Stream[T] {
+	n := newNode(s.opts)
+	handler := task.HandlerFunc(func(_ task.Task, m task.Message) {
+		if n.ended {
+			return
+		}
+		switch m := m.(type) {
+		case item[T]:
+			if pred(m.value) {
+				n.emit(m)
+			}
+		case end:
+			n.finish(m.err)
+		}
+	})
+	return chain[T, T](s, n, handler)
+}
+
+// Window returns a stream of windows of values from a stream, where each
+// window has `size` values (except the last window, which has the values that
+// are left when the stream ends). It panics if the size is less than 1.
+func Window[T any](s *Stream[T], size int) *Stream[[]T] {
+	if size < 1 {
+		panic("stream error: window size must be positive")
+	}
+	n := newNode(s.opts)
+	var window []T
+	handler := task.HandlerFunc(func(_ task.Task, m task.Message) {
+		if n.ended {
+			return
+		}
+		switch m := m.(type) {
+		case item[T]:
+			window = append(window, m.value)
+			if len(window) == size {
+				n.emit(item[[]T]{value: window})
+				window = nil
+			}
+		case end:
+			if len(window) > 0 {
+				n.emit(item[[]T]{value: window})
+			}
+			n.finish(m.err)
+		}
+	})
+	return chain[T, []T](s, n, handler)
+}
+
+// WindowTime returns a stream of windows of values from a stream, where each
+// window has the values received within a duration. A window opens when a
+// value is received while no window is open, so windows are never empty. The
+// window that is open when the stream ends is emitted straight away.
+func WindowTime[T any](s *Stream[T], d time.Duration) *Stream[[]T] {
+	n := newNode(s.opts)
+	var window []T
+	var generation uint64
+	handler := task.HandlerFunc(func(self task.Task, m task.Message) {
+		if n.ended {
+			return
+		}
+		switch m := m.(type) {
+		case item[T]:
+			if len(window) == 0 {
+				generation++
+				n.after(self, d, timer{generation: generation})
+			}
+			window = append(window, m.value)
+		case timer:
+			if m.generation == generation && len(window) > 0 {
+				n.emit(item[[]T]{value: window})
+				window = nil
+			}
+		case end:
+			if len(window) > 0 {
+				n.emit(item[[]T]{value: window})
+			}
+			n.finish(m.err)
+		}
+	})
+	return chain[T, []T](s, n, handler)
+}
+
+// Throttle returns a stream of values from a stream, where values are dropped
+// if they are received within a duration of the last value that was not
+// dropped.
+func Throttle[T any](s *Stream[T], d time.Duration) *Stream[T] {
+	n := newNode(s.opts)
+	var last time.Time
+	emitted := false
+	handler := task.HandlerFunc(func(_ task.Task, m task.Message) {
+		if n.ended {
+			return
+		}
+		switch m := m.(type) {
+		case item[T]:
+			now := n.opts.Clock.Now()
+			if emitted && now.Sub(last) < d {
+				return
+			}
+			emitted, last = true, now
+			n.emit(m)
+		case end:
+			n.finish(m.err)
+		}
+	})
+	return chain[T, T](s, n, handler)
+}
+
+// Debounce returns a stream of values from a stream, where values are only
+// emitted once no other value has been received for a duration. Values that
+// are followed by another value within the duration are dropped. The last
+// value is emitted straight away when the stream ends.
+func Debounce[T any](s *Stream[T], d time.Duration) *Stream[T] {
+	n := newNode(s.opts)
+	var pending *item[T]
+	var generation uint64
+	handler := task.HandlerFunc(func(self task.Task, m task.Message) {
+		if n.ended {
+			return
+		}
+		switch m := m.(type) {
+		case item[T]:
+			pending = &m
+			generation++
+			n.after(self, d, timer{generation: generation})
+		case timer:
+			if m.generation == generation && pending != nil {
+				n.emit(*pending)
+				pending = nil
+			}
+		case end:
+			if pending != nil {
+				n.emit(*pending)
+			}
+			n.finish(m.err)
+		}
+	})
+	return chain[T, T](s, n, handler)
+}
+
+// Merge returns a stream of the values of all of the given streams, in the
+// order in which they are received. The stream ends when all of the streams
+// have ended, or when one of them fails. It panics if no streams are given.
+func Merge[T any](streams ...*Stream[T]) *Stream[T] {
+	if len(streams) == 0 {
+		panic("stream error: nothing to merge")
+	}
+	n := newNode(streams[0].opts)
+	ended := 0
+	handler := task.HandlerFunc(func(_ task.Task, m task.Message) {
+		if n.ended {
+			return
+		}
+		switch m := m.(type) {
+		case item[T]:
+			n.emit(m)
+		case end:
+			if ended++; m.err != nil || ended == len(streams) {
+				n.finish(m.err)
+			}
+		}
+	})
+
+	ports := make([]*port, len(streams))
+	runners := make([][]task.Runner, len(streams))
+	for i, s := range streams {
+		ports[i], runners[i] = s.port, s.upstream
+	}
+	merged := operator[T](n.opts, n, handler, ports...)
+	merged.upstream = upstream(merged.upstream[0], runners...)
+	return merged
+}
+
+// Pair is a pair of values from two streams.
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+// side is the message that zip receives from one of its streams.
+type side struct {
+	second bool
+	m      task.Message
+}
+
+// IsMessage implements the `task.Message` interface.
+func (side) IsMessage() {}
+
+// Zip returns a stream of pairs of values from two streams, where the nth pair
+// has the nth value of each stream. The stream ends when either stream has
+// ended and all of its values have been paired, or when either stream fails.
+//
+// Each stream has its own credits, so a stream that emits values faster than
+// the other cannot use up all of the room in the buffer of zip; it waits
+// until the values that it has sent have been paired instead.
+func Zip[A, B any](a *Stream[A], b *Stream[B]) *Stream[Pair[A, B]] {
+	n := newNode(a.opts)
+	var firsts []A
+	var seconds []B
+	var firstEnded, secondEnded bool
+	credits := [2]*task.Credits{task.NewCredits(n.opts.Cap), task.NewCredits(n.opts.Cap)}
+	handler := task.HandlerFunc(func(_ task.Task, m task.Message) {
+		if n.ended {
+			return
+		}
+		in := m.(side)
+		switch m := in.m.(type) {
+		case end:
+			if m.err != nil {
+				n.finish(m.err)
+				return
+			}
+			if in.second {
+				secondEnded = true
+			} else {
+				firstEnded = true
+			}
+		default:
+			if in.second {
+				seconds = append(seconds, m.(item[B]).value)
+			} else {
+				firsts = append(firsts, m.(item[A]).value)
+			}
+		}
+		for len(firsts) > 0 && len(seconds) > 0 {
+			n.emit(item[Pair[A, B]]{value: Pair[A, B]{First: firsts[0], Second: seconds[0]}})
+			firsts, seconds = firsts[1:], seconds[1:]
+			credits[0].Grant(1)
+			credits[1].Grant(1)
+		}
+		if firstEnded && len(firsts) == 0 || secondEnded && len(seconds) == 0 {
+			n.finish(nil)
+		}
+	})
+
+	// Both streams can use all of their credits, so the buffer has room for
+	// both.
+	t := task.New(handler, task.Options{Cap: 2 * n.opts.Cap})
+	for i, p := range []*port{a.port, b.port} {
+		second := i == 1
+		p.connect(&outlet{
+			sender: task.ChainSend(task.SenderFunc(func(m task.Message) bool {
+				return t.Send(side{second: second, m: m})
+			}), credits[i].SendInterceptor()),
+			credits: credits[i],
+		})
+	}
+	return &Stream[Pair[A, B]]{
+		opts:     n.opts,
+		port:     n.port,
+		upstream: upstream(stage{node: n, task: t}, a.upstream, b.upstream),
+	}
+}
+
+// chain connects a stream to the handler of an operator.
+func chain[T, U any](s *Stream[T], n *node, handler task.Handler) *Stream[U] {
+	out := operator[U](n.opts, n, handler, s.port)
+	out.upstream = upstream(out.upstream[0], s.upstream)
+	return out
+}
@@ -0,0 +1,82 @@
+package stream
+
+import (
+	"context"
+	"sync"
+
+	"github.com/renproject/phi/task"
+)
+
+// A Sink is the end of a stream. It runs the tasks of the stream, and receives
+// its values.
+type Sink[T any] struct {
+	upstream []task.Runner
+	values   []T
+	done     chan error
+}
+
+// Collect returns a `Sink` that collects the values of a stream, so that they
+// are returned by `Sink.Run`.
+func Collect[T any](s *Stream[T]) *Sink[T] {
+	return newSink(s, nil)
+}
+
+// ForEach returns a `Sink` that calls a function with each value of a stream,
+// instead of collecting them. Values are not received while the function is
+// running, so a slow function applies back-pressure to the stream.
+func ForEach[T any](s *Stream[T], f func(T)) *Sink[T] {
+	return newSink(s, f)
+}
+
+func newSink[T any](s *Stream[T], f func(T)) *Sink[T] {
+	sink := &Sink[T]{done: make(chan error, 1)}
+	n := newNode(s.opts)
+	handler := task.HandlerFunc(func(_ task.Task, m task.Message) {
+		if n.ended {
+			return
+		}
+		switch m := m.(type) {
+		case item[T]:
+			if f != nil {
+				f(m.value)
+			} else {
+				sink.values = append(sink.values, m.value)
+			}
+		case end:
+			n.ended = true
+			sink.done <- m.err
+		}
+	})
+	out := operator[T](n.opts, n, handler, s.port)
+	sink.upstream = upstream(out.upstream[0], s.upstream)
+	return sink
+}
+
+// Run the stream until it ends, or the context is done. It returns the values
+// that were collected (which is nil for a sink that was returned by
+// `ForEach`), and the error that the stream failed with, or the error of the
+// context. All of the tasks of the stream have stopped by the time it
+// returns. A sink can only be run once.
+func (sink *Sink[T]) Run(ctx context.Context) ([]T, error) {
+	inner, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wg := new(sync.WaitGroup)
+	for _, runner := range sink.upstream {
+		wg.Add(1)
+		go func(runner task.Runner) {
+			defer wg.Done()
+			runner.Run(inner)
+		}(runner)
+	}
+
+	var err error
+	select {
+	case err = <-sink.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	cancel()
+	wg.Wait()
+	return sink.values, err
+}
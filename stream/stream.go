@@ -0,0 +1,270 @@
+// Package stream processes continuous flows of values with tasks. A stream
+// starts at a `Source`, passes through operators (such as `Map`, `Filter`,
+// `Window`, and `Merge`), each of which is a task, and ends at a `Sink`, which
+// runs all of the tasks and collects the results.
+//
+// Back-pressure is honoured end-to-end. Every operator uses credit-based flow
+// control (see `task.Credits`), and waits for the next operator to have room
+// before passing a value on, so a slow sink slows down every operator before
+// it, and eventually the source, instead of values being dropped or buffered
+// without bound.
+package stream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/renproject/phi/task"
+)
+
+// DefaultCap is the default capacity of the buffer of each operator.
+const DefaultCap = 16
+
+// Options are passed when constructing a source, and are used by every
+// operator after it. The `Cap` is the capacity of the buffer of each operator;
+// if it is less than 1, the `DefaultCap` is used. The `Clock` is used by
+// operators that depend on time; if it is nil, the `task.SystemClock` is
+// used.
+type Options struct {
+	Cap   int
+	Clock task.Clock
+}
+
+// A Stream is a flow of values of type `T`, from a source or an operator. A
+// stream can only be passed to one operator or sink.
+type Stream[T any] struct {
+	opts     Options
+	port     *port
+	upstream []task.Runner
+}
+
+// item is the message that carries a value through a stream.
+type item[T any] struct {
+	value T
+}
+
+// IsMessage implements the `task.Message` interface.
+func (item[T]) IsMessage() {}
+
+// end is the message that ends a stream, with an error if the stream failed.
+type end struct {
+	err error
+}
+
+// IsMessage implements the `task.Message` interface.
+func (end) IsMessage() {}
+
+// outlet is where a stage sends the messages that it emits. Messages that are
+// rejected by the sender are sent again once the credits are ready.
+type outlet struct {
+	sender  task.Sender
+	credits *task.Credits
+}
+
+// port is the outlet of a stream, which is connected when the stream is
+// passed to an operator or sink.
+type port struct {
+	out *outlet
+}
+
+// connect the port to an outlet. It panics if the port is already connected.
+func (p *port) connect(out *outlet) {
+	if p.out != nil {
+		panic("stream error: stream is already connected")
+	}
+	p.out = out
+}
+
+// send a message to an outlet, waiting for credits when it is rejected. It
+// returns false if the context is done first.
+func send(ctx context.Context, out *outlet, m task.Message) bool {
+	if out == nil {
+		panic("stream error: stream is not connected")
+	}
+	for !out.sender.Send(m) {
+		select {
+		case <-out.credits.Ready():
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// node is the state that is shared by the handlers of all operators.
+type node struct {
+	opts  Options
+	port  *port
+	in    *task.Credits
+	ctx   context.Context
+	ended bool
+}
+
+func newNode(opts Options) *node {
+	return &node{opts: opts, port: new(port)}
+}
+
+// emit a message to the next operator.
+func (n *node) emit(m task.Message) bool {
+	return send(n.ctx, n.port.out, m)
+}
+
+// finish the stream, unless it has already finished. Operators ignore all
+// messages after they have finished.
+func (n *node) finish(err error) {
+	if n.ended {
+		return
+	}
+	n.ended = true
+	n.emit(end{err: err})
+}
+
+// after sends a message to the task of the operator once a duration has
+// elapsed.
+func (n *node) after(self task.Task, d time.Duration, m task.Message) {
+	ctx := n.ctx
+	go func() {
+		select {
+		case <-n.opts.Clock.After(d):
+		case <-ctx.Done():
+			return
+		}
+		send(ctx, &outlet{sender: self, credits: n.in}, m)
+	}()
+}
+
+// stage runs the task of an operator. The context is given to the node before
+// the task starts, so that the handler can stop waiting for credits when the
+// stream stops.
+type stage struct {
+	node *node
+	task task.Task
+}
+
+func (s stage) Run(ctx context.Context) {
+	s.node.ctx = ctx
+	s.task.Run(ctx)
+}
+
+// operator creates the task for a handler, connects the inputs to it, and
+// returns the stream of its output.
+func operator[T any](opts Options, n *node, handler task.Handler, inputs ...*port) *Stream[T] {
+	n.in = task.NewCredits(opts.Cap)
+	t := task.New(handler, task.Options{Cap: opts.Cap, Credits: n.in})
+	for _, input := range inputs {
+		input.connect(&outlet{sender: t, credits: n.in})
+	}
+	return &Stream[T]{opts: opts, port: n.port, upstream: []task.Runner{stage{node: n, task: t}}}
+}
+
+// upstream returns the runners of streams, followed by another runner.
+func upstream(runner task.Runner, streams ...[]task.Runner) []task.Runner {
+	var runners []task.Runner
+	for _, s := range streams {
+		runners = append(runners, s...)
+	}
+	return append(runners, runner)
+}
+
+func defaults(opts Options) Options {
+	if opts.Cap < 1 {
+		opts.Cap = DefaultCap
+	}
+	if opts.Clock == nil {
+		opts.Clock = task.SystemClock
+	}
+	return opts
+}
+
+// runFunc is an adapter that allows an ordinary function to be used as a
+// `task.Runner`.
+type runFunc func(context.Context)
+
+func (f runFunc) Run(ctx context.Context) {
+	f(ctx)
+}
+
+// A Source emits values into a stream. Values are emitted by calling `Emit`,
+// and the stream is ended by calling `Close`. The stream of the source must be
+// connected to a sink before values are emitted.
+type Source[T any] struct {
+	opts Options
+	port *port
+	mu   *sync.Mutex
+}
+
+// NewSource returns a `Source` with no values.
+func NewSource[T any](opts Options) *Source[T] {
+	return &Source[T]{opts: defaults(opts), port: new(port), mu: new(sync.Mutex)}
+}
+
+// Stream returns the stream of values that are emitted by the source.
+func (src *Source[T]) Stream() *Stream[T] {
+	return &Stream[T]{opts: src.opts, port: src.port}
+}
+
+// Emit a value into the stream. It blocks until the next operator has room for
+// the value, or the context is done, in which case it returns the error of the
+// context. It is safe to call concurrently, but values that are emitted
+// concurrently can be emitted in any order.
+func (src *Source[T]) Emit(ctx context.Context, value T) error {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if !send(ctx, src.port.out, item[T]{value: value}) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Close ends the stream. If the error is not nil, the stream fails with the
+// error (see `Sink.Run`). No values can be emitted after the stream has
+// ended. It blocks in the same way as `Emit`.
+func (src *Source[T]) Close(ctx context.Context, err error) error {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if !send(ctx, src.port.out, end{err: err}) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// FromSlice returns a stream of the values in a slice, which ends after the
+// last value.
+func FromSlice[T any](opts Options, values ...T) *Stream[T] {
+	src := NewSource[T](opts)
+	s := src.Stream()
+	s.upstream = []task.Runner{runFunc(func(ctx context.Context) {
+		for _, value := range values {
+			if src.Emit(ctx, value) != nil {
+				return
+			}
+		}
+		src.Close(ctx, nil)
+	})}
+	return s
+}
+
+// FromChan returns a stream of the values received from a channel, which ends
+// when the channel is closed.
+func FromChan[T any](opts Options, ch <-chan T) *Stream[T] {
+	src := NewSource[T](opts)
+	s := src.Stream()
+	s.upstream = []task.Runner{runFunc(func(ctx context.Context) {
+		for {
+			select {
+			case value, ok := <-ch:
+				if !ok {
+					src.Close(ctx, nil)
+					return
+				}
+				if src.Emit(ctx, value) != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	})}
+	return s
+}
@@ -0,0 +1,294 @@
+package stream_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/stream"
+)
+
+// fakeClock is a `task.Clock` that only advances when it is told to.
+type fakeClock struct {
+	mu      *sync.Mutex
+	now     time.Time
+	nows    int
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{mu: new(sync.Mutex), now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nows++
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+// Nows returns the number of times that the time has been read.
+func (c *fakeClock) Nows() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nows
+}
+
+func (c *fakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// result is the result of running a sink.
+type result[T any] struct {
+	values []T
+	err    error
+}
+
+// run a sink in the background.
+func run[T any](ctx context.Context, sink *Sink[T]) chan result[T] {
+	results := make(chan result[T], 1)
+	go func() {
+		values, err := sink.Run(ctx)
+		results <- result[T]{values: values, err: err}
+	}()
+	return results
+}
+
+var _ = Describe("Streams", func() {
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	Context("when mapping and filtering", func() {
+		It("should transform every value in order", func() {
+			s := FromSlice(Options{Cap: 2}, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+			evens := Filter(s, func(i int) bool { return i%2 == 0 })
+			strs := Map(evens, strconv.Itoa)
+			values, err := Collect(strs).Run(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(Equal([]string{"2", "4", "6", "8", "10"}))
+		})
+
+		It("should end empty streams", func() {
+			values, err := Collect(FromSlice[int](Options{})).Run(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(BeEmpty())
+		})
+
+		It("should panic when a stream is connected twice", func() {
+			s := FromSlice(Options{}, 1)
+			Map(s, func(i int) int { return i })
+			Expect(func() { Map(s, func(i int) int { return i }) }).To(Panic())
+		})
+	})
+
+	Context("when windowing by count", func() {
+		It("should emit full windows, and then what is left", func() {
+			values, err := Collect(Window(FromSlice(Options{}, 1, 2, 3, 4, 5, 6, 7), 3)).Run(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(Equal([][]int{{1, 2, 3}, {4, 5, 6}, {7}}))
+			Expect(func() { Window(FromSlice(Options{}, 1), 0) }).To(Panic())
+		})
+	})
+
+	Context("when merging and zipping", func() {
+		It("should merge all of the values", func() {
+			a := FromSlice(Options{Cap: 1}, 1, 2, 3)
+			b := FromSlice(Options{Cap: 1}, 4, 5)
+			c := FromSlice(Options{Cap: 1}, 6)
+			values, err := Collect(Merge(a, b, c)).Run(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(ConsistOf(1, 2, 3, 4, 5, 6))
+		})
+
+		It("should pair values until the shorter stream ends", func() {
+			a := FromSlice(Options{Cap: 2}, 1, 2, 3, 4, 5, 6, 7, 8)
+			b := FromSlice(Options{Cap: 2}, "a", "b", "c")
+			values, err := Collect(Zip(a, b)).Run(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(Equal([]Pair[int, string]{{1, "a"}, {2, "b"}, {3, "c"}}))
+		})
+
+		It("should pair values from streams of the same type", func() {
+			a := FromSlice(Options{}, 1, 2)
+			b := FromSlice(Options{}, 3, 4)
+			values, err := Collect(Zip(a, b)).Run(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(Equal([]Pair[int, int]{{1, 3}, {2, 4}}))
+		})
+	})
+
+	Context("when using time", func() {
+		var clock *fakeClock
+		var src *Source[int]
+
+		BeforeEach(func() {
+			clock = newFakeClock()
+			src = NewSource[int](Options{Clock: clock})
+		})
+
+		It("should throttle values", func() {
+			results := run(ctx, Collect(Throttle(src.Stream(), time.Second)))
+			for i := 0; i < 10; i++ {
+				Expect(src.Emit(ctx, i)).To(Succeed())
+				// Wait for the value to be handled before moving time.
+				Eventually(clock.Nows).Should(Equal(i + 1))
+				clock.Advance(300 * time.Millisecond)
+			}
+			Expect(src.Close(ctx, nil)).To(Succeed())
+			Eventually(results).Should(Receive(Equal(result[int]{values: []int{0, 4, 8}})))
+		})
+
+		It("should debounce values", func() {
+			emitted := make(chan int, 4)
+			results := run(ctx, ForEach(Debounce(src.Stream(), time.Second), func(v int) { emitted <- v }))
+			Expect(src.Emit(ctx, 1)).To(Succeed())
+			Eventually(clock.Waiters).Should(Equal(1))
+			clock.Advance(500 * time.Millisecond)
+			Expect(src.Emit(ctx, 2)).To(Succeed())
+			Eventually(clock.Waiters).Should(Equal(2))
+
+			// The first timer has been replaced, so nothing is emitted.
+			clock.Advance(500 * time.Millisecond)
+			Consistently(emitted, 10*time.Millisecond).ShouldNot(Receive())
+			clock.Advance(500 * time.Millisecond)
+			Eventually(emitted).Should(Receive(Equal(2)))
+
+			// The pending value is emitted when the stream ends.
+			Expect(src.Emit(ctx, 3)).To(Succeed())
+			Expect(src.Emit(ctx, 4)).To(Succeed())
+			Expect(src.Close(ctx, nil)).To(Succeed())
+			Eventually(results).Should(Receive(Equal(result[int]{})))
+			Expect(emitted).To(Receive(Equal(4)))
+			Expect(emitted).ToNot(Receive())
+		})
+
+		It("should window values by time", func() {
+			emitted := make(chan []int, 2)
+			results := run(ctx, ForEach(WindowTime(src.Stream(), time.Second), func(v []int) { emitted <- v }))
+			Expect(src.Emit(ctx, 1)).To(Succeed())
+			Expect(src.Emit(ctx, 2)).To(Succeed())
+			Eventually(clock.Waiters).Should(Equal(1))
+			clock.Advance(time.Second)
+			Eventually(emitted).Should(Receive(Equal([]int{1, 2})))
+
+			// Nothing is emitted while no window is open.
+			clock.Advance(5 * time.Second)
+			Consistently(emitted, 10*time.Millisecond).ShouldNot(Receive())
+			Expect(src.Emit(ctx, 3)).To(Succeed())
+			Expect(src.Close(ctx, nil)).To(Succeed())
+			Eventually(results).Should(Receive(Equal(result[[]int]{})))
+			Expect(emitted).To(Receive(Equal([]int{3})))
+		})
+	})
+
+	Context("when the sink is slow", func() {
+		It("should apply back-pressure to the source", func() {
+			src := NewSource[int](Options{Cap: 2})
+			doubled := Map(src.Stream(), func(i int) int { return 2 * i })
+			release := make(chan struct{})
+			received := int64(0)
+			results := run(ctx, ForEach(doubled, func(int) {
+				<-release
+				atomic.AddInt64(&received, 1)
+			}))
+
+			emitted := int64(0)
+			go func() {
+				for i := 0; i < 100; i++ {
+					if src.Emit(ctx, i) != nil {
+						return
+					}
+					atomic.AddInt64(&emitted, 1)
+				}
+				src.Close(ctx, nil)
+			}()
+
+			// The map and the sink can each buffer two values, and hold one
+			// more while they wait to pass it on (or to handle it).
+			Eventually(func() int64 { return atomic.LoadInt64(&emitted) }).Should(Equal(int64(6)))
+			Consistently(func() int64 { return atomic.LoadInt64(&emitted) }, 50*time.Millisecond).Should(Equal(int64(6)))
+
+			close(release)
+			Eventually(results).Should(Receive(Equal(result[int]{})))
+			Expect(atomic.LoadInt64(&received)).To(Equal(int64(100)))
+		})
+	})
+
+	Context("when a stream fails or is cancelled", func() {
+		It("should return the error and the values so far", func() {
+			src := NewSource[int](Options{})
+			results := run(ctx, Collect(Map(src.Stream(), func(i int) int { return i + 1 })))
+			Expect(src.Emit(ctx, 1)).To(Succeed())
+			Expect(src.Close(ctx, errors.New("source failed"))).To(Succeed())
+
+			var r result[int]
+			Eventually(results).Should(Receive(&r))
+			Expect(r.values).To(Equal([]int{2}))
+			Expect(r.err).To(MatchError("source failed"))
+		})
+
+		It("should fail merged streams when one of them fails", func() {
+			src := NewSource[int](Options{})
+			results := run(ctx, Collect(Merge(src.Stream(), FromChan(Options{}, make(chan int)))))
+			Expect(src.Close(ctx, errors.New("source failed"))).To(Succeed())
+			var r result[int]
+			Eventually(results).Should(Receive(&r))
+			Expect(r.err).To(MatchError("source failed"))
+		})
+
+		It("should stop when the context is done", func() {
+			ch := make(chan int)
+			inner, cancelInner := context.WithCancel(ctx)
+			results := run(inner, Collect(FromChan(Options{}, ch)))
+			ch <- 1
+			cancelInner()
+			var r result[int]
+			Eventually(results).Should(Receive(&r))
+			Expect(r.err).To(Equal(context.Canceled))
+		})
+	})
+})
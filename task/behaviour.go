@@ -0,0 +1,94 @@
+package task
+
+// behaviour wraps a `Handler` so that it can be stored in an `atomic.Value`
+// (which requires values to always have the same concrete type).
+type behaviour struct {
+	handler Handler
+}
+
+// current returns the current behaviour of the task.
+func (task *task) current() Handler {
+	return task.behaviour.Load().(behaviour).handler
+}
+
+// Become implements the `BehaviourTask` interface.
+func (task *task) Become(handler Handler) {
+	task.behaviourMu.Lock()
+	defer task.behaviourMu.Unlock()
+	task.behaviours[len(task.behaviours)-1] = handler
+	task.behaviour.Store(behaviour{handler})
+}
+
+// BecomeStacked implements the `BehaviourTask` interface.
+func (task *task) BecomeStacked(handler Handler) {
+	task.behaviourMu.Lock()
+	defer task.behaviourMu.Unlock()
+	task.behaviours = append(task.behaviours, handler)
+	task.behaviour.Store(behaviour{handler})
+}
+
+// Unbecome implements the `BehaviourTask` interface.
+func (task *task) Unbecome() {
+	task.behaviourMu.Lock()
+	defer task.behaviourMu.Unlock()
+	if len(task.behaviours) == 1 {
+		return
+	}
+	task.behaviours[len(task.behaviours)-1] = nil
+	task.behaviours = task.behaviours[:len(task.behaviours)-1]
+	task.behaviour.Store(behaviour{task.behaviours[len(task.behaviours)-1]})
+}
+
+// Stash implements the `BehaviourTask` interface.
+func (task *task) Stash(m Message) bool {
+	task.stashMu.Lock()
+	defer task.stashMu.Unlock()
+	if task.stashCap > 0 && len(task.stash) >= task.stashCap {
+		if l := task.log(); l != nil {
+			l.Warn("message not stashed", task.attrs(m, "stashed", len(task.stash))...)
+		}
+		return false
+	}
+	task.stash = append(task.stash, m)
+	return true
+}
+
+// UnstashAll implements the `BehaviourTask` interface. Stashed messages are placed in
+// front of any messages that were unstashed earlier, but have not yet been
+// handled.
+func (task *task) UnstashAll() {
+	task.stashMu.Lock()
+	defer task.stashMu.Unlock()
+	if len(task.stash) == 0 {
+		return
+	}
+	task.unstashed = append(task.stash, task.unstashed...)
+	task.stash = nil
+}
+
+// handleUnstashed handles messages that have been unstashed, and reports
+// whether or not there were any. In batching mode, all unstashed messages are
+// handled as one batch; otherwise, only the first unstashed message is
+// handled, so that messages unstashed while it is handled are still handled
+// first.
+func (task *task) handleUnstashed() bool {
+	task.stashMu.Lock()
+	if len(task.unstashed) == 0 {
+		task.stashMu.Unlock()
+		return false
+	}
+	var batch Messages
+	if task.batchSize > 0 {
+		batch, task.unstashed = task.unstashed, nil
+	} else {
+		batch, task.unstashed = task.unstashed[:1], task.unstashed[1:]
+	}
+	task.stashMu.Unlock()
+
+	if task.batchSize > 0 {
+		task.handleBatch(batch, false)
+	} else {
+		task.handle(batch[0])
+	}
+	return true
+}
@@ -0,0 +1,187 @@
+package task_test
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/task"
+)
+
+// ready is the message that finishes the initialisation of an `initialiser`.
+type ready struct{}
+
+func (ready) IsMessage() {}
+
+// initialiser is a `Handler` that stashes messages until it is ready, and then
+// becomes its recorder.
+type initialiser struct {
+	*recorder
+	stashed chan bool
+}
+
+func (i initialiser) Handle(self Task, m Message) {
+	if _, ok := m.(ready); ok {
+		self.(BehaviourTask).Become(i.recorder)
+		self.(BehaviourTask).UnstashAll()
+		return
+	}
+	i.stashed <- self.(BehaviourTask).Stash(m)
+}
+
+// labeller returns a `Handler` that writes each message key, labelled, to a
+// channel.
+func labeller(label string, out chan string, f func(Task, int)) Handler {
+	return HandlerFunc(func(self Task, m Message) {
+		key := m.(testMessage).key
+		out <- fmt.Sprintf("%v%v", label, key)
+		if f != nil {
+			f(self, key)
+		}
+	})
+}
+
+var _ = Describe("Behaviours", func() {
+
+	run := func(t Task) context.CancelFunc {
+		ctx, cancel := context.WithCancel(context.Background())
+		go t.Run(ctx)
+		return cancel
+	}
+
+	Context("when a handler becomes another handler", func() {
+
+		It("should handle later messages with the new handler", func() {
+			out := make(chan string, 10)
+			b := labeller("b", out, nil)
+			a := labeller("a", out, func(self Task, key int) {
+				if key == 1 {
+					self.(BehaviourTask).Become(b)
+				}
+			})
+			t := New(a, Options{Cap: 10})
+			for i := 0; i < 3; i++ {
+				Expect(t.Send(testMessage{key: i})).To(BeTrue())
+			}
+			defer run(t)()
+
+			Eventually(out).Should(Receive(Equal("a0")))
+			Eventually(out).Should(Receive(Equal("a1")))
+			Eventually(out).Should(Receive(Equal("b2")))
+		})
+
+		It("should return to earlier handlers when unbecoming", func() {
+			out := make(chan string, 10)
+			var a, b Handler
+			b = labeller("b", out, func(self Task, key int) {
+				self.(BehaviourTask).Unbecome()
+			})
+			a = labeller("a", out, func(self Task, key int) {
+				switch key {
+				case 0:
+					self.(BehaviourTask).BecomeStacked(b)
+				case 2:
+					// The original handler is never popped.
+					self.(BehaviourTask).Unbecome()
+				}
+			})
+			t := New(a, Options{Cap: 10})
+			for i := 0; i < 4; i++ {
+				Expect(t.Send(testMessage{key: i})).To(BeTrue())
+			}
+			defer run(t)()
+
+			for _, label := range []string{"a0", "b1", "a2", "a3"} {
+				Eventually(out).Should(Receive(Equal(label)))
+			}
+		})
+
+		It("should keep intercepting messages", func() {
+			tr := newTrace()
+			out := make(chan string, 10)
+			b := labeller("b", out, nil)
+			a := labeller("a", out, func(self Task, _ int) {
+				self.(BehaviourTask).Become(b)
+			})
+			t := New(a, Options{Cap: 10, Interceptors: []Interceptor{tr.interceptor("i")}})
+			Expect(t.Send(testMessage{key: 0})).To(BeTrue())
+			Expect(t.Send(testMessage{key: 1})).To(BeTrue())
+			defer run(t)()
+
+			Eventually(out).Should(Receive(Equal("a0")))
+			Eventually(out).Should(Receive(Equal("b1")))
+			Eventually(tr.get).Should(Equal([]string{
+				"i before 0", "i after 0", "i before 1", "i after 1",
+			}))
+		})
+
+		It("should use the optional interfaces of the new handler", func() {
+			r := errorRecorder{recorder: newRecorder(1), errs: make(chan error, 1)}
+			t := New(HandlerFunc(func(self Task, _ Message) {
+				self.(BehaviourTask).Become(r)
+			}), Options{Cap: 10, MaxDepth: 1})
+			Expect(t.Send(testMessage{})).To(BeTrue())
+			Expect(t.Send(Messages{Messages{testMessage{}}})).To(BeTrue())
+			defer run(t)()
+
+			Eventually(r.errs).Should(Receive(Equal(ErrMaxDepthExceeded)))
+		})
+	})
+
+	Context("when a handler stashes messages", func() {
+
+		It("should handle unstashed messages before other messages", func() {
+			r := newRecorder(10)
+			stashed := make(chan bool, 10)
+			t := New(initialiser{recorder: r, stashed: stashed}, Options{Cap: 10})
+			Expect(t.Send(testMessage{key: 0})).To(BeTrue())
+			Expect(t.Send(testMessage{key: 1})).To(BeTrue())
+			Expect(t.Send(ready{})).To(BeTrue())
+			Expect(t.Send(testMessage{key: 2})).To(BeTrue())
+			defer run(t)()
+
+			for i := 0; i < 3; i++ {
+				Eventually(r.messages).Should(Receive(Equal(testMessage{key: i})))
+			}
+			Expect(stashed).To(Receive(BeTrue()))
+			Expect(stashed).To(Receive(BeTrue()))
+		})
+
+		It("should unstash messages as a batch when batching", func() {
+			r := batchRecorder{newRecorder(10)}
+			stashed := make(chan bool, 10)
+			init := HandlerFunc(func(self Task, m Message) {
+				initialiser{recorder: r.recorder, stashed: stashed}.Handle(self, m)
+				if _, ok := m.(ready); ok {
+					self.(BehaviourTask).Become(r)
+				}
+			})
+			t := New(init, Options{Cap: 10, BatchSize: 1})
+			Expect(t.Send(testMessage{key: 0})).To(BeTrue())
+			Expect(t.Send(testMessage{key: 1})).To(BeTrue())
+			Expect(t.Send(ready{})).To(BeTrue())
+			defer run(t)()
+
+			Eventually(r.batches).Should(Receive(Equal(Messages{testMessage{key: 0}, testMessage{key: 1}})))
+		})
+
+		It("should not stash more messages than the stash capacity", func() {
+			r := newRecorder(10)
+			stashed := make(chan bool, 10)
+			t := New(initialiser{recorder: r, stashed: stashed}, Options{Cap: 10, StashCap: 2})
+			for i := 0; i < 3; i++ {
+				Expect(t.Send(testMessage{key: i})).To(BeTrue())
+			}
+			Expect(t.Send(ready{})).To(BeTrue())
+			defer run(t)()
+
+			Eventually(stashed).Should(Receive(BeTrue()))
+			Eventually(stashed).Should(Receive(BeTrue()))
+			Eventually(stashed).Should(Receive(BeFalse()))
+			Eventually(r.messages).Should(Receive(Equal(testMessage{key: 0})))
+			Eventually(r.messages).Should(Receive(Equal(testMessage{key: 1})))
+			Consistently(r.messages).ShouldNot(Receive())
+		})
+	})
+})
@@ -0,0 +1,180 @@
+package task
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a `CircuitBreaker`.
+type CircuitState int
+
+const (
+	// CircuitClosed is the state in which messages are sent normally.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen is the state in which messages are rejected without being
+	// sent.
+	CircuitOpen
+	// CircuitHalfOpen is the state in which a limited number of messages are
+	// sent to probe whether the underlying sender has recovered.
+	CircuitHalfOpen
+)
+
+// String implements the `fmt.Stringer` interface.
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(state))
+	}
+}
+
+// CircuitStateChanged is the message sent to the `Events` sender of a
+// `CircuitBreaker` whenever it changes state.
+type CircuitStateChanged struct {
+	Breaker *CircuitBreaker
+	From    CircuitState
+	To      CircuitState
+}
+
+// IsMessage implements the `Message` interface.
+func (CircuitStateChanged) IsMessage() {}
+
+// Default values used by a `CircuitBreaker` when its options are zero.
+const (
+	DefaultFailureThreshold = 5
+	DefaultCooldown         = time.Second
+	DefaultHalfOpenProbes   = 1
+)
+
+// CircuitBreakerOptions are passed when constructing a `CircuitBreaker`. The
+// breaker opens after `FailureThreshold` consecutive failed sends, and stays
+// open for `Cooldown` before half-opening. While half-open, at most
+// `HalfOpenProbes` messages are sent; if all of them succeed the breaker
+// closes, and if any of them fails it opens again. If `Events` is not nil, a
+// `CircuitStateChanged` message is sent to it on every change of state (events
+// that it rejects are dropped). Events are sent while the breaker is locked, so
+// `Events` must not block, or send to the breaker (a task does neither). If
+// `Clock` is nil, the `SystemClock` is used.
+type CircuitBreakerOptions struct {
+	FailureThreshold int
+	Cooldown         time.Duration
+	HalfOpenProbes   int
+	Events           Sender
+	Clock            Clock
+}
+
+// A CircuitBreaker is a `Sender` that stops sending messages to another
+// `Sender` when it keeps rejecting them (for example, because its buffer is
+// full, or because it has stopped). Instead of retrying against a sender that
+// is unlikely to accept messages, callers fail fast until the cooldown has
+// elapsed. It is safe for concurrent use.
+type CircuitBreaker struct {
+	sender Sender
+	opts   CircuitBreakerOptions
+
+	mu        *sync.Mutex
+	state     CircuitState
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+}
+
+// NewCircuitBreaker returns a closed `CircuitBreaker` that sends messages to
+// the given sender.
+func NewCircuitBreaker(sender Sender, opts CircuitBreakerOptions) *CircuitBreaker {
+	if opts.FailureThreshold < 1 {
+		opts.FailureThreshold = DefaultFailureThreshold
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = DefaultCooldown
+	}
+	if opts.HalfOpenProbes < 1 {
+		opts.HalfOpenProbes = DefaultHalfOpenProbes
+	}
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
+	}
+	return &CircuitBreaker{
+		sender: sender,
+		opts:   opts,
+		mu:     new(sync.Mutex),
+		state:  CircuitClosed,
+	}
+}
+
+// State returns the current state of the breaker. An open breaker whose
+// cooldown has elapsed is reported as open until the next message is sent.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// Send implements the `Sender` interface. It returns false, without sending
+// the message, if the breaker is open, or if it is half-open and already has
+// as many probes as it allows.
+func (cb *CircuitBreaker) Send(m Message) bool {
+	cb.mu.Lock()
+	if cb.state == CircuitOpen {
+		if cb.opts.Clock.Now().Sub(cb.openedAt) < cb.opts.Cooldown {
+			cb.mu.Unlock()
+			return false
+		}
+		cb.transition(CircuitHalfOpen)
+	}
+	state := cb.state
+	if state == CircuitHalfOpen {
+		if cb.probes >= cb.opts.HalfOpenProbes {
+			cb.mu.Unlock()
+			return false
+		}
+		cb.probes++
+	}
+	cb.mu.Unlock()
+
+	ok := cb.sender.Send(m)
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state != state {
+		// The breaker changed state while the message was being sent, so the
+		// result is no longer relevant.
+		return ok
+	}
+	switch state {
+	case CircuitClosed:
+		if ok {
+			cb.failures = 0
+		} else if cb.failures++; cb.failures >= cb.opts.FailureThreshold {
+			cb.transition(CircuitOpen)
+		}
+	case CircuitHalfOpen:
+		if !ok {
+			cb.transition(CircuitOpen)
+		} else if cb.successes++; cb.successes >= cb.opts.HalfOpenProbes {
+			cb.transition(CircuitClosed)
+		}
+	}
+	return ok
+}
+
+// transition to a new state, resetting the counters of the new state and
+// emitting an event. The mutex must be held.
+func (cb *CircuitBreaker) transition(to CircuitState) {
+	from := cb.state
+	cb.state = to
+	cb.failures, cb.probes, cb.successes = 0, 0, 0
+	if to == CircuitOpen {
+		cb.openedAt = cb.opts.Clock.Now()
+	}
+	if cb.opts.Events != nil {
+		cb.opts.Events.Send(CircuitStateChanged{Breaker: cb, From: from, To: to})
+	}
+}
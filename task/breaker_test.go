@@ -0,0 +1,163 @@
+package task_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/task"
+)
+
+// events is a `Sender` that records the state changes of circuit breakers.
+type events struct {
+	changes chan CircuitStateChanged
+}
+
+func newEvents() events {
+	return events{changes: make(chan CircuitStateChanged, 10)}
+}
+
+func (e events) Send(m Message) bool {
+	e.changes <- m.(CircuitStateChanged)
+	return true
+}
+
+var _ = Describe("Circuit breaker", func() {
+
+	var clock *fakeClock
+	var t *toggle
+	var evs events
+	var cb *CircuitBreaker
+
+	BeforeEach(func() {
+		clock = newFakeClock()
+		t = &toggle{on: false}
+		evs = newEvents()
+		cb = NewCircuitBreaker(t, CircuitBreakerOptions{
+			FailureThreshold: 3,
+			Cooldown:         time.Second,
+			HalfOpenProbes:   2,
+			Events:           evs,
+			Clock:            clock,
+		})
+	})
+
+	expectChange := func(from, to CircuitState) {
+		var change CircuitStateChanged
+		Expect(evs.changes).To(Receive(&change))
+		Expect(change.Breaker).To(Equal(cb))
+		Expect(change.From).To(Equal(from))
+		Expect(change.To).To(Equal(to))
+	}
+
+	open := func() {
+		for i := 0; i < 3; i++ {
+			Expect(cb.Send(testMessage{})).To(BeFalse())
+		}
+		Expect(cb.State()).To(Equal(CircuitOpen))
+		expectChange(CircuitClosed, CircuitOpen)
+	}
+
+	Context("when the sender accepts messages", func() {
+		It("should stay closed", func() {
+			t.on = true
+			for i := 0; i < 10; i++ {
+				Expect(cb.Send(testMessage{})).To(BeTrue())
+			}
+			Expect(cb.State()).To(Equal(CircuitClosed))
+			Expect(evs.changes).ToNot(Receive())
+		})
+	})
+
+	Context("when the sender keeps rejecting messages", func() {
+		It("should open after consecutive failures", func() {
+			open()
+		})
+
+		It("should not open when failures are not consecutive", func() {
+			for i := 0; i < 10; i++ {
+				t.on = i%3 == 0
+				cb.Send(testMessage{})
+			}
+			Expect(cb.State()).To(Equal(CircuitClosed))
+		})
+
+		It("should fail fast while open", func() {
+			open()
+			t.on = true
+			Expect(cb.Send(testMessage{})).To(BeFalse())
+			clock.Advance(999 * time.Millisecond)
+			Expect(cb.Send(testMessage{})).To(BeFalse())
+		})
+	})
+
+	Context("when the cooldown has elapsed", func() {
+		It("should close after enough successful probes", func() {
+			open()
+			clock.Advance(time.Second)
+			t.on = true
+			Expect(cb.Send(testMessage{})).To(BeTrue())
+			Expect(cb.State()).To(Equal(CircuitHalfOpen))
+			expectChange(CircuitOpen, CircuitHalfOpen)
+			Expect(cb.Send(testMessage{})).To(BeTrue())
+			Expect(cb.State()).To(Equal(CircuitClosed))
+			expectChange(CircuitHalfOpen, CircuitClosed)
+		})
+
+		It("should open again when a probe fails", func() {
+			open()
+			clock.Advance(time.Second)
+			Expect(cb.Send(testMessage{})).To(BeFalse())
+			expectChange(CircuitOpen, CircuitHalfOpen)
+			Expect(cb.State()).To(Equal(CircuitOpen))
+			expectChange(CircuitHalfOpen, CircuitOpen)
+
+			// The cooldown starts again.
+			t.on = true
+			Expect(cb.Send(testMessage{})).To(BeFalse())
+			clock.Advance(time.Second)
+			Expect(cb.Send(testMessage{})).To(BeTrue())
+		})
+
+		It("should not send more probes than allowed", func() {
+			blocker := &blockingSender{release: make(chan struct{}), entered: make(chan struct{}, 2)}
+			cb = NewCircuitBreaker(blocker, CircuitBreakerOptions{
+				FailureThreshold: 1,
+				HalfOpenProbes:   2,
+				Clock:            clock,
+			})
+			blocker.on = false
+			close(blocker.release)
+			Expect(cb.Send(testMessage{})).To(BeFalse())
+			Expect(blocker.entered).To(Receive())
+			Expect(cb.State()).To(Equal(CircuitOpen))
+			clock.Advance(time.Second)
+
+			blocker.release = make(chan struct{})
+			blocker.on = true
+			done := make(chan bool, 2)
+			for i := 0; i < 2; i++ {
+				go func() { done <- cb.Send(testMessage{}) }()
+				Eventually(blocker.entered).Should(Receive())
+			}
+			Expect(cb.Send(testMessage{})).To(BeFalse())
+			close(blocker.release)
+			Eventually(done).Should(Receive(BeTrue()))
+			Eventually(done).Should(Receive(BeTrue()))
+			Expect(cb.State()).To(Equal(CircuitClosed))
+		})
+	})
+})
+
+// blockingSender is a `Sender` that blocks until it is released.
+type blockingSender struct {
+	on      bool
+	release chan struct{}
+	entered chan struct{}
+}
+
+func (s *blockingSender) Send(Message) bool {
+	s.entered <- struct{}{}
+	<-s.release
+	return s.on
+}
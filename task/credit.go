@@ -0,0 +1,102 @@
+package task
+
+import "sync"
+
+// closed is a channel that is always closed.
+var closed = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// Credits implement credit-based flow control between producers and a
+// consumer. The consumer grants credits, and every message that a producer
+// sends takes one. When there are no credits, sends are rejected, and
+// producers can wait on the `Ready` channel to know when they can send again,
+// instead of retrying in a loop. As long as the consumer does not grant more
+// credits than it has room for, sends are never rejected because the consumer
+// is full.
+//
+// Credits can be used with a task by setting `Options.Credits`, or with any
+// sender by using the `SendInterceptor`. It is safe for concurrent use.
+type Credits struct {
+	mu      *sync.Mutex
+	n       int
+	waiting chan struct{}
+}
+
+// NewCredits returns `Credits` with an initial number of credits. When used
+// with a task, this is the most messages that can be in the buffer of the task
+// at once, so it should be no more than the capacity of the task.
+func NewCredits(initial int) *Credits {
+	return &Credits{mu: new(sync.Mutex), n: initial}
+}
+
+// Grant credits, allowing that many more messages to be sent. Producers that
+// are waiting for credits are woken up. Granting zero, or fewer, credits does
+// nothing.
+func (credits *Credits) Grant(n int) {
+	if n <= 0 {
+		return
+	}
+	credits.mu.Lock()
+	defer credits.mu.Unlock()
+	credits.n += n
+	if credits.waiting != nil {
+		close(credits.waiting)
+		credits.waiting = nil
+	}
+}
+
+// Available returns the number of credits that can be taken.
+func (credits *Credits) Available() int {
+	credits.mu.Lock()
+	defer credits.mu.Unlock()
+	return credits.n
+}
+
+// Ready returns a channel that is closed when there are credits available. If
+// there are already credits available, the channel is already closed. Other
+// producers can take the credits first, so a send after the channel is closed
+// can still be rejected, in which case the producer should wait again.
+func (credits *Credits) Ready() <-chan struct{} {
+	credits.mu.Lock()
+	defer credits.mu.Unlock()
+	if credits.n > 0 {
+		return closed
+	}
+	if credits.waiting == nil {
+		credits.waiting = make(chan struct{})
+	}
+	return credits.waiting
+}
+
+// SendInterceptor returns a `SendInterceptor` that takes a credit for every
+// message that is sent, and rejects messages when there are no credits.
+// Messages that are rejected by the next sender give their credit back.
+// Credits are not granted automatically; the consumer must call `Grant`.
+func (credits *Credits) SendInterceptor() SendInterceptor {
+	return func(next Sender) Sender {
+		return SenderFunc(func(m Message) bool {
+			if !credits.take() {
+				return false
+			}
+			if !next.Send(m) {
+				credits.Grant(1)
+				return false
+			}
+			return true
+		})
+	}
+}
+
+// take a credit, if there are any.
+func (credits *Credits) take() bool {
+	credits.mu.Lock()
+	defer credits.mu.Unlock()
+	if credits.n <= 0 {
+		return false
+	}
+	credits.n--
+	return true
+}
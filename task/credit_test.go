@@ -0,0 +1,148 @@
+package task_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/task"
+)
+
+// gate is a `Handler` that waits to be opened before handling each message,
+// and then writes it to a channel.
+type gate struct {
+	open    chan struct{}
+	handled chan Message
+}
+
+func newGate(n int) gate {
+	return gate{open: make(chan struct{}, n), handled: make(chan Message, n)}
+}
+
+func (g gate) Handle(_ Task, m Message) {
+	<-g.open
+	g.handled <- m
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+var _ = Describe("Flow control", func() {
+
+	Context("when granting and taking credits", func() {
+
+		It("should only send messages while there are credits", func() {
+			credits := NewCredits(2)
+			c := new(counter)
+			sender := ChainSend(c, credits.SendInterceptor())
+
+			Expect(isClosed(credits.Ready())).To(BeTrue())
+			Expect(sender.Send(testMessage{})).To(BeTrue())
+			Expect(sender.Send(testMessage{})).To(BeTrue())
+			Expect(sender.Send(testMessage{})).To(BeFalse())
+			Expect(c.count()).To(Equal(int64(2)))
+			Expect(credits.Available()).To(Equal(0))
+
+			ready := credits.Ready()
+			Expect(isClosed(ready)).To(BeFalse())
+			credits.Grant(0)
+			Expect(isClosed(ready)).To(BeFalse())
+			credits.Grant(1)
+			Expect(isClosed(ready)).To(BeTrue())
+			Expect(sender.Send(testMessage{})).To(BeTrue())
+			Expect(c.count()).To(Equal(int64(3)))
+		})
+
+		It("should give credits back when the next sender rejects a message", func() {
+			credits := NewCredits(1)
+			t := &toggle{}
+			sender := ChainSend(t, credits.SendInterceptor())
+			Expect(sender.Send(testMessage{})).To(BeFalse())
+			Expect(credits.Available()).To(Equal(1))
+			t.on = true
+			Expect(sender.Send(testMessage{})).To(BeTrue())
+			Expect(credits.Available()).To(Equal(0))
+		})
+
+		It("should wake up every producer that is waiting", func() {
+			credits := NewCredits(0)
+			woken := make(chan struct{}, 3)
+			for i := 0; i < 3; i++ {
+				ready := credits.Ready()
+				go func() {
+					<-ready
+					woken <- struct{}{}
+				}()
+			}
+			Consistently(woken, 10*time.Millisecond).ShouldNot(Receive())
+			credits.Grant(1)
+			for i := 0; i < 3; i++ {
+				Eventually(woken).Should(Receive())
+			}
+		})
+	})
+
+	Context("when a task has credits", func() {
+
+		It("should reject messages instead of filling its buffer", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			credits := NewCredits(2)
+			g := newGate(4)
+			t := New(g, Options{Cap: 4, Credits: credits})
+			go t.Run(ctx)
+
+			Expect(t.Send(testMessage{key: 1})).To(BeTrue())
+			Expect(t.Send(testMessage{key: 2})).To(BeTrue())
+			Expect(t.Send(testMessage{key: 3})).To(BeFalse())
+
+			// Taking a message from the buffer grants a credit, even before
+			// the message has been handled.
+			Eventually(credits.Ready()).Should(BeClosed())
+			Expect(t.Send(testMessage{key: 3})).To(BeTrue())
+			Expect(t.Send(testMessage{key: 4})).To(BeFalse())
+
+			for i := 1; i <= 3; i++ {
+				g.open <- struct{}{}
+				Eventually(g.handled).Should(Receive(Equal(testMessage{key: i})))
+			}
+			Eventually(credits.Available).Should(Equal(2))
+		})
+
+		It("should stream messages without rejections from a full buffer", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			credits := NewCredits(4)
+			r := newRecorder(100)
+			logger := newMemLogger()
+			t := New(r, Options{Cap: 4, Credits: credits, SendInterceptors: []SendInterceptor{evenOnly}, Logger: logger})
+			go t.Run(ctx)
+
+			// Messages rejected by other interceptors do not take credits.
+			Expect(t.Send(testMessage{key: 1})).To(BeFalse())
+			Expect(credits.Available()).To(Equal(4))
+
+			go func() {
+				for i := 0; i < 100; i++ {
+					for !t.Send(testMessage{key: 2 * i}) {
+						<-credits.Ready()
+					}
+				}
+			}()
+			for i := 0; i < 100; i++ {
+				Eventually(r.messages).Should(Receive(Equal(testMessage{key: 2 * i})))
+			}
+			_, dropped := logger.find("message dropped")
+			Expect(dropped).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,41 @@
+package task
+
+import (
+	"context"
+	"time"
+
+	"github.com/renproject/phi/co"
+)
+
+// Completed is the message that is sent to a task when a future that it is
+// awaiting (see `Await`) completes. It holds the future, so that handlers
+// awaiting more than one future can tell them apart, and its result.
+type Completed[T any] struct {
+	Future *co.Future[T]
+	Value  T
+	Err    error
+}
+
+// IsMessage implements the Message interface.
+func (Completed[T]) IsMessage() {}
+
+// awaitRetry is how long `Await` waits before sending the result of a future
+// again to a sender that did not accept it.
+const awaitRetry = 10 * time.Millisecond
+
+// Await a future without blocking. When the future completes, its result is
+// sent to the given sender as a `Completed` message. This allows a handler to
+// wait for the result of a future by receiving a message, instead of blocking
+// its task. If the sender does not accept the message, it is retried until it
+// is accepted, until the context is done, or until the sender is a task that
+// has terminated (see `Resend`). Retries are timed by the `Clock` of the sender
+// if it is a task returned by `New`, and by the `SystemClock` otherwise.
+func Await[T any](ctx context.Context, future *co.Future[T], to Sender) {
+	clock := SystemClock
+	if t, ok := to.(*task); ok {
+		clock = t.clock
+	}
+	future.OnComplete(func(value T, err error) {
+		Resend(clock, to, Completed[T]{Future: future, Value: value, Err: err}, awaitRetry, ctx.Done())
+	})
+}
@@ -0,0 +1,103 @@
+package task
+
+import (
+	"context"
+	"sync"
+
+	"github.com/renproject/phi/co"
+)
+
+// A Group is a collection of runners (usually tasks) that are run together,
+// and waited for together. It is the task-aware counterpart to `co.ParBegin`:
+// every runner is run on its own goroutine, the first runner to fail cancels
+// the context of all of the others, and waiting on the group returns once
+// every runner has exited.
+//
+// A runner fails if it panics, or if it is a task that terminates abnormally
+// (because it panicked, or because a task linked to it terminated abnormally;
+// see `WatchableTask`). The panics of tasks are contained while they are run by
+// the group, even when they are scaled. Runners that exit because
+// their context is done, or that return by themselves, do not fail, and do
+// not stop the rest of the group. Groups can be nested, in which case the
+// failure of the inner group is the failure of the first of its runners.
+type Group struct {
+	mu      *sync.Mutex
+	runners []Runner
+	started bool
+}
+
+// NewGroup returns a `Group` of runners. More runners can be added with
+// `Group.Add`, until the group is run.
+func NewGroup(runners ...Runner) *Group {
+	return &Group{mu: new(sync.Mutex), runners: runners}
+}
+
+// Add runners to the group. It panics if the group has already been run.
+func (g *Group) Add(runners ...Runner) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.started {
+		panic("group error: cannot add to a group that has been run")
+	}
+	g.runners = append(g.runners, runners...)
+}
+
+// Wait runs all of the runners in the group, and blocks until all of them have
+// exited. The context given to the runners is cancelled when the given context
+// is cancelled, or when a runner fails. It returns the first failure (panics
+// are returned as a `co.PanicError`, and tasks that terminate abnormally
+// return the reason for which they terminated; see `Terminated`), or nil if no
+// runner failed. A group can only be run once, and it panics if it is run
+// again.
+func (g *Group) Wait(ctx context.Context) error {
+	g.mu.Lock()
+	if g.started {
+		g.mu.Unlock()
+		panic("group error: group has already been run")
+	}
+	g.started = true
+	runners := g.runners
+	g.mu.Unlock()
+
+	return co.WithScope(ctx, func(s *co.Scope) error {
+		for _, r := range runners {
+			r := r
+			s.Go(func(ctx context.Context) error {
+				return runMember(ctx, r)
+			})
+		}
+		return nil
+	})
+}
+
+// Run implements the `Runner` interface, so that a group can be run wherever a
+// runner is expected. It is the same as `Group.Wait`, except that the first
+// failure is raised as a panic instead of being returned.
+func (g *Group) Run(ctx context.Context) {
+	if err := g.Wait(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// runMember runs one runner of a group, and returns the reason for which it
+// failed, if any. Tasks are marked as being run by the group, so that they
+// contain their own panics (which would otherwise escape from the goroutines of
+// a scaled task and crash the process). Other panics are recovered by the scope
+// of the group.
+func runMember(ctx context.Context, r Runner) error {
+	switch r := r.(type) {
+	case *Group:
+		return r.Wait(ctx)
+	case *task:
+		r.joinGroup()
+		defer r.leaveGroup()
+		r.Run(ctx)
+		if reason := r.Status().Reason; abnormal(reason) {
+			return reason
+		}
+		return nil
+	default:
+		r.Run(ctx)
+		return nil
+	}
+}
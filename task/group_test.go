@@ -0,0 +1,157 @@
+package task_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/task"
+
+	"github.com/renproject/phi/co"
+)
+
+// runner is a `Runner` that calls a function.
+type runner func(context.Context)
+
+func (f runner) Run(ctx context.Context) {
+	f(ctx)
+}
+
+// blocker returns a `Runner` that blocks until its context is done, and then
+// closes a channel.
+func blocker() (Runner, chan struct{}) {
+	exited := make(chan struct{})
+	return runner(func(ctx context.Context) {
+		defer close(exited)
+		<-ctx.Done()
+	}), exited
+}
+
+var _ = Describe("Groups", func() {
+
+	// wait runs a group in the background, and returns a channel to which the
+	// result is written.
+	wait := func(ctx context.Context, g *Group) chan error {
+		errs := make(chan error, 1)
+		go func() {
+			errs <- g.Wait(ctx)
+		}()
+		return errs
+	}
+
+	Context("when no runner fails", func() {
+
+		It("should run every task until the context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			r := newRecorder(3)
+			tasks := []Task{New(r, Options{Cap: 1}), New(r, Options{Cap: 1}), New(r, Options{Cap: 1})}
+			g := NewGroup(tasks[0], tasks[1])
+			g.Add(tasks[2])
+			errs := wait(ctx, g)
+
+			for i, t := range tasks {
+				Eventually(func() bool { return t.Send(testMessage{key: i}) }).Should(BeTrue())
+			}
+			Eventually(r.messages).Should(HaveLen(3))
+			Consistently(errs, 10*time.Millisecond).ShouldNot(Receive())
+
+			cancel()
+			Eventually(errs).Should(Receive(BeNil()))
+			for _, t := range tasks {
+				Expect(t.(MonitoredTask).Status().Terminated).To(BeTrue())
+			}
+		})
+
+		It("should not stop the group when a runner returns", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			b, exited := blocker()
+			errs := wait(ctx, NewGroup(runner(func(context.Context) {}), b))
+			Consistently(exited, 10*time.Millisecond).ShouldNot(BeClosed())
+			cancel()
+			Eventually(errs).Should(Receive(BeNil()))
+			Expect(exited).To(BeClosed())
+		})
+
+		It("should return straight away when empty", func() {
+			Expect(NewGroup().Wait(context.Background())).To(Succeed())
+		})
+	})
+
+	Context("when a runner fails", func() {
+
+		It("should cancel the others and return the panic", func() {
+			b, exited := blocker()
+			crashing := New(crasher{}, Options{Cap: 1})
+			errs := wait(context.Background(), NewGroup(b, crashing))
+			Eventually(func() bool { return crashing.Send(testMessage{}) }).Should(BeTrue())
+
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			Expect(exited).To(BeClosed())
+			Expect(err).To(BeAssignableToTypeOf(co.PanicError{}))
+			Expect(err.(co.PanicError).Value).To(Equal("crash"))
+		})
+
+		It("should contain the panic of a scaled task", func() {
+			b, exited := blocker()
+			crashing := New(crasher{}, Options{Cap: 2, Scale: 2})
+			errs := wait(context.Background(), NewGroup(b, crashing))
+			Eventually(func() bool { return crashing.Send(testMessage{}) }).Should(BeTrue())
+
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			Expect(exited).To(BeClosed())
+			Expect(err).To(BeAssignableToTypeOf(co.PanicError{}))
+			Expect(err.(co.PanicError).Value).To(Equal("crash"))
+			Expect(crashing.(MonitoredTask).Status().Reason).To(Equal(err))
+		})
+
+		It("should return the reason of tasks that terminate abnormally", func() {
+			// Linking contains the panic, and kills the other task.
+			crashing := New(crasher{}, Options{Cap: 1})
+			linked := newWatchable(newRecorder(1), Options{Cap: 1})
+			Expect(linked.Link(crashing)).To(Succeed())
+			errs := wait(context.Background(), NewGroup(linked, crashing))
+			Eventually(func() bool { return crashing.Send(testMessage{}) }).Should(BeTrue())
+
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			var panicErr co.PanicError
+			Expect(errors.As(err, &panicErr)).To(BeTrue())
+			Expect(panicErr.Value).To(Equal("crash"))
+			Expect(linked.(MonitoredTask).Status().Reason).To(BeAssignableToTypeOf(LinkError{}))
+		})
+
+		It("should propagate failures out of nested groups", func() {
+			b, exited := blocker()
+			inner := NewGroup(runner(func(context.Context) { panic("inner") }))
+			err := NewGroup(b, inner).Wait(context.Background())
+			Expect(exited).To(BeClosed())
+			Expect(err).To(BeAssignableToTypeOf(co.PanicError{}))
+			Expect(err.(co.PanicError).Value).To(Equal("inner"))
+		})
+
+		It("should panic with the failure when run as a runner", func() {
+			g := NewGroup(runner(func(context.Context) { panic("boom") }))
+			var r interface{}
+			func() {
+				defer func() { r = recover() }()
+				g.Run(context.Background())
+			}()
+			Expect(r).To(BeAssignableToTypeOf(co.PanicError{}))
+		})
+	})
+
+	Context("when a group is misused", func() {
+
+		It("should panic when it is run twice, or added to after it is run", func() {
+			g := NewGroup()
+			Expect(g.Wait(context.Background())).To(Succeed())
+			Expect(func() { g.Wait(context.Background()) }).To(Panic())
+			Expect(func() { g.Add(runner(func(context.Context) {})) }).To(Panic())
+		})
+	})
+})
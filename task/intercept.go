@@ -0,0 +1,104 @@
+package task
+
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/renproject/phi/co"
+)
+
+// HandlerFunc is an adapter that allows an ordinary function to be used as a
+// `Handler`.
+type HandlerFunc func(Task, Message)
+
+// Handle implements the `Handler` interface by calling the function.
+func (f HandlerFunc) Handle(t Task, m Message) {
+	f(t, m)
+}
+
+// SenderFunc is an adapter that allows an ordinary function to be used as a
+// `Sender`.
+type SenderFunc func(Message) bool
+
+// Send implements the `Sender` interface by calling the function.
+func (f SenderFunc) Send(m Message) bool {
+	return f(m)
+}
+
+// Interceptor wraps a `Handler` with another `Handler`, so that cross-cutting
+// logic (such as logging, tracing, validation, or metrics) can run before and
+// after messages are handled, without changing the handler itself. An
+// interceptor can stop a message from being handled by not calling the next
+// handler.
+type Interceptor func(next Handler) Handler
+
+// SendInterceptor wraps a `Sender` with another `Sender`, in the same way that
+// an `Interceptor` wraps a `Handler`. An interceptor can stop a message from
+// being sent by returning without calling the next sender.
+type SendInterceptor func(next Sender) Sender
+
+// Chain wraps a handler with interceptors. The interceptors are applied in
+// order, so the first interceptor is the outermost, and is the first to see
+// each message.
+func Chain(handler Handler, interceptors ...Interceptor) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		handler = interceptors[i](handler)
+	}
+	return handler
+}
+
+// ChainSend wraps a sender with interceptors. The interceptors are applied in
+// order, so the first interceptor is the outermost, and is the first to see
+// each message.
+func ChainSend(sender Sender, interceptors ...SendInterceptor) Sender {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		sender = interceptors[i](sender)
+	}
+	return sender
+}
+
+// LoggingInterceptor returns an `Interceptor` that logs the type of every
+// message that is handled, and how long it took to handle. The `logf` function
+// has the same signature as `log.Printf`.
+func LoggingInterceptor(logf func(format string, args ...interface{})) Interceptor {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(t Task, m Message) {
+			start := time.Now()
+			defer func() {
+				logf("handled %T in %v", m, time.Since(start))
+			}()
+			next.Handle(t, m)
+		})
+	}
+}
+
+// LoggingSendInterceptor returns a `SendInterceptor` that logs the type of
+// every message that is sent, and whether or not it was accepted. The `logf`
+// function has the same signature as `log.Printf`.
+func LoggingSendInterceptor(logf func(format string, args ...interface{})) SendInterceptor {
+	return func(next Sender) Sender {
+		return SenderFunc(func(m Message) bool {
+			ok := next.Send(m)
+			logf("sent %T (accepted: %v)", m, ok)
+			return ok
+		})
+	}
+}
+
+// RecoverInterceptor returns an `Interceptor` that recovers from panics while a
+// message is being handled, so that a single bad message does not crash the
+// task. The panic is passed to `onPanic` as a `co.PanicError`, along with the
+// message that caused it; if `onPanic` is nil, the panic is silently dropped.
+// The `HandleError` method of an `ErrorHandler` can be used as `onPanic`.
+func RecoverInterceptor(onPanic func(Task, Message, error)) Interceptor {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(t Task, m Message) {
+			defer func() {
+				if r := recover(); r != nil && onPanic != nil {
+					onPanic(t, m, co.PanicError{Value: r, Stack: debug.Stack()})
+				}
+			}()
+			next.Handle(t, m)
+		})
+	}
+}
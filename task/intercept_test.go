@@ -0,0 +1,218 @@
+package task_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/task"
+
+	"github.com/renproject/phi/co"
+)
+
+// trace records the order in which interceptors see messages.
+type trace struct {
+	mu    *sync.Mutex
+	lines []string
+}
+
+func newTrace() *trace {
+	return &trace{mu: new(sync.Mutex)}
+}
+
+func (t *trace) add(format string, args ...interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lines = append(t.lines, fmt.Sprintf(format, args...))
+}
+
+func (t *trace) get() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string{}, t.lines...)
+}
+
+func (t *trace) interceptor(name string) Interceptor {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(self Task, m Message) {
+			t.add("%v before %v", name, m.(testMessage).key)
+			next.Handle(self, m)
+			t.add("%v after %v", name, m.(testMessage).key)
+		})
+	}
+}
+
+func (t *trace) sendInterceptor(name string) SendInterceptor {
+	return func(next Sender) Sender {
+		return SenderFunc(func(m Message) bool {
+			t.add("%v send %v", name, m.(testMessage).key)
+			return next.Send(m)
+		})
+	}
+}
+
+// evenOnly is a `SendInterceptor` that rejects messages with odd keys.
+func evenOnly(next Sender) Sender {
+	return SenderFunc(func(m Message) bool {
+		if m.(testMessage).key%2 != 0 {
+			return false
+		}
+		return next.Send(m)
+	})
+}
+
+// panicker is a `Handler` that panics on messages with odd keys.
+type panicker struct {
+	*recorder
+}
+
+func (p panicker) Handle(self Task, m Message) {
+	if m.(testMessage).key%2 != 0 {
+		panic(fmt.Sprintf("odd key %v", m.(testMessage).key))
+	}
+	p.recorder.Handle(self, m)
+}
+
+var _ = Describe("Interceptors", func() {
+
+	Context("when chaining interceptors", func() {
+
+		It("should apply them in order", func() {
+			tr := newTrace()
+			handler := Chain(HandlerFunc(func(Task, Message) {
+				tr.add("handle")
+			}), tr.interceptor("a"), tr.interceptor("b"))
+			handler.Handle(nil, testMessage{key: 1})
+			Expect(tr.get()).To(Equal([]string{
+				"a before 1", "b before 1", "handle", "b after 1", "a after 1",
+			}))
+		})
+
+		It("should apply send interceptors in order", func() {
+			tr := newTrace()
+			c := new(counter)
+			sender := ChainSend(c, tr.sendInterceptor("a"), tr.sendInterceptor("b"))
+			Expect(sender.Send(testMessage{key: 1})).To(BeTrue())
+			Expect(tr.get()).To(Equal([]string{"a send 1", "b send 1"}))
+			Expect(c.count()).To(Equal(int64(1)))
+		})
+
+		It("should return the handler when there are no interceptors", func() {
+			r := newRecorder(1)
+			Expect(Chain(r)).To(Equal(r))
+		})
+	})
+
+	Context("when a task has interceptors", func() {
+
+		It("should intercept every handled message", func() {
+			tr := newTrace()
+			r := newRecorder(10)
+			t := New(r, Options{Cap: 10, Interceptors: []Interceptor{tr.interceptor("a")}})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			Expect(t.Send(Messages{testMessage{key: 1}, testMessage{key: 2}})).To(BeTrue())
+			Eventually(r.messages).Should(Receive(Equal(testMessage{key: 1})))
+			Eventually(r.messages).Should(Receive(Equal(testMessage{key: 2})))
+			Eventually(tr.get).Should(Equal([]string{
+				"a before 1", "a after 1", "a before 2", "a after 2",
+			}))
+		})
+
+		It("should intercept every sent message", func() {
+			r := newRecorder(10)
+			t := New(r, Options{Cap: 10, SendInterceptors: []SendInterceptor{evenOnly}})
+			for i := 0; i < 4; i++ {
+				Expect(t.Send(testMessage{key: i})).To(Equal(i%2 == 0))
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			Eventually(r.messages).Should(Receive(Equal(testMessage{key: 0})))
+			Eventually(r.messages).Should(Receive(Equal(testMessage{key: 2})))
+			Consistently(r.messages).ShouldNot(Receive())
+		})
+
+		It("should still notify an error handler", func() {
+			r := errorRecorder{recorder: newRecorder(1), errs: make(chan error, 1)}
+			t := New(r, Options{Cap: 1, MaxDepth: 1, Interceptors: []Interceptor{newTrace().interceptor("a")}})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			Expect(t.Send(Messages{Messages{testMessage{}}})).To(BeTrue())
+			Eventually(r.errs).Should(Receive(Equal(ErrMaxDepthExceeded)))
+		})
+	})
+
+	Context("when a router has interceptors", func() {
+
+		It("should intercept every message before it is routed", func() {
+			a, b := new(counter), new(counter)
+			router := NewRouter(&modRouter{senders: []Sender{a, b}}, evenOnly)
+			co.ParForAll(10, func(i int) {
+				Expect(router.Send(testMessage{key: i})).To(Equal(i%2 == 0))
+			})
+			Expect(a.count()).To(Equal(int64(5)))
+			Expect(b.count()).To(Equal(int64(0)))
+		})
+	})
+
+	Context("when using the built-in interceptors", func() {
+
+		It("should log handled and sent messages", func() {
+			tr := newTrace()
+			r := newRecorder(1)
+			t := New(r, Options{
+				Cap:              1,
+				Interceptors:     []Interceptor{LoggingInterceptor(tr.add)},
+				SendInterceptors: []SendInterceptor{LoggingSendInterceptor(tr.add)},
+			})
+			Expect(t.Send(testMessage{})).To(BeTrue())
+			Expect(t.Send(testMessage{})).To(BeFalse())
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			Eventually(r.messages).Should(Receive())
+			Eventually(func() int { return len(tr.get()) }).Should(Equal(3))
+			lines := tr.get()
+			Expect(lines[0]).To(Equal("sent task_test.testMessage (accepted: true)"))
+			Expect(lines[1]).To(Equal("sent task_test.testMessage (accepted: false)"))
+			Expect(lines[2]).To(HavePrefix("handled task_test.testMessage in "))
+		})
+
+		It("should recover from panics and keep handling messages", func() {
+			errs := make(chan error, 10)
+			r := newRecorder(10)
+			t := New(panicker{r}, Options{
+				Cap:   10,
+				Scale: 2,
+				Interceptors: []Interceptor{RecoverInterceptor(func(_ Task, _ Message, err error) {
+					errs <- err
+				})},
+			})
+			for i := 0; i < 4; i++ {
+				Expect(t.Send(testMessage{key: i})).To(BeTrue())
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			for i := 0; i < 2; i++ {
+				var err error
+				Eventually(errs).Should(Receive(&err))
+				panicErr, ok := err.(co.PanicError)
+				Expect(ok).To(BeTrue())
+				Expect(panicErr.Value).To(HavePrefix("odd key"))
+			}
+			Eventually(r.messages).Should(Receive())
+			Eventually(r.messages).Should(Receive())
+		})
+	})
+})
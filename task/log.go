@@ -0,0 +1,123 @@
+package task
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Logger is a structured logger. Each method takes a message and alternating
+// keys and values, in the same way as the methods of a `*slog.Logger` (from
+// the `log/slog` package), so a `*slog.Logger` can be used wherever a `Logger`
+// is expected.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// Level is the severity of a log message. The levels have the same values as
+// the levels of the `log/slog` package.
+type Level int
+
+// The levels of a `Logger`, in increasing order of severity.
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+// String implements the `fmt.Stringer` interface.
+func (level Level) String() string {
+	switch level {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("Level(%d)", int(level))
+	}
+}
+
+// loggerBox allows a nil `Logger` to be stored in an `atomic.Value`.
+type loggerBox struct {
+	logger Logger
+}
+
+var defaultLogger atomic.Value
+
+func init() {
+	defaultLogger.Store(loggerBox{})
+}
+
+// SetDefaultLogger sets the logger used by tasks that do not have a logger in
+// their `Options`. Setting it to nil, which is the default, disables logging
+// for these tasks. It is safe to call at any time; running tasks will use the
+// new logger from then on.
+func SetDefaultLogger(logger Logger) {
+	defaultLogger.Store(loggerBox{logger: logger})
+}
+
+// DefaultLogger returns the logger used by tasks that do not have a logger in
+// their `Options`. It can be nil.
+func DefaultLogger() Logger {
+	return defaultLogger.Load().(loggerBox).logger
+}
+
+// stdLogger is a `Logger` that writes to a `*log.Logger`.
+type stdLogger struct {
+	logger *log.Logger
+	min    Level
+}
+
+// NewStdLogger returns a `Logger` that writes to a `*log.Logger` from the `log`
+// package, for programs that do not use `log/slog`. Messages below the minimum
+// level are discarded. Each message is written on one line, as the level, the
+// message, and then each key and value as `key=value`.
+func NewStdLogger(logger *log.Logger, min Level) Logger {
+	return stdLogger{logger: logger, min: min}
+}
+
+// Debug implements the `Logger` interface.
+func (l stdLogger) Debug(msg string, args ...interface{}) {
+	l.log(LevelDebug, msg, args)
+}
+
+// Info implements the `Logger` interface.
+func (l stdLogger) Info(msg string, args ...interface{}) {
+	l.log(LevelInfo, msg, args)
+}
+
+// Warn implements the `Logger` interface.
+func (l stdLogger) Warn(msg string, args ...interface{}) {
+	l.log(LevelWarn, msg, args)
+}
+
+// Error implements the `Logger` interface.
+func (l stdLogger) Error(msg string, args ...interface{}) {
+	l.log(LevelError, msg, args)
+}
+
+func (l stdLogger) log(level Level, msg string, args []interface{}) {
+	if level < l.min {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v %v", level, msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			// A key without a value is logged in the same way as `log/slog`.
+			fmt.Fprintf(&b, " !BADKEY=%v", args[i])
+			break
+		}
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+	l.logger.Print(b.String())
+}
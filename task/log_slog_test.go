@@ -0,0 +1,23 @@
+//go:build go1.21
+
+package task_test
+
+import (
+	"bytes"
+	"log/slog"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/task"
+)
+
+var _ = Describe("Logging with slog", func() {
+
+	It("should accept a *slog.Logger", func() {
+		buf := new(bytes.Buffer)
+		var l Logger = slog.New(slog.NewTextHandler(buf, nil))
+		t := New(newRecorder(0), Options{Name: "test", Logger: l})
+		Expect(t.Send(testMessage{})).To(BeFalse())
+		Expect(buf.String()).To(ContainSubstring("level=WARN msg=\"message dropped\" task=test message=task_test.testMessage queued=0 capacity=0"))
+	})
+})
@@ -0,0 +1,180 @@
+package task_test
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/task"
+)
+
+// entry is a message logged by a `memLogger`.
+type entry struct {
+	level Level
+	msg   string
+	attrs map[string]interface{}
+}
+
+// memLogger is a `Logger` that keeps every message in memory.
+type memLogger struct {
+	mu      *sync.Mutex
+	entries []entry
+}
+
+func newMemLogger() *memLogger {
+	return &memLogger{mu: new(sync.Mutex)}
+}
+
+func (l *memLogger) Debug(msg string, args ...interface{}) { l.log(LevelDebug, msg, args) }
+func (l *memLogger) Info(msg string, args ...interface{})  { l.log(LevelInfo, msg, args) }
+func (l *memLogger) Warn(msg string, args ...interface{})  { l.log(LevelWarn, msg, args) }
+func (l *memLogger) Error(msg string, args ...interface{}) { l.log(LevelError, msg, args) }
+
+func (l *memLogger) log(level Level, msg string, args []interface{}) {
+	attrs := map[string]interface{}{}
+	for i := 0; i+1 < len(args); i += 2 {
+		attrs[args[i].(string)] = args[i+1]
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry{level: level, msg: msg, attrs: attrs})
+}
+
+// find returns the first entry with the given message.
+func (l *memLogger) find(msg string) (entry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.entries {
+		if e.msg == msg {
+			return e, true
+		}
+	}
+	return entry{}, false
+}
+
+// logged returns a function, for use with `Eventually`, that reports whether
+// or not the given message has been logged.
+func (l *memLogger) logged(msg string) func() bool {
+	return func() bool {
+		_, ok := l.find(msg)
+		return ok
+	}
+}
+
+// crasher is a `Handler` that always panics.
+type crasher struct{}
+
+func (crasher) Handle(Task, Message) {
+	panic("crash")
+}
+
+var _ = Describe("Logging", func() {
+
+	Context("when a task has a logger", func() {
+
+		It("should log dropped messages", func() {
+			l := newMemLogger()
+			t := New(newRecorder(1), Options{Name: "test", Cap: 1, Logger: l})
+			Expect(t.Send(testMessage{})).To(BeTrue())
+			Expect(t.Send(testMessage{})).To(BeFalse())
+
+			e, ok := l.find("message dropped")
+			Expect(ok).To(BeTrue())
+			Expect(e.level).To(Equal(LevelWarn))
+			Expect(e.attrs).To(Equal(map[string]interface{}{
+				"task":     "test",
+				"message":  "task_test.testMessage",
+				"queued":   1,
+				"capacity": 1,
+			}))
+		})
+
+		It("should log messages that cannot be handled", func() {
+			l := newMemLogger()
+			t := New(newRecorder(1), Options{Name: "test", Cap: 1, MaxDepth: 1, Logger: l})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			Expect(t.Send(Messages{Messages{testMessage{}}})).To(BeTrue())
+			Eventually(l.logged("message not handled")).Should(BeTrue())
+			e, _ := l.find("message not handled")
+			Expect(e.level).To(Equal(LevelWarn))
+			Expect(e.attrs["error"]).To(Equal(ErrMaxDepthExceeded))
+		})
+
+		It("should log panics and continue panicking", func() {
+			l := newMemLogger()
+			t := New(crasher{}, Options{Name: "test", Cap: 1, Logger: l})
+			panics := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					panics <- recover()
+				}()
+				t.Run(context.Background())
+			}()
+
+			Expect(t.Send(testMessage{})).To(BeTrue())
+			Eventually(panics).Should(Receive(Equal("crash")))
+			e, ok := l.find("handler panicked")
+			Expect(ok).To(BeTrue())
+			Expect(e.level).To(Equal(LevelError))
+			Expect(e.attrs["panic"]).To(Equal("crash"))
+			Expect(e.attrs["message"]).To(Equal("task_test.testMessage"))
+			Expect(e.attrs["stack"]).To(ContainSubstring("log_test.go"))
+		})
+
+		It("should log when the task starts and stops", func() {
+			l := newMemLogger()
+			t := New(newRecorder(1), Options{Name: "test", Cap: 1, Scale: 2, Logger: l})
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				t.Run(ctx)
+			}()
+
+			Eventually(l.logged("task started")).Should(BeTrue())
+			Expect(l.logged("task stopped")()).To(BeFalse())
+			cancel()
+			Eventually(done).Should(BeClosed())
+			e, ok := l.find("task stopped")
+			Expect(ok).To(BeTrue())
+			Expect(e.level).To(Equal(LevelInfo))
+			Expect(e.attrs["reason"]).To(Equal(context.Canceled))
+		})
+	})
+
+	Context("when a task does not have a logger", func() {
+
+		AfterEach(func() {
+			SetDefaultLogger(nil)
+		})
+
+		It("should use the default logger", func() {
+			t := New(newRecorder(1), Options{Cap: 0})
+			Expect(t.Send(testMessage{})).To(BeFalse())
+
+			l := newMemLogger()
+			SetDefaultLogger(l)
+			Expect(DefaultLogger()).To(Equal(l))
+			Expect(t.Send(testMessage{})).To(BeFalse())
+			Expect(l.logged("message dropped")()).To(BeTrue())
+		})
+	})
+
+	Context("when using the standard library logger", func() {
+
+		It("should write messages at or above the minimum level", func() {
+			buf := new(bytes.Buffer)
+			l := NewStdLogger(log.New(buf, "", 0), LevelInfo)
+			l.Debug("hidden")
+			l.Info("shown", "a", 1, "b", "two")
+			l.Error("odd", "a")
+			Expect(buf.String()).To(Equal("INFO shown a=1 b=two\nERROR odd !BADKEY=a\n"))
+		})
+	})
+})
@@ -0,0 +1,152 @@
+package task
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a source of time. It exists so that time can be controlled in
+// tests; outside of tests, the `SystemClock` should be used.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel that the current time is written to once the
+	// given duration has elapsed.
+	After(time.Duration) <-chan time.Time
+}
+
+// SystemClock is a `Clock` that uses the system time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Rate is a token bucket rate limit. Tokens are added to the bucket at a rate
+// of `PerSecond` tokens per second, up to a maximum of `Burst` tokens (at least
+// one), and every message takes one token from the bucket. A `Burst` of one
+// results in messages being evenly spaced (like a leaky bucket), and larger
+// bursts allow short spikes above the rate.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// bucket is a token bucket. It is not safe for concurrent use.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newBucket returns a full bucket.
+func newBucket(rate Rate, now time.Time) *bucket {
+	return &bucket{tokens: float64(burst(rate)), last: now}
+}
+
+// take a token from the bucket. If there are no tokens, it returns false and
+// the time until the next token will be available.
+func (b *bucket) take(rate Rate, now time.Time) (bool, time.Duration) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * rate.PerSecond
+		if max := float64(burst(rate)); b.tokens > max {
+			b.tokens = max
+		}
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if rate.PerSecond <= 0 {
+		return false, time.Duration(1<<63 - 1)
+	}
+	return false, time.Duration((1 - b.tokens) / rate.PerSecond * float64(time.Second))
+}
+
+// refund a token that was taken from the bucket.
+func (b *bucket) refund(rate Rate) {
+	if b.tokens++; b.tokens > float64(burst(rate)) {
+		b.tokens = float64(burst(rate))
+	}
+}
+
+func burst(rate Rate) int {
+	if rate.Burst < 1 {
+		return 1
+	}
+	return rate.Burst
+}
+
+// RateLimitOptions are passed when constructing a `RateLimitedSender`. If
+// `Key` is not nil, each key has its own token bucket, so that messages with
+// one key do not use up the tokens of messages with another key. Buckets for
+// keys are never removed, so the number of distinct keys should be bounded. If
+// `Clock` is nil, the `SystemClock` is used.
+type RateLimitOptions struct {
+	Rate  Rate
+	Key   func(Message) interface{}
+	Clock Clock
+}
+
+// A RateLimitedSender is a `Sender` that limits the rate at which messages can
+// be sent to another `Sender`. Messages that exceed the rate are rejected (in
+// the same way as when a task has a full buffer). It is safe for concurrent
+// use.
+type RateLimitedSender struct {
+	sender Sender
+	opts   RateLimitOptions
+
+	mu      *sync.Mutex
+	buckets map[interface{}]*bucket
+}
+
+// NewRateLimitedSender returns a `RateLimitedSender` that sends messages to the
+// given sender.
+func NewRateLimitedSender(sender Sender, opts RateLimitOptions) *RateLimitedSender {
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
+	}
+	return &RateLimitedSender{
+		sender:  sender,
+		opts:    opts,
+		mu:      new(sync.Mutex),
+		buckets: map[interface{}]*bucket{},
+	}
+}
+
+// Send implements the `Sender` interface. It returns false, without sending
+// the message, if the message exceeds the rate limit. Messages that are
+// rejected by the underlying sender do not count towards the rate limit.
+func (s *RateLimitedSender) Send(m Message) bool {
+	var key interface{}
+	if s.opts.Key != nil {
+		key = s.opts.Key(m)
+	}
+
+	s.mu.Lock()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = newBucket(s.opts.Rate, s.opts.Clock.Now())
+		s.buckets[key] = b
+	}
+	ok, _ = b.take(s.opts.Rate, s.opts.Clock.Now())
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	if !s.sender.Send(m) {
+		s.mu.Lock()
+		b.refund(s.opts.Rate)
+		s.mu.Unlock()
+		return false
+	}
+	return true
+}
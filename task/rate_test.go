@@ -0,0 +1,193 @@
+package task_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/task"
+)
+
+// fakeClock is a `Clock` that only advances when it is told to.
+type fakeClock struct {
+	mu      *sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{mu: new(sync.Mutex), now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+func (c *fakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// toggle is a `Sender` that only accepts messages when it is on.
+type toggle struct {
+	on bool
+}
+
+func (t *toggle) Send(Message) bool {
+	return t.on
+}
+
+var _ = Describe("Rate limiting", func() {
+
+	Context("when sending to a rate limited sender", func() {
+
+		It("should reject messages that exceed the rate", func() {
+			clock := newFakeClock()
+			sender := NewRateLimitedSender(new(counter), RateLimitOptions{
+				Rate:  Rate{PerSecond: 2, Burst: 2},
+				Clock: clock,
+			})
+			Expect(sender.Send(testMessage{})).To(BeTrue())
+			Expect(sender.Send(testMessage{})).To(BeTrue())
+			Expect(sender.Send(testMessage{})).To(BeFalse())
+
+			clock.Advance(250 * time.Millisecond)
+			Expect(sender.Send(testMessage{})).To(BeFalse())
+			clock.Advance(250 * time.Millisecond)
+			Expect(sender.Send(testMessage{})).To(BeTrue())
+			Expect(sender.Send(testMessage{})).To(BeFalse())
+
+			clock.Advance(time.Hour)
+			Expect(sender.Send(testMessage{})).To(BeTrue())
+			Expect(sender.Send(testMessage{})).To(BeTrue())
+			Expect(sender.Send(testMessage{})).To(BeFalse())
+		})
+
+		It("should limit each key separately", func() {
+			clock := newFakeClock()
+			sender := NewRateLimitedSender(new(counter), RateLimitOptions{
+				Rate:  Rate{PerSecond: 1},
+				Key:   func(m Message) interface{} { return m.(testMessage).key },
+				Clock: clock,
+			})
+			Expect(sender.Send(testMessage{key: 0})).To(BeTrue())
+			Expect(sender.Send(testMessage{key: 0})).To(BeFalse())
+			Expect(sender.Send(testMessage{key: 1})).To(BeTrue())
+			Expect(sender.Send(testMessage{key: 1})).To(BeFalse())
+		})
+
+		It("should not count messages that are rejected by the underlying sender", func() {
+			clock := newFakeClock()
+			t := &toggle{on: false}
+			sender := NewRateLimitedSender(t, RateLimitOptions{
+				Rate:  Rate{PerSecond: 1},
+				Clock: clock,
+			})
+			Expect(sender.Send(testMessage{})).To(BeFalse())
+			t.on = true
+			Expect(sender.Send(testMessage{})).To(BeTrue())
+			Expect(sender.Send(testMessage{})).To(BeFalse())
+		})
+	})
+
+	Context("when running a task with a max rate", func() {
+
+		It("should wait for the rate to allow more messages", func() {
+			clock := newFakeClock()
+			r := newRecorder(10)
+			t := New(r, Options{Cap: 10, MaxRate: Rate{PerSecond: 1, Burst: 2}, Clock: clock})
+			for i := 0; i < 4; i++ {
+				Expect(t.Send(testMessage{key: i})).To(BeTrue())
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			Eventually(r.messages).Should(Receive(Equal(testMessage{key: 0})))
+			Eventually(r.messages).Should(Receive(Equal(testMessage{key: 1})))
+			Eventually(clock.Waiters).Should(Equal(1))
+			Consistently(r.messages).ShouldNot(Receive())
+
+			clock.Advance(time.Second)
+			Eventually(r.messages).Should(Receive(Equal(testMessage{key: 2})))
+			Eventually(clock.Waiters).Should(Equal(1))
+			Consistently(r.messages).ShouldNot(Receive())
+
+			clock.Advance(time.Second)
+			Eventually(r.messages).Should(Receive(Equal(testMessage{key: 3})))
+		})
+
+		It("should report a message that is waiting for the rate when the task stops", func() {
+			clock := newFakeClock()
+			r := errorRecorder{newRecorder(10), make(chan error, 1)}
+			t := New(r, Options{Cap: 10, MaxRate: Rate{PerSecond: 1, Burst: 1}, Clock: clock})
+			Expect(t.Send(testMessage{key: 0})).To(BeTrue())
+			Expect(t.Send(testMessage{key: 1})).To(BeTrue())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			Eventually(r.messages).Should(Receive(Equal(testMessage{key: 0})))
+			Eventually(clock.Waiters).Should(Equal(1))
+			cancel()
+			Eventually(r.errs).Should(Receive(Equal(context.Canceled)))
+			Expect(r.messages).ToNot(Receive())
+		})
+
+		It("should not drain more messages into a batch than the rate allows", func() {
+			clock := newFakeClock()
+			r := batchRecorder{newRecorder(10)}
+			t := New(r, Options{Cap: 10, BatchSize: 10, MaxRate: Rate{PerSecond: 1, Burst: 3}, Clock: clock})
+			for i := 0; i < 5; i++ {
+				Expect(t.Send(testMessage{key: i})).To(BeTrue())
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			var batch Messages
+			Eventually(r.batches).Should(Receive(&batch))
+			Expect(batch).To(HaveLen(3))
+			Eventually(clock.Waiters).Should(Equal(1))
+			clock.Advance(2 * time.Second)
+			Eventually(r.batches).Should(Receive(&batch))
+			Expect(batch).To(HaveLen(2))
+		})
+	})
+})
@@ -0,0 +1,83 @@
+package task
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Status is a snapshot of the runtime state of a task, for use in monitoring
+// and health checks.
+type Status struct {
+	// Name is the name of the task from its `Options`.
+	Name string
+
+	// Running is true while the task is running, and Terminated is true once
+	// it has stopped running, for the given Reason (see `Terminated`). A task
+	// that has not been run is neither running nor terminated.
+	Running    bool
+	Terminated bool
+	Reason     error
+
+	// Started is the time at which the task started running, and LastHandled
+	// is the time at which it last finished handling a message (or a batch).
+	// LastHandled is zero if no message has been handled.
+	Started     time.Time
+	LastHandled time.Time
+
+	// Handled is the number of messages that have been handled.
+	Handled uint64
+
+	// Queued is the number of messages in the buffer of the task, and Capacity
+	// is the size of the buffer.
+	Queued, Capacity int
+
+	// NotReady is the error returned by the `Ready` method of the current
+	// behaviour of the task, if it is a `ReadyHandler`. It is nil when the
+	// task is ready, or when its behaviour is not a `ReadyHandler`.
+	NotReady error
+}
+
+// ReadyHandler is a `Handler` that can report whether or not it is ready to
+// handle messages (for example, because it is still initialising). It is used
+// by the `Status` of its task. The `Ready` method returns nil when the handler
+// is ready, and is called concurrently with `Handle`, so it must be safe for
+// concurrent use.
+type ReadyHandler interface {
+	Handler
+	Ready() error
+}
+
+// Status implements the `MonitoredTask` interface.
+func (task *task) Status() Status {
+	status := Status{
+		Name:     task.name,
+		Handled:  atomic.LoadUint64(&task.handled),
+		Queued:   len(task.input),
+		Capacity: cap(task.input),
+	}
+	if ns := atomic.LoadInt64(&task.lastHandled); ns != 0 {
+		status.LastHandled = time.Unix(0, ns)
+	}
+
+	task.watchMu.Lock()
+	status.Running = len(task.runs) > 0 && !task.terminated
+	status.Terminated = task.terminated
+	status.Reason = task.reason
+	status.Started = task.started
+	task.watchMu.Unlock()
+
+	if handler, ok := task.current().(ReadyHandler); ok {
+		status.NotReady = handler.Ready()
+	}
+	return status
+}
+
+// count messages that have been handled.
+func (task *task) count(n int) {
+	atomic.AddUint64(&task.handled, uint64(n))
+}
+
+// progress records the time at which a message (or a batch) was handled.
+func (task *task) progress() {
+	atomic.StoreInt64(&task.lastHandled, task.clock.Now().UnixNano())
+}
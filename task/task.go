@@ -2,7 +2,12 @@ package task
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/renproject/phi/co"
 )
@@ -17,13 +22,27 @@ type Message interface {
 // Messages is a collection of messages. Note that handlers will never receive
 // a `Messages` type because they will always be flattened before being
 // processed. If a task needs to respond with more than one message, and it is
-// important that these messages are processed together, then a custom
-// container type should be created and handled appropriately in the handler.
+// important that these messages are processed together, then an `Atomic`
+// should be used instead.
+// Messages can be nested at most `MaxDepth` levels deep (see `Options`);
+// deeper, or cyclic, messages are not handled.
 type Messages []Message
 
 // IsMessage implements the Message interface.
 func (Messages) IsMessage() {}
 
+// Atomic is a collection of messages that must be processed together. Like
+// `Messages`, handlers will never receive an `Atomic` type because it will be
+// flattened before being processed. However, it is guaranteed that the
+// messages in an `Atomic` are handled consecutively, by the same worker, and
+// that no other worker will handle messages until they are all handled (even
+// when `Scale` >= 2). An `Atomic` occupies a single slot in the buffer of a
+// task, so it is either accepted in its entirety or not at all.
+type Atomic []Message
+
+// IsMessage implements the Message interface.
+func (Atomic) IsMessage() {}
+
 // Runner represents something that can be run.
 type Runner interface {
 	// Run by convention will be blocking. The context should be used to signal
@@ -42,11 +61,83 @@ type Sender interface {
 // represents an entity that (when running) can be sent messages and upon
 // receipt of these messages performs internal logic (which often involves
 // sending messages to other tasks).
+//
+// Tasks returned by `New` can do more than this: they implement the
+// `BehaviourTask`, `WatchableTask`, and `MonitoredTask` interfaces. These are
+// optional, so that other implementations of `Task` do not have to implement
+// them; code that needs them should check for them with a type assertion.
 type Task interface {
 	Runner
 	Sender
 }
 
+// A BehaviourTask is a `Task` that can switch the behaviour that handles its
+// messages, and stash messages that its current behaviour is not ready to
+// handle. These methods are intended to be called by the handler of the task,
+// while it is handling a message.
+type BehaviourTask interface {
+	Task
+
+	// Become replaces the current behaviour of the task with a handler, which
+	// will handle all messages from the next message onwards.
+	Become(Handler)
+
+	// BecomeStacked pushes a handler onto the behaviour stack of the task, so
+	// that it handles all messages from the next message onwards, until
+	// `Unbecome` is called.
+	BecomeStacked(Handler)
+
+	// Unbecome pops the current behaviour from the behaviour stack of the task,
+	// returning to the previous behaviour. The original handler of the task is
+	// never popped.
+	Unbecome()
+
+	// Stash a message, so that it can be handled later. It returns false if
+	// the stash is full.
+	Stash(Message) bool
+
+	// UnstashAll returns all stashed messages to the task, in the order in
+	// which they were stashed. They are handled before any other messages in
+	// the buffer of the task.
+	UnstashAll()
+}
+
+// A WatchableTask is a `Task` that can watch, and be linked to, other tasks.
+// Only tasks returned by `New` can be watched or linked; other tasks are
+// rejected with `ErrNotWatchable`.
+type WatchableTask interface {
+	Task
+
+	// Watch a target task, so that a `Terminated` message is delivered to this
+	// task when the target terminates (when its `Run` returns). If the target
+	// has already terminated, the message is delivered immediately. While a
+	// task is watched, panics in its handler are contained: instead of
+	// crashing the program, the task terminates with a `co.PanicError`.
+	Watch(target Task) error
+
+	// Unwatch a target task. A `Terminated` message that is already being
+	// delivered may still arrive.
+	Unwatch(target Task)
+
+	// Link this task to another task, so that if either terminates
+	// abnormally (because it panicked, or because a task linked to it
+	// terminated abnormally) the other is terminated too, with a `LinkError`.
+	// Tasks that terminate because their context is done do not terminate
+	// their linked tasks. Like watching, linking contains panics.
+	Link(other Task) error
+
+	// Unlink this task from another task.
+	Unlink(other Task)
+}
+
+// A MonitoredTask is a `Task` that can report its runtime state.
+type MonitoredTask interface {
+	Task
+
+	// Status returns a snapshot of the runtime state of the task.
+	Status() Status
+}
+
 // Handler defines a type that can receive a message and mutate its internal
 // state. The `Task` argument is the parent task of the Handler, and can be used
 // by a Handler to send messages to itself.
@@ -61,6 +152,25 @@ type Router interface {
 	Route(Message) Sender
 }
 
+// ConcurrentRouter is a Router that declares itself safe for concurrent use.
+// Routers that are stateless (or that synchronise their own state) should
+// implement this interface so that the sender returned by `NewRouter` does not
+// serialise every call to `Route` behind a mutex.
+type ConcurrentRouter interface {
+	Router
+
+	// IsConcurrent is a marker method; it is never called.
+	IsConcurrent()
+}
+
+// DefaultMaxDepth is the maximum nesting depth of `Messages` used when the
+// `Options` of a task do not specify one.
+const DefaultMaxDepth = 64
+
+// ErrMaxDepthExceeded is passed to an `ErrorHandler` when a message is nested
+// more deeply than the maximum depth of its task.
+var ErrMaxDepthExceeded = errors.New("max depth exceeded")
+
 // Options are passed when constructing a `Task`. The `Cap` is the buffer
 // capacity of the `Task`'s channel, and the `Scale` is the number of worker
 // instances of the handler for load balancing. If `Scale` is an number less
@@ -69,20 +179,170 @@ type Router interface {
 // >= 2; this means that handlers that have and modify their own state are not
 // safe to be used at non-unity scales. Only handlers that are purely
 // functional should be used with non-unity scale.
+//
+// Setting `BatchSize` to a positive number enables batching. In batching mode,
+// a worker that receives a message will continue to drain up to `BatchSize`
+// queued messages from the buffer, waiting at most `BatchTimeout` for them to
+// arrive (a zero `BatchTimeout` means that only messages that are already
+// queued will be drained). The resulting batch is passed through `Coalesce`,
+// if it is not nil, and is then given to the handler. If the handler is a
+// `BatchHandler` it receives the whole batch at once, otherwise it receives
+// each message in turn.
+//
+// The `MaxDepth` is the maximum number of levels that `Messages` can be nested
+// inside one another. If it is less than 1, the `DefaultMaxDepth` is used.
+//
+// Setting `MaxRate.PerSecond` to a positive number limits the rate at which
+// the task takes messages from its buffer (across all workers). Every message
+// taken from the buffer, including messages drained into a batch, takes a
+// token from a token bucket (see `Rate`), and workers wait for tokens when
+// there are none. The `Clock` is used to measure the rate, and to wait for the
+// `BatchTimeout`; if it is nil, the `SystemClock` is used.
+//
+// The `Interceptors` wrap the handler (see `Chain`), and see every message
+// that is handled, unless the handler is a `BatchHandler` and batching is
+// enabled, in which case batches are given to `HandleBatch` directly. The
+// `SendInterceptors` wrap the `Send` method of the task (see `ChainSend`), and
+// see every message that is sent to the task before it is buffered.
+// Interceptors wrap the current behaviour of the task (see `BehaviourTask`), so
+// they continue to see messages when the behaviour changes.
+//
+// If `Credits` is not nil, every message that is sent to the task takes a
+// credit (after the `SendInterceptors` have accepted it), and messages are
+// rejected when there are no credits. The task grants a credit back whenever it
+// takes a message from its buffer, so producers can wait on `Credits.Ready` for
+// room in the buffer instead of retrying.
+//
+// The `StashCap` is the maximum number of messages that can be stashed (see
+// `BehaviourTask`). If it is less than 1, the stash is unbounded.
+//
+// The `Logger` is used to log events in the runtime of the task: messages that
+// are dropped because the buffer is full (at the warn level), messages that
+// cannot be handled (warn), panics in the handler (error, after which the
+// panic continues), and the task starting (debug) and stopping (info). Every
+// event includes the `Name` of the task. If the `Logger` is nil, the
+// `DefaultLogger` is used.
 type Options struct {
+	Name       string
 	Cap, Scale int
+
+	MaxDepth int
+	StashCap int
+
+	MaxRate Rate
+	Clock   Clock
+
+	BatchSize    int
+	BatchTimeout time.Duration
+	Coalesce     func(Messages) Messages
+
+	Interceptors     []Interceptor
+	SendInterceptors []SendInterceptor
+	Credits          *Credits
+
+	Logger Logger
+}
+
+// ErrorHandler is a `Handler` that is notified when a message sent to its task
+// cannot be handled. Handlers that are not an `ErrorHandler` will not know that
+// such a message was dropped.
+type ErrorHandler interface {
+	Handler
+	HandleError(Task, Message, error)
+}
+
+// BatchHandler is a `Handler` that can handle a batch of messages at once. It
+// is only used when batching is enabled in the `Options` of the task. The
+// batch is always flattened, and is never empty.
+type BatchHandler interface {
+	Handler
+	HandleBatch(Task, Messages)
 }
 
 // task is a basic implementation for a `Task`.
 type task struct {
-	// The handler for message handling logic.
-	handler Handler
+	// The number of messages that have been handled, and the time (in Unix
+	// nanoseconds) at which the last message was handled. These are accessed
+	// atomically, so they are first in the struct to be 64-bit aligned.
+	handled     uint64
+	lastHandled int64
+
+	// The name of the task, and the logger for runtime events. The logger is
+	// nil when the default logger should be used.
+	name   string
+	logger Logger
+
+	// The current behaviour of the task, and the current behaviour wrapped by
+	// the interceptors of the task. Messages are handled by the wrapped
+	// behaviour, but optional interfaces are implemented by the current
+	// behaviour. The current behaviour is the top of the behaviour stack, and
+	// is stored separately so that it can be loaded without locking.
+	behaviour   atomic.Value
+	behaviours  []Handler
+	behaviourMu *sync.Mutex
+	intercepted Handler
+
+	// Messages that have been stashed, and messages that have been unstashed
+	// but not yet handled.
+	stash     []Message
+	unstashed []Message
+	stashCap  int
+	stashMu   *sync.Mutex
+
+	// The tasks that watch this task, that this task watches, and that are
+	// linked to this task. Each call to `Run` that has not returned is a
+	// run, with a function that stops it. Once the last run has returned,
+	// the task has terminated: the reason is kept so that later watchers can
+	// be notified, and done is closed. Running the task again starts a new
+	// lifecycle, with no watchers or links. A task that is killed by a linked
+	// task before it runs terminates as soon as it starts.
+	watchMu    *sync.Mutex
+	watchers   map[*task]struct{}
+	watching   map[*task]struct{}
+	links      map[*task]struct{}
+	runs       map[int]context.CancelFunc
+	nextRun    int
+	groups     int
+	started    time.Time
+	killed     error
+	terminated bool
+	reason     error
+	done       chan struct{}
+
+	// The sender that buffers messages, wrapped by the send interceptors of
+	// the task.
+	sender Sender
 
 	// The buffered channel that incoming messages are written to.
 	input chan Message
 
 	// The scale (number of workers) for the task.
 	scale int
+
+	// The maximum nesting depth of messages handled by the task.
+	maxDepth int
+
+	// Guards the handler against concurrent access by workers while an
+	// `Atomic` message is being handled. It is only used when the scale is at
+	// least 2.
+	mu *sync.RWMutex
+
+	// The rate limit for taking messages from the buffer. The limiter is nil
+	// when there is no rate limit.
+	rate      Rate
+	clock     Clock
+	limiter   *bucket
+	limiterMu *sync.Mutex
+
+	// The batching options for the task. Batching is disabled when the batch
+	// size is less than 1.
+	batchSize    int
+	batchTimeout time.Duration
+	coalesce     func(Messages) Messages
+
+	// The credits that are granted when messages are taken from the buffer,
+	// or nil if there is no flow control.
+	credits *Credits
 }
 
 // New returns a new task with the given handler and buffer capacity. The
@@ -90,28 +350,134 @@ type task struct {
 // processing before the task can no longer accept more messages (until space
 // in the buffer is freed up by processing messages in the buffer).
 func New(handler Handler, opts Options) Task {
-	return &task{
-		handler: handler,
-		input:   make(chan Message, opts.Cap),
-		scale:   opts.Scale,
+	if opts.MaxDepth < 1 {
+		opts.MaxDepth = DefaultMaxDepth
+	}
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
+	}
+	var limiter *bucket
+	if opts.MaxRate.PerSecond > 0 {
+		limiter = newBucket(opts.MaxRate, opts.Clock.Now())
+	}
+	task := &task{
+		name:   opts.Name,
+		logger: opts.Logger,
+
+		behaviours:  []Handler{handler},
+		behaviourMu: new(sync.Mutex),
+
+		stashCap: opts.StashCap,
+		stashMu:  new(sync.Mutex),
+
+		watchMu:  new(sync.Mutex),
+		watchers: map[*task]struct{}{},
+		watching: map[*task]struct{}{},
+		links:    map[*task]struct{}{},
+		runs:     map[int]context.CancelFunc{},
+		done:     make(chan struct{}),
+
+		input:    make(chan Message, opts.Cap),
+		scale:    opts.Scale,
+		maxDepth: opts.MaxDepth,
+		mu:       new(sync.RWMutex),
+
+		rate:      opts.MaxRate,
+		clock:     opts.Clock,
+		limiter:   limiter,
+		limiterMu: new(sync.Mutex),
+
+		batchSize:    opts.BatchSize,
+		batchTimeout: opts.BatchTimeout,
+		coalesce:     opts.Coalesce,
+
+		credits: opts.Credits,
 	}
+	task.behaviour.Store(behaviour{handler})
+	task.intercepted = Chain(HandlerFunc(func(self Task, m Message) {
+		task.current().Handle(self, m)
+	}), opts.Interceptors...)
+	sendInterceptors := opts.SendInterceptors
+	if opts.Credits != nil {
+		// Credits are taken last, so that messages rejected by the other
+		// interceptors do not take them.
+		sendInterceptors = append(sendInterceptors[:len(sendInterceptors):len(sendInterceptors)], opts.Credits.SendInterceptor())
+	}
+	task.sender = ChainSend(SenderFunc(task.enqueue), sendInterceptors...)
+	return task
 }
 
 // Run implements the `Runner` interface (in order to implement the `Task`
 // interface). This function blocks. The task will continue to run until it is
-// signalled to terminate by the context.
+// signalled to terminate by the context, or until it is terminated by a linked
+// task (see `WatchableTask`). If the handler panics while the task is watched,
+// linked, or run by a `Group`, the panic is contained and the task terminates;
+// otherwise, the panic continues.
+//
+// A task can be run more than once, including concurrently (in which case the
+// runs share the buffer of the task). The task terminates when its last run
+// returns, and running it again after that starts it afresh. A panic that is
+// contained in one run stops all of the others.
 func (task *task) Run(ctx context.Context) {
+	inner, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var panicOnce sync.Once
+	var panicked error
 	loop := func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panicOnce.Do(func() {
+					panicked = co.PanicError{Value: r, Stack: debug.Stack()}
+				})
+				if !task.watched() {
+					panic(r)
+				}
+				stop()
+			}
+		}()
 		for {
+			if inner.Err() != nil {
+				return
+			}
+			if task.handleUnstashed() {
+				continue
+			}
 			select {
-			case <-ctx.Done():
+			case <-inner.Done():
 				return
 			case message := <-task.input:
-				task.handle(flatten(message))
+				task.received()
+				if !task.throttle(inner) {
+					// The message has been taken from the buffer, so it
+					// must be reported instead of silently lost.
+					task.fail(message, inner.Err())
+					return
+				}
+				if task.batchSize > 0 {
+					task.handleBatch(task.drain(inner, message))
+				} else {
+					task.handle(message)
+				}
 			}
 		}
 	}
 
+	id, ok := task.start(stop)
+	defer func() {
+		reason := task.finish(id, panicked, ctx.Err())
+		if l := task.log(); l != nil {
+			l.Info("task stopped", task.attrs(nil, "reason", reason)...)
+		}
+	}()
+	if !ok {
+		return
+	}
+
+	if l := task.log(); l != nil {
+		l.Debug("task started", task.attrs(nil, "scale", task.scale)...)
+	}
+
 	// Don't spawn a go routine if there is no load balancing
 	if task.scale < 2 {
 		loop()
@@ -124,50 +490,347 @@ func (task *task) Run(ctx context.Context) {
 // interface). It returns a channel to which the (possibly nil) response will
 // be written, and a bool indicating whether the message was able to be sent;
 // true indicates the message was sent, and false indicates that the task
-// currently has a full buffer and won't accept the message.
+// currently has a full buffer and won't accept the message. Messages pass
+// through the send interceptors of the task before they are buffered.
 func (task *task) Send(m Message) bool {
+	return task.sender.Send(m)
+}
+
+// Resend sends a message to a sender, and keeps sending it again every `retry`
+// (as measured by the clock) until it is accepted. It gives up when done is
+// closed, or when the sender is a task that has terminated (see
+// `MonitoredTask`). It blocks, and returns whether or not the message was
+// accepted. It is useful for messages that must not be dropped just because the
+// buffer of the receiver is full, such as timeouts that a handler sends to its
+// own task.
+func Resend(clock Clock, to Sender, m Message, retry time.Duration, done <-chan struct{}) bool {
+	for {
+		if to.Send(m) {
+			return true
+		}
+		if monitored, ok := to.(MonitoredTask); ok && monitored.Status().Terminated {
+			return false
+		}
+		select {
+		case <-done:
+			return false
+		case <-clock.After(retry):
+		}
+	}
+}
+
+// enqueue a message in the input buffer, without blocking.
+func (task *task) enqueue(m Message) bool {
 	select {
 	case task.input <- m:
 		return true
 	default:
+		if l := task.log(); l != nil {
+			l.Warn("message dropped", task.attrs(m)...)
+		}
 		return false
 	}
 }
 
-// handle a message sent to the Task. It is assumed that the message is
-// flattened.
+// received is called whenever a message is taken from the input buffer.
+func (task *task) received() {
+	if task.credits != nil {
+		task.credits.Grant(1)
+	}
+}
+
+// log returns the logger for runtime events, or nil if they should not be
+// logged.
+func (task *task) log() Logger {
+	if task.logger != nil {
+		return task.logger
+	}
+	return DefaultLogger()
+}
+
+// attrs returns the attributes included in every runtime event: the name of
+// the task, the type of the message (if there is one), and the state of the
+// buffer. Extra attributes are appended.
+func (task *task) attrs(m Message, extra ...interface{}) []interface{} {
+	attrs := []interface{}{"task", task.name}
+	if m != nil {
+		attrs = append(attrs, "message", fmt.Sprintf("%T", m))
+	}
+	attrs = append(attrs, "queued", len(task.input), "capacity", cap(task.input))
+	return append(attrs, extra...)
+}
+
+// logPanic logs a panic raised while handling a message, and then continues
+// panicking. It must be deferred.
+func (task *task) logPanic(m Message) {
+	if r := recover(); r != nil {
+		if l := task.log(); l != nil {
+			l.Error("handler panicked", task.attrs(m, "panic", r, "stack", string(debug.Stack()))...)
+		}
+		panic(r)
+	}
+}
+
+// call the (intercepted) handler with a message.
+func (task *task) call(m Message) {
+	defer task.logPanic(m)
+	task.intercepted.Handle(task, m)
+	task.count(1)
+}
+
+// throttle waits until the rate limit of the task allows another message to be
+// taken from the buffer. It returns false if the context is done first.
+func (task *task) throttle(ctx context.Context) bool {
+	for {
+		ok, wait := task.take()
+		if ok {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-task.clock.After(wait):
+		}
+	}
+}
+
+// take a token from the rate limiter of the task. If there are no tokens, it
+// returns false and the time until the next token will be available.
+func (task *task) take() (bool, time.Duration) {
+	if task.limiter == nil {
+		return true, 0
+	}
+	task.limiterMu.Lock()
+	defer task.limiterMu.Unlock()
+	return task.limiter.take(task.rate, task.clock.Now())
+}
+
+// refund a token that was taken from the rate limiter of the task, but was not
+// used.
+func (task *task) refund() {
+	if task.limiter == nil {
+		return
+	}
+	task.limiterMu.Lock()
+	defer task.limiterMu.Unlock()
+	task.limiter.refund(task.rate)
+}
+
+// handle a message sent to the Task. Nested messages are traversed in order,
+// and each message is dispatched to the handler as it is reached. If the
+// message is nested too deeply, none of it will be handled.
 func (task *task) handle(m Message) {
+	atomic, err := task.check(m, 0)
+	if err != nil {
+		task.fail(m, err)
+		return
+	}
+	defer task.progress()
+	defer task.lock(atomic)()
+	task.dispatch(m)
+}
+
+// dispatch each message in a (possibly nested) message to the handler. It is
+// assumed that the message has been checked.
+func (task *task) dispatch(m Message) {
 	switch m := m.(type) {
 	case Messages:
 		for _, msg := range m {
-			task.handler.Handle(task, msg)
+			task.dispatch(msg)
+		}
+	case Atomic:
+		for _, msg := range m {
+			task.dispatch(msg)
 		}
 	default:
-		task.handler.Handle(task, m)
+		task.call(m)
 	}
 }
 
-// flatten takes a message and effectively flattens it out to depth 1, where
-// the depth is determined by the level of nested Messages. If `flatten`
-// receives a message that is not a `Messages`, it will return the same
-// message. Otherwise, it will return a `Messages` type where the internal list
-// of messages contain no `Messages`; these will be flattened out.
-func flatten(message Message) Message {
-	switch message := message.(type) {
+// check that the nesting depth of a message does not exceed the maximum depth
+// of the task, and report whether or not it contains an `Atomic` message.
+// Cyclic messages (a `Messages` that contains itself) will always exceed the
+// maximum depth.
+func (task *task) check(m Message, depth int) (bool, error) {
+	var msgs []Message
+	atomic := false
+	switch m := m.(type) {
 	case Messages:
-		msgs := Messages{}
-		for _, msg := range message {
-			m := flatten(msg)
-			switch m := m.(type) {
-			case Messages:
-				msgs = append(msgs, m...)
+		msgs = m
+	case Atomic:
+		msgs = m
+		atomic = true
+	default:
+		return false, nil
+	}
+	if depth >= task.maxDepth {
+		return false, ErrMaxDepthExceeded
+	}
+	for _, msg := range msgs {
+		a, err := task.check(msg, depth+1)
+		if err != nil {
+			return false, err
+		}
+		atomic = atomic || a
+	}
+	return atomic, nil
+}
+
+// lock the handler for the processing of a message, and return the function
+// that unlocks it. Atomic messages require exclusive access to the handler,
+// so that no other worker can handle messages while they are being processed.
+// Locking is not needed when there is only one worker.
+func (task *task) lock(atomic bool) func() {
+	if task.scale < 2 {
+		return func() {}
+	}
+	if atomic {
+		task.mu.Lock()
+		return task.mu.Unlock
+	}
+	task.mu.RLock()
+	return task.mu.RUnlock
+}
+
+// fail reports an error that prevented a message from being handled. If the
+// handler is not an `ErrorHandler` the message is dropped, and only logged.
+func (task *task) fail(m Message, err error) {
+	if l := task.log(); l != nil {
+		l.Warn("message not handled", task.attrs(m, "error", err)...)
+	}
+	if handler, ok := task.current().(ErrorHandler); ok {
+		handler.HandleError(task, m, err)
+	}
+}
+
+// drain the input buffer into a flattened batch that begins with the given
+// message. At most `batchSize` messages will be taken from the buffer, and the
+// drain will stop early if the batch timeout expires or the context is done.
+// The returned boolean reports whether or not the batch contains the contents
+// of an `Atomic` message.
+func (task *task) drain(ctx context.Context, message Message) (Messages, bool) {
+	batch, atomic := task.appendBatch(make(Messages, 0, task.batchSize), message)
+
+	var timeout <-chan time.Time
+	if task.batchTimeout > 0 {
+		timeout = task.clock.After(task.batchTimeout)
+	}
+
+	for n := 1; n < task.batchSize; n++ {
+		if ok, _ := task.take(); !ok {
+			// Do not drain more messages than the rate limit allows.
+			return batch, atomic
+		}
+		if timeout == nil {
+			select {
+			case message := <-task.input:
+				task.received()
+				var a bool
+				batch, a = task.appendBatch(batch, message)
+				atomic = atomic || a
+				continue
 			default:
-				msgs = append(msgs, m)
 			}
+			task.refund()
+			return batch, atomic
+		}
+		select {
+		case message := <-task.input:
+			task.received()
+			var a bool
+			batch, a = task.appendBatch(batch, message)
+			atomic = atomic || a
+		case <-timeout:
+			task.refund()
+			return batch, atomic
+		case <-ctx.Done():
+			task.refund()
+			return batch, atomic
+		}
+	}
+	return batch, atomic
+}
+
+// appendBatch appends each message in a (possibly nested) message to the
+// batch, and reports whether or not it contained an `Atomic` message. If the
+// message is nested too deeply, none of it will be appended.
+func (task *task) appendBatch(batch Messages, m Message) (Messages, bool) {
+	atomic, err := task.check(m, 0)
+	if err != nil {
+		task.fail(m, err)
+		return batch, false
+	}
+	return appendFlattened(batch, m), atomic
+}
+
+// handleBatch coalesces a flattened batch and then passes it to the handler.
+// Batches that contain the contents of an `Atomic` message are never
+// coalesced, and are handled with exclusive access to the handler.
+func (task *task) handleBatch(batch Messages, atomic bool) {
+	if task.coalesce != nil && !atomic {
+		batch = task.coalesce(batch)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	defer task.progress()
+	defer task.lock(atomic)()
+	if handler, ok := task.current().(BatchHandler); ok {
+		defer task.logPanic(batch)
+		handler.HandleBatch(task, batch)
+		task.count(len(batch))
+		return
+	}
+	for _, msg := range batch {
+		task.call(msg)
+	}
+}
+
+// LatestByKey returns a coalescing function, suitable for use in `Options`,
+// that keeps only the latest message for each key. Messages for which the key
+// function returns false are never coalesced. The relative order of the
+// remaining messages is preserved.
+func LatestByKey(key func(Message) (interface{}, bool)) func(Messages) Messages {
+	return func(msgs Messages) Messages {
+		seen := map[interface{}]struct{}{}
+		keep := make([]bool, len(msgs))
+		n := 0
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if k, ok := key(msgs[i]); ok {
+				if _, ok := seen[k]; ok {
+					continue
+				}
+				seen[k] = struct{}{}
+			}
+			keep[i] = true
+			n++
+		}
+		coalesced := make(Messages, 0, n)
+		for i, msg := range msgs {
+			if keep[i] {
+				coalesced = append(coalesced, msg)
+			}
+		}
+		return coalesced
+	}
+}
+
+// appendFlattened appends each message in a (possibly nested) message to the
+// given messages. It is assumed that the message has been checked.
+func appendFlattened(msgs Messages, m Message) Messages {
+	switch m := m.(type) {
+	case Messages:
+		for _, msg := range m {
+			msgs = appendFlattened(msgs, msg)
+		}
+		return msgs
+	case Atomic:
+		for _, msg := range m {
+			msgs = appendFlattened(msgs, msg)
 		}
 		return msgs
 	default:
-		return message
+		return append(msgs, m)
 	}
 }
 
@@ -180,12 +843,18 @@ type router struct {
 // NewRouter returns a new sender that represents a Router. The given Router
 // determines how the sender routes messages; any message `m` that is sent to
 // this sender will be sent to the sender determined by the Router through
-// `Route(m)`.
-func NewRouter(r Router) Sender {
-	return &router{
+// `Route(m)`. Calls to `Route` are serialised, unless the Router is a
+// `ConcurrentRouter`, in which case `Route` will be called concurrently by
+// all goroutines that send to the returned sender. Messages pass through the
+// given interceptors (see `ChainSend`) before they are routed.
+func NewRouter(r Router, interceptors ...SendInterceptor) Sender {
+	if r, ok := r.(ConcurrentRouter); ok {
+		return ChainSend(&concurrentRouter{r: r}, interceptors...)
+	}
+	return ChainSend(&router{
 		rMu: new(sync.Mutex),
 		r:   r,
-	}
+	}, interceptors...)
 }
 
 // Send implements the `Sender` interface. If the resolver returns a nil Sender,
@@ -201,3 +870,18 @@ func (r *router) Send(message Message) bool {
 	}
 	return true
 }
+
+// concurrentRouter is an implementation of a `Sender` that is a resolver that
+// is safe for concurrent use, and so does not need to be guarded by a mutex.
+type concurrentRouter struct {
+	r ConcurrentRouter
+}
+
+// Send implements the `Sender` interface. If the resolver returns a nil Sender,
+// it signifies that the message is not to be sent anywhere.
+func (r *concurrentRouter) Send(message Message) bool {
+	if sender := r.r.Route(message); sender != nil {
+		return sender.Send(message)
+	}
+	return true
+}
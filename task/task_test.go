@@ -1 +1,638 @@
-package task_test
\ No newline at end of file
+package task_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/task"
+
+	"github.com/renproject/phi/co"
+)
+
+type testMessage struct {
+	key int
+}
+
+func (testMessage) IsMessage() {}
+
+// counter is a `Sender` that counts the messages that it is sent.
+type counter struct {
+	n int64
+}
+
+func (c *counter) Send(Message) bool {
+	atomic.AddInt64(&c.n, 1)
+	return true
+}
+
+func (c *counter) count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// modRouter routes messages to one of its senders based on the message key.
+type modRouter struct {
+	senders []Sender
+}
+
+func (r *modRouter) Route(m Message) Sender {
+	msg, ok := m.(testMessage)
+	if !ok {
+		return nil
+	}
+	return r.senders[msg.key%len(r.senders)]
+}
+
+// concurrentModRouter is a `modRouter` that declares itself safe for
+// concurrent use.
+type concurrentModRouter struct {
+	modRouter
+}
+
+func (r *concurrentModRouter) IsConcurrent() {}
+
+var _ = Describe("Router", func() {
+
+	for _, concurrent := range []bool{false, true} {
+		concurrent := concurrent
+
+		Context(fmt.Sprintf("when the router is concurrent=%v", concurrent), func() {
+
+			newRouter := func(senders ...Sender) Sender {
+				if concurrent {
+					return NewRouter(&concurrentModRouter{modRouter{senders: senders}})
+				}
+				return NewRouter(&modRouter{senders: senders})
+			}
+
+			It("should route messages to the correct sender", func() {
+				a, b := new(counter), new(counter)
+				router := newRouter(a, b)
+				co.ParForAll(100, func(i int) {
+					Expect(router.Send(testMessage{key: i})).To(BeTrue())
+				})
+				Expect(a.count()).To(Equal(int64(50)))
+				Expect(b.count()).To(Equal(int64(50)))
+			})
+
+			It("should drop messages that are routed to a nil sender", func() {
+				a := new(counter)
+				router := newRouter(a)
+				Expect(router.Send(Messages{})).To(BeTrue())
+				Expect(a.count()).To(Equal(int64(0)))
+			})
+		})
+	}
+})
+
+// recorder is a `Handler` that writes every message, and every batch, that it
+// handles to a channel.
+type recorder struct {
+	messages chan Message
+	batches  chan Messages
+}
+
+func newRecorder(n int) *recorder {
+	return &recorder{
+		messages: make(chan Message, n),
+		batches:  make(chan Messages, n),
+	}
+}
+
+func (r *recorder) Handle(_ Task, m Message) {
+	r.messages <- m
+}
+
+// batchRecorder is a `recorder` that implements the `BatchHandler` interface.
+type batchRecorder struct {
+	*recorder
+}
+
+func (r batchRecorder) HandleBatch(_ Task, msgs Messages) {
+	r.batches <- msgs
+}
+
+var _ = Describe("Batching", func() {
+
+	keys := func(msgs Messages) []int {
+		ks := make([]int, len(msgs))
+		for i, msg := range msgs {
+			ks[i] = msg.(testMessage).key
+		}
+		return ks
+	}
+
+	Context("when the handler is a batch handler", func() {
+
+		It("should drain queued messages into a single batch", func() {
+			r := batchRecorder{newRecorder(10)}
+			t := New(r, Options{Cap: 10, BatchSize: 10})
+			for i := 0; i < 10; i++ {
+				Expect(t.Send(testMessage{key: i})).To(BeTrue())
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			var batch Messages
+			Eventually(r.batches).Should(Receive(&batch))
+			Expect(keys(batch)).To(Equal([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}))
+		})
+
+		It("should not exceed the batch size", func() {
+			r := batchRecorder{newRecorder(10)}
+			t := New(r, Options{Cap: 10, BatchSize: 4})
+			for i := 0; i < 10; i++ {
+				Expect(t.Send(testMessage{key: i})).To(BeTrue())
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			var batch Messages
+			for _, expected := range [][]int{{0, 1, 2, 3}, {4, 5, 6, 7}, {8, 9}} {
+				Eventually(r.batches).Should(Receive(&batch))
+				Expect(keys(batch)).To(Equal(expected))
+			}
+		})
+
+		It("should flatten nested messages into the batch", func() {
+			r := batchRecorder{newRecorder(10)}
+			t := New(r, Options{Cap: 10, BatchSize: 2})
+			Expect(t.Send(Messages{testMessage{key: 0}, Messages{testMessage{key: 1}}})).To(BeTrue())
+			Expect(t.Send(testMessage{key: 2})).To(BeTrue())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			var batch Messages
+			Eventually(r.batches).Should(Receive(&batch))
+			Expect(keys(batch)).To(Equal([]int{0, 1, 2}))
+		})
+
+		It("should wait for the batch timeout before handling the batch", func() {
+			r := batchRecorder{newRecorder(10)}
+			t := New(r, Options{Cap: 10, BatchSize: 3, BatchTimeout: 100 * time.Millisecond})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			Expect(t.Send(testMessage{key: 0})).To(BeTrue())
+			time.Sleep(10 * time.Millisecond)
+			Expect(t.Send(testMessage{key: 1})).To(BeTrue())
+
+			var batch Messages
+			Eventually(r.batches).Should(Receive(&batch))
+			Expect(keys(batch)).To(Equal([]int{0, 1}))
+		})
+
+		It("should use the clock to wait for the batch timeout", func() {
+			r := batchRecorder{newRecorder(10)}
+			clock := newFakeClock()
+			t := New(r, Options{Cap: 10, BatchSize: 3, BatchTimeout: time.Second, Clock: clock})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			Expect(t.Send(testMessage{key: 0})).To(BeTrue())
+			Eventually(clock.Waiters).Should(Equal(1))
+			Expect(t.Send(testMessage{key: 1})).To(BeTrue())
+			Consistently(r.batches, 20*time.Millisecond).ShouldNot(Receive())
+
+			clock.Advance(time.Second)
+			var batch Messages
+			Eventually(r.batches).Should(Receive(&batch))
+			Expect(keys(batch)).To(Equal([]int{0, 1}))
+		})
+
+		It("should coalesce the batch", func() {
+			r := batchRecorder{newRecorder(10)}
+			t := New(r, Options{
+				Cap:       10,
+				BatchSize: 10,
+				Coalesce: LatestByKey(func(m Message) (interface{}, bool) {
+					return m.(testMessage).key % 3, true
+				}),
+			})
+			for i := 0; i < 10; i++ {
+				Expect(t.Send(testMessage{key: i})).To(BeTrue())
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			var batch Messages
+			Eventually(r.batches).Should(Receive(&batch))
+			Expect(keys(batch)).To(Equal([]int{7, 8, 9}))
+		})
+	})
+
+	Context("when the handler is not a batch handler", func() {
+
+		It("should handle each message in the batch in order", func() {
+			r := newRecorder(10)
+			t := New(r, Options{Cap: 10, BatchSize: 10})
+			for i := 0; i < 10; i++ {
+				Expect(t.Send(testMessage{key: i})).To(BeTrue())
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			for i := 0; i < 10; i++ {
+				Eventually(r.messages).Should(Receive(Equal(testMessage{key: i})))
+			}
+		})
+	})
+})
+
+func benchmarkRouter(b *testing.B, router Sender) {
+	for _, parallelism := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("parallelism=%v", parallelism), func(b *testing.B) {
+			b.SetParallelism(parallelism)
+			b.RunParallel(func(pb *testing.PB) {
+				key := 0
+				for pb.Next() {
+					router.Send(testMessage{key: key})
+					key++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkRouter(b *testing.B) {
+	benchmarkRouter(b, NewRouter(&modRouter{senders: []Sender{new(counter), new(counter)}}))
+}
+
+func BenchmarkConcurrentRouter(b *testing.B) {
+	benchmarkRouter(b, NewRouter(&concurrentModRouter{modRouter{senders: []Sender{new(counter), new(counter)}}}))
+}
+
+// errorRecorder is a `recorder` that implements the `ErrorHandler` interface.
+type errorRecorder struct {
+	*recorder
+	errs chan error
+}
+
+func (r errorRecorder) HandleError(_ Task, _ Message, err error) {
+	r.errs <- err
+}
+
+var _ = Describe("Nested messages", func() {
+
+	run := func(t Task) context.CancelFunc {
+		ctx, cancel := context.WithCancel(context.Background())
+		go t.Run(ctx)
+		return cancel
+	}
+
+	It("should handle nested messages in order", func() {
+		r := newRecorder(10)
+		t := New(r, Options{Cap: 1})
+		defer run(t)()
+
+		Expect(t.Send(Messages{
+			testMessage{key: 0},
+			Messages{testMessage{key: 1}, Messages{testMessage{key: 2}}},
+			Messages{},
+			testMessage{key: 3},
+		})).To(BeTrue())
+		for i := 0; i < 4; i++ {
+			Eventually(r.messages).Should(Receive(Equal(testMessage{key: i})))
+		}
+	})
+
+	for _, batchSize := range []int{0, 1} {
+		batchSize := batchSize
+
+		Context(fmt.Sprintf("when the batch size is %v", batchSize), func() {
+
+			It("should report messages that exceed the max depth", func() {
+				r := errorRecorder{newRecorder(10), make(chan error, 10)}
+				t := New(r, Options{Cap: 2, MaxDepth: 2, BatchSize: batchSize})
+				defer run(t)()
+
+				Expect(t.Send(Messages{testMessage{key: 0}, Messages{Messages{testMessage{key: 1}}}})).To(BeTrue())
+				Eventually(r.errs).Should(Receive(Equal(ErrMaxDepthExceeded)))
+				Expect(t.Send(Messages{Messages{testMessage{key: 2}}})).To(BeTrue())
+				Eventually(r.messages).Should(Receive(Equal(testMessage{key: 2})))
+				Consistently(r.messages).ShouldNot(Receive())
+			})
+
+			It("should report cyclic messages", func() {
+				r := errorRecorder{newRecorder(10), make(chan error, 10)}
+				t := New(r, Options{Cap: 1, BatchSize: batchSize})
+				defer run(t)()
+
+				cycle := Messages{testMessage{key: 0}, nil}
+				cycle[1] = cycle
+				Expect(t.Send(cycle)).To(BeTrue())
+				Eventually(r.errs).Should(Receive(Equal(ErrMaxDepthExceeded)))
+				Expect(r.messages).ShouldNot(Receive())
+			})
+		})
+	}
+})
+
+// logger is a `Handler` that logs the keys of the messages it handles. It is
+// safe for concurrent use.
+type logger struct {
+	mu   *sync.Mutex
+	keys []int
+}
+
+func (l *logger) Handle(_ Task, m Message) {
+	time.Sleep(time.Millisecond)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.keys = append(l.keys, m.(testMessage).key)
+}
+
+func (l *logger) log() []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]int{}, l.keys...)
+}
+
+var _ = Describe("Atomic messages", func() {
+
+	atomicMessage := func(from, to int) Atomic {
+		msgs := Atomic{}
+		for i := from; i < to; i++ {
+			msgs = append(msgs, testMessage{key: i})
+		}
+		return msgs
+	}
+
+	for _, batchSize := range []int{0, 2} {
+		batchSize := batchSize
+
+		Context(fmt.Sprintf("when the batch size is %v", batchSize), func() {
+
+			It("should handle atomic messages without interleaving", func() {
+				l := &logger{mu: new(sync.Mutex)}
+				t := New(l, Options{Cap: 100, Scale: 4, BatchSize: batchSize})
+				for i := 0; i < 20; i++ {
+					Expect(t.Send(testMessage{key: i})).To(BeTrue())
+				}
+				Expect(t.Send(Messages{testMessage{key: 20}, atomicMessage(100, 110)})).To(BeTrue())
+				for i := 21; i < 40; i++ {
+					Expect(t.Send(testMessage{key: i})).To(BeTrue())
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				go t.Run(ctx)
+
+				Eventually(func() int { return len(l.log()) }).Should(Equal(50))
+				keys := l.log()
+				start := 0
+				for keys[start] != 100 {
+					start++
+				}
+				Expect(keys[start : start+10]).To(Equal([]int{100, 101, 102, 103, 104, 105, 106, 107, 108, 109}))
+			})
+		})
+	}
+
+	It("should not coalesce atomic messages", func() {
+		r := batchRecorder{newRecorder(10)}
+		t := New(r, Options{
+			Cap:       10,
+			BatchSize: 10,
+			Coalesce: func(Messages) Messages {
+				return Messages{}
+			},
+		})
+		Expect(t.Send(testMessage{key: 0})).To(BeTrue())
+		Expect(t.Send(atomicMessage(1, 3))).To(BeTrue())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go t.Run(ctx)
+
+		Eventually(r.batches).Should(Receive(Equal(Messages{testMessage{key: 0}, testMessage{key: 1}, testMessage{key: 2}})))
+	})
+
+	It("should admit or reject an atomic message as one unit", func() {
+		// Every part of the atomic message would need its own credit, and
+		// its own slot in the buffer, if the parts were admitted separately.
+		credits := NewCredits(2)
+		l := &logger{mu: new(sync.Mutex)}
+		t := New(l, Options{Cap: 2, Scale: 4, Credits: credits})
+		Expect(t.Send(atomicMessage(0, 10))).To(BeTrue())
+		Expect(credits.Available()).To(Equal(1))
+		Expect(t.Send(testMessage{key: 10})).To(BeTrue())
+		Expect(t.Send(atomicMessage(20, 30))).To(BeFalse())
+		Expect(credits.Available()).To(Equal(0))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go t.Run(ctx)
+
+		Eventually(func() int { return len(l.log()) }).Should(Equal(11))
+		Consistently(func() int { return len(l.log()) }, 20*time.Millisecond).Should(Equal(11))
+		keys := l.log()
+		start := 0
+		for keys[start] != 0 {
+			start++
+		}
+		Expect(keys[start : start+10]).To(Equal([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}))
+		Expect(keys).To(ContainElement(10))
+	})
+})
+
+var _ = Describe("Futures", func() {
+
+	It("should send the result of an awaited future as a message", func() {
+		r := newRecorder(1)
+		t := New(r, Options{Cap: 1})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go t.Run(ctx)
+
+		future := co.Async(func() (int, error) {
+			time.Sleep(10 * time.Millisecond)
+			return 42, nil
+		})
+		Await(ctx, future, t)
+
+		var message Message
+		Eventually(r.messages).Should(Receive(&message))
+		Expect(message).To(Equal(Completed[int]{Future: future, Value: 42}))
+	})
+
+	It("should retry until the result is accepted", func() {
+		r := newRecorder(2)
+		t := New(r, Options{Cap: 1})
+		Expect(t.Send(testMessage{})).To(BeTrue())
+
+		future := co.Resolve(42, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		Await(ctx, future, t)
+		time.Sleep(20 * time.Millisecond)
+		go t.Run(ctx)
+
+		Eventually(r.messages).Should(Receive(Equal(testMessage{})))
+		Eventually(r.messages).Should(Receive(Equal(Completed[int]{Future: future, Value: 42})))
+	})
+
+	It("should time retries with the clock of the task", func() {
+		clock := newFakeClock()
+		r := newRecorder(2)
+		t := New(r, Options{Cap: 1, Clock: clock})
+		Expect(t.Send(testMessage{})).To(BeTrue())
+
+		future := co.Resolve(42, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		Await(ctx, future, t)
+		Eventually(clock.Waiters).Should(Equal(1))
+		go t.Run(ctx)
+		Eventually(r.messages).Should(Receive(Equal(testMessage{})))
+		Consistently(r.messages, 10*time.Millisecond).ShouldNot(Receive())
+
+		clock.Advance(time.Second)
+		Eventually(r.messages).Should(Receive(Equal(Completed[int]{Future: future, Value: 42})))
+	})
+})
+
+var _ = Describe("Resending", func() {
+
+	It("should resend a message until it is accepted", func() {
+		clock := newFakeClock()
+		attempts := int64(0)
+		to := SenderFunc(func(Message) bool {
+			return atomic.AddInt64(&attempts, 1) >= 3
+		})
+		accepted := make(chan bool, 1)
+		go func() { accepted <- Resend(clock, to, testMessage{}, time.Second, nil) }()
+
+		for i := 0; i < 2; i++ {
+			Eventually(clock.Waiters).Should(Equal(1))
+			clock.Advance(time.Second)
+		}
+		Eventually(accepted).Should(Receive(BeTrue()))
+		Expect(atomic.LoadInt64(&attempts)).To(Equal(int64(3)))
+	})
+
+	It("should give up when done, or when the task has terminated", func() {
+		done := make(chan struct{})
+		close(done)
+		Expect(Resend(newFakeClock(), SenderFunc(func(Message) bool { return false }), testMessage{}, time.Second, done)).To(BeFalse())
+
+		// The task is not retried at all once it has terminated.
+		clock := newFakeClock()
+		t := New(newRecorder(1), Options{Cap: 1})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		t.Run(ctx)
+		Expect(t.Send(testMessage{})).To(BeTrue())
+		Expect(Resend(clock, t, testMessage{}, time.Second, nil)).To(BeFalse())
+		Expect(clock.Waiters()).To(Equal(0))
+	})
+})
+
+// signaller is a `Handler` that signals whenever it handles a message with a
+// negative key.
+type signaller struct {
+	done chan struct{}
+}
+
+func (s *signaller) Handle(_ Task, m Message) {
+	if m.(testMessage).key < 0 {
+		s.done <- struct{}{}
+	}
+}
+
+// nested returns a message with the given nesting depth, where each level of
+// nesting contains the given number of messages.
+func nested(depth, width int) Message {
+	if depth == 0 {
+		return testMessage{}
+	}
+	msgs := make(Messages, width)
+	for i := range msgs {
+		msgs[i] = nested(depth-1, width)
+	}
+	return msgs
+}
+
+// flatten is a copy of the function that tasks used to flatten nested messages
+// before handling them, so that benchmarks can compare it with streaming.
+func flatten(message Message) Message {
+	switch message := message.(type) {
+	case Messages:
+		msgs := Messages{}
+		for _, msg := range message {
+			m := flatten(msg)
+			switch m := m.(type) {
+			case Messages:
+				msgs = append(msgs, m...)
+			default:
+				msgs = append(msgs, m)
+			}
+		}
+		return msgs
+	default:
+		return message
+	}
+}
+
+// benchmarkNestedMessages sends nested messages to a task, and waits for each
+// of them to be handled. Each message is passed through the prepare function
+// before it is sent.
+func benchmarkNestedMessages(b *testing.B, opts Options, prepare func(Message) Message) {
+	for _, shape := range []struct{ depth, width int }{{1, 64}, {3, 4}, {16, 1}} {
+		b.Run(fmt.Sprintf("depth=%v/width=%v", shape.depth, shape.width), func(b *testing.B) {
+			s := &signaller{done: make(chan struct{})}
+			t := New(s, opts)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go t.Run(ctx)
+
+			var message Message = Messages{nested(shape.depth, shape.width), testMessage{key: -1}}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m := prepare(message)
+				for !t.Send(m) {
+				}
+				<-s.done
+			}
+		})
+	}
+}
+
+func identity(m Message) Message {
+	return m
+}
+
+// BenchmarkNestedMessagesFlattened is the baseline for the nested message
+// benchmarks: it flattens every message before sending it, which is what
+// tasks did before they streamed nested messages to their handlers.
+func BenchmarkNestedMessagesFlattened(b *testing.B) {
+	benchmarkNestedMessages(b, Options{Cap: 1}, flatten)
+}
+
+func BenchmarkNestedMessages(b *testing.B) {
+	benchmarkNestedMessages(b, Options{Cap: 1}, identity)
+}
+
+func BenchmarkNestedMessagesBatched(b *testing.B) {
+	benchmarkNestedMessages(b, Options{Cap: 1, BatchSize: 1}, identity)
+}
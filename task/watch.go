@@ -0,0 +1,301 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Terminated is the message delivered to the tasks that watch a target task
+// when the target terminates (see `WatchableTask`). The `Reason` is the error of
+// the context of the target when its `Run` returned normally, a `co.PanicError`
+// when the target panicked, or a `LinkError` when a task linked to the target
+// terminated abnormally.
+type Terminated struct {
+	Target Task
+	Reason error
+}
+
+// IsMessage implements the `Message` interface.
+func (Terminated) IsMessage() {}
+
+// LinkError is the reason that a task terminates when a task linked to it
+// terminates abnormally (see `WatchableTask`).
+type LinkError struct {
+	Target Task
+	Reason error
+}
+
+// Error implements the `error` interface.
+func (err LinkError) Error() string {
+	return fmt.Sprintf("linked task terminated: %v", err.Reason)
+}
+
+// Unwrap returns the reason that the linked task terminated.
+func (err LinkError) Unwrap() error {
+	return err.Reason
+}
+
+// ErrNotWatchable is returned when watching, or linking to, a task that was
+// not returned by `New`.
+var ErrNotWatchable = errors.New("task is not watchable")
+
+// terminatedRetry is how long a task waits before redelivering a `Terminated`
+// message to a watcher that did not accept it (because its buffer was full).
+const terminatedRetry = 10 * time.Millisecond
+
+// Watch implements the `WatchableTask` interface.
+func (task *task) Watch(target Task) error {
+	t, ok := watchable(target)
+	if !ok {
+		return ErrNotWatchable
+	}
+	t.watchMu.Lock()
+	if t.terminated {
+		reason := t.reason
+		t.watchMu.Unlock()
+		task.deliver(Terminated{Target: t, Reason: reason})
+		return nil
+	}
+	t.watchers[task] = struct{}{}
+	t.watchMu.Unlock()
+
+	task.watchMu.Lock()
+	terminated := task.terminated
+	if !terminated {
+		task.watching[t] = struct{}{}
+	}
+	task.watchMu.Unlock()
+	if terminated {
+		// The watcher has terminated, so it no longer needs to be notified.
+		t.removeWatcher(task)
+	}
+	return nil
+}
+
+// Unwatch implements the `WatchableTask` interface.
+func (task *task) Unwatch(target Task) {
+	t, ok := watchable(target)
+	if !ok {
+		return
+	}
+	t.removeWatcher(task)
+
+	task.watchMu.Lock()
+	defer task.watchMu.Unlock()
+	delete(task.watching, t)
+}
+
+// Link implements the `WatchableTask` interface.
+func (task *task) Link(other Task) error {
+	t, ok := watchable(other)
+	if !ok {
+		return ErrNotWatchable
+	}
+	if t == task {
+		return nil
+	}
+	t.watchMu.Lock()
+	if t.terminated {
+		reason := t.reason
+		t.watchMu.Unlock()
+		if abnormal(reason) {
+			task.kill(LinkError{Target: t, Reason: reason})
+		}
+		return nil
+	}
+	t.links[task] = struct{}{}
+	t.watchMu.Unlock()
+
+	task.watchMu.Lock()
+	terminated := task.terminated
+	if !terminated {
+		task.links[t] = struct{}{}
+	}
+	task.watchMu.Unlock()
+	if terminated {
+		// This task has already terminated, so it cannot share its fate.
+		t.removeLink(task)
+	}
+	return nil
+}
+
+// Unlink implements the `WatchableTask` interface.
+func (task *task) Unlink(other Task) {
+	t, ok := watchable(other)
+	if !ok {
+		return
+	}
+	t.removeLink(task)
+	task.removeLink(t)
+}
+
+// watchable returns the task that implements a `Task`, and false if it is not
+// a task returned by `New` (which are the only tasks that can be watched or
+// linked).
+func watchable(target Task) (*task, bool) {
+	t, ok := target.(*task)
+	return t, ok
+}
+
+// watched reports whether or not the panics of the task are contained, because
+// it is watched by, or linked to, another task, or because it is run by a group.
+func (task *task) watched() bool {
+	task.watchMu.Lock()
+	defer task.watchMu.Unlock()
+	return len(task.watchers) > 0 || len(task.links) > 0 || task.groups > 0
+}
+
+// joinGroup marks the task as being run by a group, which contains its panics
+// until it leaves the group.
+func (task *task) joinGroup() {
+	task.watchMu.Lock()
+	defer task.watchMu.Unlock()
+	task.groups++
+}
+
+// leaveGroup marks the task as no longer being run by a group.
+func (task *task) leaveGroup() {
+	task.watchMu.Lock()
+	defer task.watchMu.Unlock()
+	task.groups--
+}
+
+// start a run of the task, keeping the function that stops it so that it can
+// be killed by a linked task. The first run after the task has terminated
+// starts a new lifecycle. It returns the ID of the run, and false if the task
+// has already been killed (in which case the run must finish straight away).
+func (task *task) start(stop context.CancelFunc) (int, bool) {
+	task.watchMu.Lock()
+	defer task.watchMu.Unlock()
+	if task.terminated {
+		task.watchers, task.watching, task.links = newTaskSet(), newTaskSet(), newTaskSet()
+		task.killed = nil
+		task.terminated = false
+		task.reason = nil
+		task.done = make(chan struct{})
+	}
+	id := task.nextRun
+	task.nextRun++
+	task.runs[id] = stop
+	if len(task.runs) == 1 {
+		task.started = task.clock.Now()
+	}
+	return id, task.killed == nil
+}
+
+// finish a run of the task, and return the reason that it stopped. A run that
+// panicked kills the other runs. When the last run finishes, the task
+// terminates.
+func (task *task) finish(id int, panicked, ctxErr error) error {
+	task.watchMu.Lock()
+	delete(task.runs, id)
+	var stops []context.CancelFunc
+	if panicked != nil && task.killed == nil {
+		task.killed = panicked
+		for _, stop := range task.runs {
+			stops = append(stops, stop)
+		}
+	}
+	reason := task.killed
+	if panicked != nil {
+		reason = panicked
+	} else if reason == nil {
+		reason = ctxErr
+	}
+	last := len(task.runs) == 0
+	task.watchMu.Unlock()
+
+	for _, stop := range stops {
+		stop()
+	}
+	if last {
+		task.terminate(reason)
+	}
+	return reason
+}
+
+// newTaskSet returns an empty set of tasks.
+func newTaskSet() map[*task]struct{} {
+	return map[*task]struct{}{}
+}
+
+// kill the task, because a linked task terminated abnormally. If the task is
+// not yet running, it will terminate as soon as it starts.
+func (task *task) kill(reason error) {
+	task.watchMu.Lock()
+	if task.terminated || task.killed != nil {
+		task.watchMu.Unlock()
+		return
+	}
+	task.killed = reason
+	stops := make([]context.CancelFunc, 0, len(task.runs))
+	for _, stop := range task.runs {
+		stops = append(stops, stop)
+	}
+	task.watchMu.Unlock()
+	for _, stop := range stops {
+		stop()
+	}
+}
+
+// terminate the task, notifying its watchers, removing it from the tasks that
+// it watches, and killing its linked tasks if the reason is abnormal. It does
+// nothing if the task has already terminated.
+func (task *task) terminate(reason error) {
+	task.watchMu.Lock()
+	if task.terminated {
+		task.watchMu.Unlock()
+		return
+	}
+	task.terminated = true
+	task.reason = reason
+	watchers, watching, links := task.watchers, task.watching, task.links
+	task.watchers, task.watching, task.links = nil, nil, nil
+	close(task.done)
+	task.watchMu.Unlock()
+
+	for watcher := range watchers {
+		watcher.deliver(Terminated{Target: task, Reason: reason})
+	}
+	for target := range watching {
+		target.removeWatcher(task)
+	}
+	for link := range links {
+		link.removeLink(task)
+		if abnormal(reason) {
+			link.kill(LinkError{Target: task, Reason: reason})
+		}
+	}
+}
+
+// deliver a message to the task, retrying until it is accepted or until the
+// task terminates.
+func (task *task) deliver(m Message) {
+	if task.Send(m) {
+		return
+	}
+	task.watchMu.Lock()
+	done := task.done
+	task.watchMu.Unlock()
+	go Resend(task.clock, task, m, terminatedRetry, done)
+}
+
+func (task *task) removeWatcher(watcher *task) {
+	task.watchMu.Lock()
+	defer task.watchMu.Unlock()
+	delete(task.watchers, watcher)
+}
+
+func (task *task) removeLink(link *task) {
+	task.watchMu.Lock()
+	defer task.watchMu.Unlock()
+	delete(task.links, link)
+}
+
+// abnormal reports whether or not a task terminated for a reason other than
+// its context being done.
+func abnormal(reason error) bool {
+	return reason != nil && !errors.Is(reason, context.Canceled) && !errors.Is(reason, context.DeadlineExceeded)
+}
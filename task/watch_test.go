@@ -0,0 +1,291 @@
+package task_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/task"
+
+	"github.com/renproject/phi/co"
+)
+
+// newWatchable returns a new task as a `WatchableTask`.
+func newWatchable(handler Handler, opts Options) WatchableTask {
+	return New(handler, opts).(WatchableTask)
+}
+
+// foreignTask is a `Task` that was not returned by `New`.
+type foreignTask struct {
+	*counter
+}
+
+func (foreignTask) Run(context.Context) {}
+
+var _ = Describe("Watching", func() {
+
+	// start runs a task, and returns a function that stops it and a channel
+	// that is closed when it has stopped. A panic that escapes the task is
+	// written to the panics channel.
+	start := func(t Task, panics chan interface{}) (context.CancelFunc, chan struct{}) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer func() {
+				if r := recover(); r != nil {
+					panics <- r
+				}
+			}()
+			t.Run(ctx)
+		}()
+		return cancel, done
+	}
+
+	expectTerminated := func(r *recorder, target Task) error {
+		var m Message
+		Eventually(r.messages).Should(Receive(&m))
+		terminated, ok := m.(Terminated)
+		Expect(ok).To(BeTrue())
+		Expect(terminated.Target).To(Equal(target))
+		return terminated.Reason
+	}
+
+	Context("when a watched task stops", func() {
+
+		It("should notify the watcher", func() {
+			r := newRecorder(10)
+			watcher := newWatchable(r, Options{Cap: 10})
+			target := newWatchable(newRecorder(1), Options{Cap: 1})
+			Expect(watcher.Watch(target)).To(Succeed())
+
+			stopWatcher, _ := start(watcher, nil)
+			defer stopWatcher()
+			stopTarget, done := start(target, nil)
+			stopTarget()
+			Eventually(done).Should(BeClosed())
+			Expect(expectTerminated(r, target)).To(Equal(context.Canceled))
+		})
+
+		It("should notify watchers of a task that has already stopped", func() {
+			target := newWatchable(newRecorder(1), Options{Cap: 1})
+			stopTarget, done := start(target, nil)
+			stopTarget()
+			Eventually(done).Should(BeClosed())
+
+			r := newRecorder(10)
+			watcher := newWatchable(r, Options{Cap: 10})
+			Expect(watcher.Watch(target)).To(Succeed())
+			stopWatcher, _ := start(watcher, nil)
+			defer stopWatcher()
+			Expect(expectTerminated(r, target)).To(Equal(context.Canceled))
+		})
+
+		It("should retry when the buffer of the watcher is full", func() {
+			r := newRecorder(10)
+			watcher := newWatchable(r, Options{Cap: 1})
+			target := newWatchable(newRecorder(1), Options{Cap: 1})
+			Expect(watcher.Watch(target)).To(Succeed())
+			Expect(watcher.Send(testMessage{})).To(BeTrue())
+
+			stopTarget, done := start(target, nil)
+			stopTarget()
+			Eventually(done).Should(BeClosed())
+
+			stopWatcher, _ := start(watcher, nil)
+			defer stopWatcher()
+			Eventually(r.messages).Should(Receive(Equal(testMessage{})))
+			Expect(expectTerminated(r, target)).To(Equal(context.Canceled))
+		})
+
+		It("should not notify a watcher that has unwatched", func() {
+			r := newRecorder(10)
+			watcher := newWatchable(r, Options{Cap: 10})
+			target := newWatchable(newRecorder(1), Options{Cap: 1})
+			Expect(watcher.Watch(target)).To(Succeed())
+			watcher.Unwatch(target)
+
+			stopWatcher, _ := start(watcher, nil)
+			defer stopWatcher()
+			stopTarget, done := start(target, nil)
+			stopTarget()
+			Eventually(done).Should(BeClosed())
+			Consistently(r.messages).ShouldNot(Receive())
+		})
+	})
+
+	Context("when a watched task panics", func() {
+
+		for _, scale := range []int{1, 2} {
+			scale := scale
+
+			It(fmt.Sprintf("should contain the panic and notify the watcher when scale=%v", scale), func() {
+				r := newRecorder(10)
+				watcher := newWatchable(r, Options{Cap: 10})
+				target := newWatchable(crasher{}, Options{Cap: 1, Scale: scale})
+				Expect(watcher.Watch(target)).To(Succeed())
+
+				stopWatcher, _ := start(watcher, nil)
+				defer stopWatcher()
+				panics := make(chan interface{}, 1)
+				stopTarget, done := start(target, panics)
+				defer stopTarget()
+
+				Expect(target.Send(testMessage{})).To(BeTrue())
+				Eventually(done).Should(BeClosed())
+				Expect(panics).ToNot(Receive())
+				reason := expectTerminated(r, target)
+				Expect(reason).To(BeAssignableToTypeOf(co.PanicError{}))
+				Expect(reason.(co.PanicError).Value).To(Equal("crash"))
+			})
+		}
+
+		It("should not contain the panic once the watcher has stopped", func() {
+			watcher := newWatchable(newRecorder(10), Options{Cap: 10})
+			target := newWatchable(crasher{}, Options{Cap: 1})
+			Expect(watcher.Watch(target)).To(Succeed())
+
+			stopWatcher, watcherDone := start(watcher, nil)
+			stopWatcher()
+			Eventually(watcherDone).Should(BeClosed())
+
+			panics := make(chan interface{}, 1)
+			stopTarget, done := start(target, panics)
+			defer stopTarget()
+			Expect(target.Send(testMessage{})).To(BeTrue())
+			Eventually(done).Should(BeClosed())
+			Expect(panics).To(Receive(Equal("crash")))
+		})
+	})
+
+	Context("when tasks are linked", func() {
+
+		It("should terminate linked tasks when a task panics", func() {
+			r := newRecorder(10)
+			watcher := newWatchable(r, Options{Cap: 10})
+			a := newWatchable(crasher{}, Options{Cap: 1})
+			b := newWatchable(newRecorder(1), Options{Cap: 1})
+			Expect(a.Link(b)).To(Succeed())
+			Expect(watcher.Watch(b)).To(Succeed())
+
+			stopWatcher, _ := start(watcher, nil)
+			defer stopWatcher()
+			stopA, doneA := start(a, nil)
+			defer stopA()
+			stopB, doneB := start(b, nil)
+			defer stopB()
+
+			Expect(a.Send(testMessage{})).To(BeTrue())
+			Eventually(doneA).Should(BeClosed())
+			Eventually(doneB).Should(BeClosed())
+			reason := expectTerminated(r, b)
+			Expect(reason).To(BeAssignableToTypeOf(LinkError{}))
+			Expect(reason.(LinkError).Target).To(Equal(a))
+			var panicErr co.PanicError
+			Expect(errors.As(reason, &panicErr)).To(BeTrue())
+		})
+
+		It("should terminate linked tasks that have not started", func() {
+			a := newWatchable(crasher{}, Options{Cap: 1})
+			b := newWatchable(newRecorder(1), Options{Cap: 1})
+			Expect(b.Link(a)).To(Succeed())
+
+			stopA, doneA := start(a, nil)
+			defer stopA()
+			Expect(a.Send(testMessage{})).To(BeTrue())
+			Eventually(doneA).Should(BeClosed())
+
+			stopB, doneB := start(b, nil)
+			defer stopB()
+			Eventually(doneB).Should(BeClosed())
+		})
+
+		It("should not terminate linked tasks when a task stops normally", func() {
+			a := newWatchable(newRecorder(1), Options{Cap: 1})
+			b := newWatchable(newRecorder(1), Options{Cap: 1})
+			Expect(a.Link(b)).To(Succeed())
+
+			stopA, doneA := start(a, nil)
+			stopB, doneB := start(b, nil)
+			defer stopB()
+			stopA()
+			Eventually(doneA).Should(BeClosed())
+			Consistently(doneB).ShouldNot(BeClosed())
+		})
+
+		It("should not terminate tasks that have been unlinked", func() {
+			a := newWatchable(crasher{}, Options{Cap: 1})
+			b := newWatchable(newRecorder(1), Options{Cap: 1})
+			Expect(a.Link(b)).To(Succeed())
+			b.Unlink(a)
+
+			panics := make(chan interface{}, 1)
+			stopA, doneA := start(a, panics)
+			defer stopA()
+			stopB, doneB := start(b, nil)
+			defer stopB()
+			Expect(a.Send(testMessage{})).To(BeTrue())
+			Eventually(doneA).Should(BeClosed())
+			Expect(panics).To(Receive(Equal("crash")))
+			Consistently(doneB).ShouldNot(BeClosed())
+		})
+	})
+
+	Context("when a task is run more than once", func() {
+
+		It("should start afresh when it is run again after terminating", func() {
+			target := newWatchable(newRecorder(1), Options{Cap: 1})
+			stopTarget, done := start(target, nil)
+			stopTarget()
+			Eventually(done).Should(BeClosed())
+			Expect(target.(MonitoredTask).Status().Terminated).To(BeTrue())
+
+			stopTarget, done = start(target, nil)
+			Eventually(func() bool { return target.(MonitoredTask).Status().Running }).Should(BeTrue())
+			status := target.(MonitoredTask).Status()
+			Expect(status.Terminated).To(BeFalse())
+			Expect(status.Reason).To(BeNil())
+
+			// Watchers of the second run are notified when it stops.
+			r := newRecorder(10)
+			watcher := newWatchable(r, Options{Cap: 10})
+			Expect(watcher.Watch(target)).To(Succeed())
+			stopWatcher, _ := start(watcher, nil)
+			defer stopWatcher()
+			Consistently(r.messages, 10*time.Millisecond).ShouldNot(Receive())
+			stopTarget()
+			Eventually(done).Should(BeClosed())
+			Expect(expectTerminated(r, target)).To(Equal(context.Canceled))
+		})
+
+		It("should terminate when the last of its concurrent runs stops", func() {
+			target := newWatchable(newRecorder(1), Options{Cap: 1})
+			stopFirst, doneFirst := start(target, nil)
+			stopSecond, doneSecond := start(target, nil)
+			Eventually(func() bool { return target.(MonitoredTask).Status().Running }).Should(BeTrue())
+
+			stopFirst()
+			Eventually(doneFirst).Should(BeClosed())
+			Consistently(func() bool { return target.(MonitoredTask).Status().Running }, 10*time.Millisecond).Should(BeTrue())
+
+			stopSecond()
+			Eventually(doneSecond).Should(BeClosed())
+			Expect(target.(MonitoredTask).Status().Terminated).To(BeTrue())
+		})
+	})
+
+	Context("when a task was not returned by New", func() {
+
+		It("should not be watched or linked", func() {
+			t := newWatchable(newRecorder(1), Options{Cap: 1})
+			other := foreignTask{new(counter)}
+			Expect(t.Watch(other)).To(Equal(ErrNotWatchable))
+			Expect(t.Link(other)).To(Equal(ErrNotWatchable))
+			t.Unwatch(other)
+			t.Unlink(other)
+		})
+	})
+})
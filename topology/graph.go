@@ -0,0 +1,332 @@
+// Package topology generates network topologies, and provides a
+// `task.Handler` that routes messages between tasks along the edges of a
+// topology, with configurable latency, loss, and partitions. Together, they
+// can be used to simulate protocols that are built on phi (for example, the
+// handlers in package `consensus`) over networks of different shapes and
+// qualities.
+//
+// Random topologies are generated from a seed, so that simulations can be
+// reproduced.
+package topology
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// A Graph is a directed graph of nodes, which are numbered from zero. The
+// neighbours of each node are the nodes that it has edges to, in ascending
+// order. All of the generators in this package return undirected graphs (if a
+// node has an edge to another node, the other node has an edge back), without
+// self-loops.
+type Graph [][]uint
+
+// Len returns the number of nodes in the graph.
+func (g Graph) Len() int {
+	return len(g)
+}
+
+// Neighbours returns the nodes that a node has edges to.
+func (g Graph) Neighbours(node uint) []uint {
+	if node >= uint(len(g)) {
+		return nil
+	}
+	return g[node]
+}
+
+// HasEdge returns true if there is an edge from one node to another.
+func (g Graph) HasEdge(from, to uint) bool {
+	neighbours := g.Neighbours(from)
+	i := sort.Search(len(neighbours), func(i int) bool { return neighbours[i] >= to })
+	return i < len(neighbours) && neighbours[i] == to
+}
+
+// Edges returns the number of (directed) edges in the graph. An undirected
+// edge counts as two edges.
+func (g Graph) Edges() int {
+	edges := 0
+	for _, neighbours := range g {
+		edges += len(neighbours)
+	}
+	return edges
+}
+
+// Distances returns the number of edges on the shortest path from a node to
+// every node, or -1 for nodes that cannot be reached.
+func (g Graph) Distances(from uint) []int {
+	dists := make([]int, len(g))
+	for i := range dists {
+		dists[i] = -1
+	}
+	if from >= uint(len(g)) {
+		return dists
+	}
+	dists[from] = 0
+	queue := []uint{from}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, neighbour := range g[node] {
+			if dists[neighbour] < 0 {
+				dists[neighbour] = dists[node] + 1
+				queue = append(queue, neighbour)
+			}
+		}
+	}
+	return dists
+}
+
+// Connected returns true if every node can be reached from every other node.
+func (g Graph) Connected() bool {
+	for from := range g {
+		for _, dist := range g.Distances(uint(from)) {
+			if dist < 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Diameter returns the longest shortest path between two nodes, or -1 if the
+// graph is not connected.
+func (g Graph) Diameter() int {
+	diameter := 0
+	for from := range g {
+		for _, dist := range g.Distances(uint(from)) {
+			if dist < 0 {
+				return -1
+			}
+			if dist > diameter {
+				diameter = dist
+			}
+		}
+	}
+	return diameter
+}
+
+// Ring returns a graph where each node is connected to the nodes before and
+// after it, and the last node is connected to the first.
+func Ring(n int) Graph {
+	check(n >= 0, "ring of %v nodes", n)
+	b := newBuilder(n)
+	for i := 0; i < n; i++ {
+		b.add(uint(i), uint((i+1)%n))
+	}
+	return b.graph()
+}
+
+// Grid returns a graph where the nodes are laid out in rows and connected to
+// the nodes above, below, left, and right of them. Node `r*cols + c` is in row
+// `r` and column `c`.
+func Grid(rows, cols int) Graph {
+	return grid(rows, cols, false)
+}
+
+// Torus returns a grid where the nodes on the edges of the grid are also
+// connected to the nodes on the opposite edges.
+func Torus(rows, cols int) Graph {
+	return grid(rows, cols, true)
+}
+
+func grid(rows, cols int, wrap bool) Graph {
+	check(rows >= 0 && cols >= 0, "grid of %vx%v nodes", rows, cols)
+	b := newBuilder(rows * cols)
+	node := func(r, c int) uint { return uint(r*cols + c) }
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			if c+1 < cols {
+				b.add(node(r, c), node(r, c+1))
+			} else if wrap {
+				b.add(node(r, c), node(r, 0))
+			}
+			if r+1 < rows {
+				b.add(node(r, c), node(r+1, c))
+			} else if wrap {
+				b.add(node(r, c), node(0, c))
+			}
+		}
+	}
+	return b.graph()
+}
+
+// Complete returns a graph where every node is connected to every other node.
+func Complete(n int) Graph {
+	check(n >= 0, "complete graph of %v nodes", n)
+	b := newBuilder(n)
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			b.add(uint(i), uint(j))
+		}
+	}
+	return b.graph()
+}
+
+// RandomRegular returns a random graph where every node is connected to `d`
+// other nodes. It panics unless `d` is less than `n`, and `n*d` is even.
+func RandomRegular(n, d int, seed int64) Graph {
+	check(n >= 0 && d >= 0 && d < n || n == 0 && d == 0, "%v-regular graph of %v nodes", d, n)
+	check(n*d%2 == 0, "%v-regular graph of %v nodes", d, n)
+	r := rand.New(rand.NewSource(seed))
+
+	// Pair up d copies of every node at random, and start again if the only
+	// pairs that are left would create self-loops or duplicate edges.
+	for {
+		b := newBuilder(n)
+		points := make([]uint, 0, n*d)
+		for i := 0; i < n; i++ {
+			for j := 0; j < d; j++ {
+				points = append(points, uint(i))
+			}
+		}
+		for len(points) > 0 {
+			paired := false
+			for attempt := 0; attempt < 10*len(points); attempt++ {
+				i, j := r.Intn(len(points)), r.Intn(len(points))
+				if points[i] == points[j] || b.has(points[i], points[j]) {
+					continue
+				}
+				b.add(points[i], points[j])
+				if i < j {
+					i, j = j, i
+				}
+				points[i] = points[len(points)-1]
+				points[j] = points[len(points)-2]
+				points = points[:len(points)-2]
+				paired = true
+				break
+			}
+			if !paired {
+				break
+			}
+		}
+		if len(points) == 0 {
+			return b.graph()
+		}
+	}
+}
+
+// SmallWorld returns a Watts-Strogatz small-world graph. Each node starts
+// connected to the `k/2` nodes on either side of it in a ring, and then each
+// of these edges is rewired to a random node with probability `beta`. A `beta`
+// of zero results in a ring lattice, and a `beta` of one results in a random
+// graph; in between, the graph has short paths between nodes (like a random
+// graph) but remains clustered (like a lattice). It panics unless `k` is even
+// and less than `n`.
+func SmallWorld(n, k int, beta float64, seed int64) Graph {
+	check(n >= 0 && k >= 0 && k%2 == 0 && (k < n || n == 0 && k == 0), "small-world graph of %v nodes with degree %v", n, k)
+	r := rand.New(rand.NewSource(seed))
+	b := newBuilder(n)
+	for i := 0; i < n; i++ {
+		for j := 1; j <= k/2; j++ {
+			b.add(uint(i), uint((i+j)%n))
+		}
+	}
+	for j := 1; j <= k/2; j++ {
+		for i := 0; i < n; i++ {
+			if r.Float64() >= beta {
+				continue
+			}
+			from, to := uint(i), uint((i+j)%n)
+			if !b.has(from, to) || len(b.adj[from]) >= n-1 {
+				continue
+			}
+			target := uint(r.Intn(n))
+			for target == from || b.has(from, target) {
+				target = uint(r.Intn(n))
+			}
+			b.remove(from, to)
+			b.add(from, target)
+		}
+	}
+	return b.graph()
+}
+
+// ScaleFree returns a Barabási-Albert scale-free graph. It starts with a
+// complete graph of `m+1` nodes, and then adds the other nodes one at a time,
+// connecting each one to `m` existing nodes that are chosen with probability
+// proportional to their degree. The result is a graph with a few highly
+// connected hubs, and many nodes with few connections. It panics unless `m` is
+// positive and less than `n`.
+func ScaleFree(n, m int, seed int64) Graph {
+	check(m > 0 && m < n, "scale-free graph of %v nodes with %v edges per node", n, m)
+	r := rand.New(rand.NewSource(seed))
+	b := newBuilder(n)
+
+	// Every node appears in the list once per edge, so choosing from the
+	// list at random chooses nodes in proportion to their degree.
+	var ends []uint
+	for i := 0; i <= m; i++ {
+		for j := i + 1; j <= m; j++ {
+			b.add(uint(i), uint(j))
+			ends = append(ends, uint(i), uint(j))
+		}
+	}
+	for i := m + 1; i < n; i++ {
+		node := uint(i)
+		targets := make([]uint, 0, m)
+		for len(targets) < m {
+			target := ends[r.Intn(len(ends))]
+			if b.has(node, target) {
+				continue
+			}
+			b.add(node, target)
+			targets = append(targets, target)
+		}
+		for _, target := range targets {
+			ends = append(ends, node, target)
+		}
+	}
+	return b.graph()
+}
+
+// builder builds undirected graphs.
+type builder struct {
+	adj []map[uint]struct{}
+}
+
+func newBuilder(n int) *builder {
+	adj := make([]map[uint]struct{}, n)
+	for i := range adj {
+		adj[i] = map[uint]struct{}{}
+	}
+	return &builder{adj: adj}
+}
+
+// add an edge between two nodes, unless they are the same node.
+func (b *builder) add(a, c uint) {
+	if a == c {
+		return
+	}
+	b.adj[a][c] = struct{}{}
+	b.adj[c][a] = struct{}{}
+}
+
+func (b *builder) remove(a, c uint) {
+	delete(b.adj[a], c)
+	delete(b.adj[c], a)
+}
+
+func (b *builder) has(a, c uint) bool {
+	_, ok := b.adj[a][c]
+	return ok
+}
+
+func (b *builder) graph() Graph {
+	g := make(Graph, len(b.adj))
+	for i, set := range b.adj {
+		g[i] = make([]uint, 0, len(set))
+		for neighbour := range set {
+			g[i] = append(g[i], neighbour)
+		}
+		sort.Slice(g[i], func(j, k int) bool { return g[i][j] < g[i][k] })
+	}
+	return g
+}
+
+func check(ok bool, format string, args ...interface{}) {
+	if !ok {
+		panic(fmt.Sprintf("topology error: invalid "+format, args...))
+	}
+}
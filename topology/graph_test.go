@@ -0,0 +1,120 @@
+package topology_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/topology"
+)
+
+// undirected checks that every edge has an edge back, and that there are no
+// self-loops.
+func undirected(g Graph) {
+	for from := range g {
+		for _, to := range g.Neighbours(uint(from)) {
+			Expect(to).ToNot(Equal(uint(from)))
+			Expect(g.HasEdge(to, uint(from))).To(BeTrue())
+		}
+	}
+}
+
+// degrees returns the degree of every node.
+func degrees(g Graph) []int {
+	degrees := make([]int, g.Len())
+	for i := range degrees {
+		degrees[i] = len(g.Neighbours(uint(i)))
+	}
+	return degrees
+}
+
+var _ = Describe("Graphs", func() {
+
+	Context("when generating regular topologies", func() {
+		It("should generate rings", func() {
+			g := Ring(5)
+			Expect(g).To(Equal(Graph{{1, 4}, {0, 2}, {1, 3}, {2, 4}, {0, 3}}))
+			Expect(g.Diameter()).To(Equal(2))
+			Expect(Ring(2)).To(Equal(Graph{{1}, {0}}))
+			Expect(Ring(1)).To(Equal(Graph{{}}))
+		})
+
+		It("should generate grids and tori", func() {
+			g := Grid(2, 3)
+			Expect(g).To(Equal(Graph{{1, 3}, {0, 2, 4}, {1, 5}, {0, 4}, {1, 3, 5}, {2, 4}}))
+			Expect(g.Diameter()).To(Equal(3))
+
+			g = Torus(4, 4)
+			undirected(g)
+			for _, degree := range degrees(g) {
+				Expect(degree).To(Equal(4))
+			}
+			Expect(g.Diameter()).To(Equal(4))
+		})
+
+		It("should generate complete graphs", func() {
+			g := Complete(4)
+			Expect(g.Edges()).To(Equal(12))
+			Expect(g.Diameter()).To(Equal(1))
+		})
+	})
+
+	Context("when generating random topologies", func() {
+		It("should generate regular graphs", func() {
+			g := RandomRegular(50, 3, 1)
+			undirected(g)
+			for _, degree := range degrees(g) {
+				Expect(degree).To(Equal(3))
+			}
+			Expect(RandomRegular(50, 3, 1)).To(Equal(g))
+			Expect(RandomRegular(50, 3, 2)).ToNot(Equal(g))
+			Expect(RandomRegular(5, 4, 1)).To(Equal(Complete(5)))
+		})
+
+		It("should generate small-world graphs", func() {
+			Expect(SmallWorld(10, 2, 0, 1)).To(Equal(Ring(10)))
+
+			lattice := SmallWorld(100, 4, 0, 1)
+			g := SmallWorld(100, 4, 0.2, 1)
+			undirected(g)
+			Expect(g.Edges()).To(Equal(lattice.Edges()))
+			Expect(g).ToNot(Equal(lattice))
+			Expect(g.Diameter()).To(BeNumerically("<", lattice.Diameter()))
+			Expect(SmallWorld(100, 4, 0.2, 1)).To(Equal(g))
+		})
+
+		It("should generate scale-free graphs", func() {
+			g := ScaleFree(200, 2, 1)
+			undirected(g)
+			Expect(g.Connected()).To(BeTrue())
+			Expect(g.Edges()).To(Equal(2 * (3 + 2*197)))
+			max := 0
+			for _, degree := range degrees(g) {
+				Expect(degree).To(BeNumerically(">=", 2))
+				if degree > max {
+					max = degree
+				}
+			}
+			Expect(max).To(BeNumerically(">", 10))
+			Expect(ScaleFree(200, 2, 1)).To(Equal(g))
+		})
+
+		It("should panic for invalid parameters", func() {
+			Expect(func() { RandomRegular(5, 3, 1) }).To(Panic())
+			Expect(func() { RandomRegular(3, 3, 1) }).To(Panic())
+			Expect(func() { SmallWorld(10, 3, 0.1, 1) }).To(Panic())
+			Expect(func() { ScaleFree(2, 2, 1) }).To(Panic())
+			Expect(func() { Ring(-1) }).To(Panic())
+		})
+	})
+
+	Context("when measuring graphs", func() {
+		It("should find distances and disconnected nodes", func() {
+			g := Graph{{1}, {0}, {}}
+			Expect(g.Distances(0)).To(Equal([]int{0, 1, -1}))
+			Expect(g.Distances(3)).To(Equal([]int{-1, -1, -1}))
+			Expect(g.Connected()).To(BeFalse())
+			Expect(g.Diameter()).To(Equal(-1))
+			Expect(g.HasEdge(2, 0)).To(BeFalse())
+			Expect(g.Neighbours(3)).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,289 @@
+package topology
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/renproject/phi/task"
+)
+
+var (
+	// ErrNoLink is the error of a `Dropped` message when there is no edge
+	// between the nodes.
+	ErrNoLink = errors.New("no link")
+
+	// ErrLost is the error of a `Dropped` message when the link lost the
+	// message.
+	ErrLost = errors.New("lost")
+
+	// ErrPartitioned is the error of a `Dropped` message when the nodes are in
+	// different partitions.
+	ErrPartitioned = errors.New("partitioned")
+
+	// ErrRejected is the error of a `Dropped` message when the node that the
+	// message was sent to rejected it (for example, because its buffer was
+	// full).
+	ErrRejected = errors.New("rejected")
+)
+
+// Link is the quality of the link along an edge. Messages are delayed by the
+// `Latency`, plus a random duration less than the `Jitter` (so messages with
+// jitter can arrive out of order), and are lost with probability `Loss`.
+type Link struct {
+	Latency time.Duration
+	Jitter  time.Duration
+	Loss    float64
+}
+
+// Unicast is the message that makes a `Router` send a message from a node to
+// one of its neighbours.
+type Unicast struct {
+	From, To uint
+	Message  task.Message
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Unicast) IsMessage() {}
+
+// Multicast is the message that makes a `Router` send a message from a node to
+// all of its neighbours.
+type Multicast struct {
+	From    uint
+	Message task.Message
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Multicast) IsMessage() {}
+
+// SetLink is the message that changes the quality of the link from one node to
+// another. Links are directed, so the link in the other direction is not
+// changed.
+type SetLink struct {
+	From, To uint
+	Link     Link
+}
+
+// IsMessage implements the `task.Message` interface.
+func (SetLink) IsMessage() {}
+
+// Partition is the message that partitions the network into groups of nodes.
+// Nodes can only send messages to nodes in the same group. Nodes that are not
+// in any of the groups form a group of their own. Partitioning the network
+// replaces any previous partition.
+type Partition struct {
+	Groups [][]uint
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Partition) IsMessage() {}
+
+// Heal is the message that removes the partition of the network.
+type Heal struct{}
+
+// IsMessage implements the `task.Message` interface.
+func (Heal) IsMessage() {}
+
+// Dropped is the message sent to the `Events` sender of a `Router` when a
+// message is not delivered.
+type Dropped struct {
+	From, To uint
+	Message  task.Message
+	Err      error
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Dropped) IsMessage() {}
+
+// Unexpected is the message sent to the `Events` sender of a `Router` when it
+// is sent a message that is not one of the messages in this package. The
+// message is otherwise ignored.
+type Unexpected struct {
+	Message task.Message
+}
+
+// IsMessage implements the `task.Message` interface.
+func (Unexpected) IsMessage() {}
+
+// RouterOptions are passed when constructing a `Router`. The `Link` is the
+// quality of every link that has not been changed by a `SetLink` message.
+// Random choices are made using a source seeded with `Seed`. If `Clock` is
+// nil, the `task.SystemClock` is used. Dropped and unexpected messages are
+// sent to `Events`, if it is not nil; because delayed messages are delivered
+// in their own goroutines, it must be safe for concurrent use.
+type RouterOptions struct {
+	Link   Link
+	Seed   int64
+	Clock  task.Clock
+	Events task.Sender
+}
+
+// A Router is a `task.Handler` that sends messages between nodes along the
+// edges of a graph. Node `i` of the graph is the `i`th sender that the router
+// is constructed with, and messages are delivered to nodes unchanged, so
+// messages that need to identify their sender must do so themselves.
+//
+// Messages without latency are delivered while they are being handled.
+// Messages with latency are delivered in their own goroutine once the latency
+// has elapsed; whether they are lost or partitioned is decided when they are
+// sent, so messages that are in flight when the network is partitioned are
+// still delivered. Running the router (see `Router.Run`) alongside its task
+// stops the delivery of delayed messages when the router is shut down.
+type Router struct {
+	graph Graph
+	nodes []task.Sender
+	opts  RouterOptions
+
+	mu     *sync.Mutex
+	rand   *rand.Rand
+	links  map[[2]uint]Link
+	groups map[uint]int
+
+	// The goroutines that deliver delayed messages are tracked so that they
+	// can be stopped, and waited for, when the router stops.
+	inFlight *sync.WaitGroup
+	stopped  bool
+	done     chan struct{}
+}
+
+// NewRouter returns a `Router` that sends messages to the nodes along the edges
+// of the graph. It panics if the number of nodes is different from the number
+// of nodes in the graph.
+func NewRouter(graph Graph, nodes []task.Sender, opts RouterOptions) *Router {
+	if len(nodes) != graph.Len() {
+		panic(fmt.Sprintf("topology error: expected %v nodes got %v", graph.Len(), len(nodes)))
+	}
+	if opts.Clock == nil {
+		opts.Clock = task.SystemClock
+	}
+	return &Router{
+		graph:  graph,
+		nodes:  nodes,
+		opts:   opts,
+		mu:     new(sync.Mutex),
+		rand:   rand.New(rand.NewSource(opts.Seed)),
+		links:  map[[2]uint]Link{},
+		groups: map[uint]int{},
+
+		inFlight: new(sync.WaitGroup),
+		done:     make(chan struct{}),
+	}
+}
+
+// Run implements the `task.Runner` interface. It blocks until the context is
+// done, and then stops the router: delayed messages that have not been
+// delivered are discarded (without being reported to `Events`), and it waits
+// for the goroutines delivering them to exit. Messages that are delayed after
+// the router has stopped are also discarded.
+func (router *Router) Run(ctx context.Context) {
+	<-ctx.Done()
+
+	router.mu.Lock()
+	if !router.stopped {
+		router.stopped = true
+		close(router.done)
+	}
+	router.mu.Unlock()
+	router.inFlight.Wait()
+}
+
+// Handle implements the `task.Handler` interface. Messages that are not one of
+// the messages in this package are ignored, and reported as `Unexpected` to the
+// `Events` sender.
+func (router *Router) Handle(_ task.Task, m task.Message) {
+	switch m := m.(type) {
+	case Unicast:
+		router.send(m.From, m.To, m.Message)
+	case Multicast:
+		for _, to := range router.graph.Neighbours(m.From) {
+			router.send(m.From, to, m.Message)
+		}
+	case SetLink:
+		router.mu.Lock()
+		router.links[[2]uint{m.From, m.To}] = m.Link
+		router.mu.Unlock()
+	case Partition:
+		router.mu.Lock()
+		router.groups = map[uint]int{}
+		for i, group := range m.Groups {
+			for _, node := range group {
+				router.groups[node] = i + 1
+			}
+		}
+		router.mu.Unlock()
+	case Heal:
+		router.mu.Lock()
+		router.groups = map[uint]int{}
+		router.mu.Unlock()
+	default:
+		router.event(Unexpected{Message: m})
+	}
+}
+
+func (router *Router) send(from, to uint, m task.Message) {
+	if !router.graph.HasEdge(from, to) || to >= uint(len(router.nodes)) {
+		router.drop(from, to, m, ErrNoLink)
+		return
+	}
+
+	router.mu.Lock()
+	link, ok := router.links[[2]uint{from, to}]
+	if !ok {
+		link = router.opts.Link
+	}
+	partitioned := router.groups[from] != router.groups[to]
+	lost := link.Loss > 0 && router.rand.Float64() < link.Loss
+	delay := link.Latency
+	if link.Jitter > 0 {
+		delay += time.Duration(router.rand.Int63n(int64(link.Jitter)))
+	}
+	stopped := router.stopped
+	if delay > 0 && !partitioned && !lost && !stopped {
+		router.inFlight.Add(1)
+	}
+	router.mu.Unlock()
+
+	switch {
+	case partitioned:
+		router.drop(from, to, m, ErrPartitioned)
+	case lost:
+		router.drop(from, to, m, ErrLost)
+	case delay <= 0:
+		router.deliver(from, to, m)
+	case stopped:
+		// The router has stopped, so the message is discarded.
+	default:
+		go func() {
+			defer router.inFlight.Done()
+			select {
+			case <-router.opts.Clock.After(delay):
+			case <-router.done:
+				return
+			}
+			select {
+			case <-router.done:
+			default:
+				router.deliver(from, to, m)
+			}
+		}()
+	}
+}
+
+func (router *Router) deliver(from, to uint, m task.Message) {
+	if !router.nodes[to].Send(m) {
+		router.drop(from, to, m, ErrRejected)
+	}
+}
+
+func (router *Router) drop(from, to uint, m task.Message, err error) {
+	router.event(Dropped{From: from, To: to, Message: m, Err: err})
+}
+
+func (router *Router) event(m task.Message) {
+	if router.opts.Events != nil {
+		router.opts.Events.Send(m)
+	}
+}
@@ -0,0 +1,248 @@
+package topology_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/renproject/phi/topology"
+
+	"github.com/renproject/phi/task"
+)
+
+// inbox is a `task.Sender` that records the messages that it receives, unless
+// it is full.
+type inbox struct {
+	mu       *sync.Mutex
+	messages []task.Message
+	cap      int
+}
+
+func newInbox(cap int) *inbox {
+	return &inbox{mu: new(sync.Mutex), cap: cap}
+}
+
+func (in *inbox) Send(m task.Message) bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.cap > 0 && len(in.messages) >= in.cap {
+		return false
+	}
+	in.messages = append(in.messages, m)
+	return true
+}
+
+func (in *inbox) get() []task.Message {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return append([]task.Message{}, in.messages...)
+}
+
+type msg int
+
+func (msg) IsMessage() {}
+
+// fakeClock is a `task.Clock` that only advances when it is told to.
+type fakeClock struct {
+	mu      *sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+func (c *fakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+var _ = Describe("Routers", func() {
+
+	var inboxes []*inbox
+	var events *inbox
+	var clock *fakeClock
+
+	// setup a router for a line of three nodes.
+	setup := func(opts RouterOptions) *Router {
+		inboxes = []*inbox{newInbox(0), newInbox(0), newInbox(1)}
+		events = newInbox(0)
+		clock = &fakeClock{mu: new(sync.Mutex)}
+		opts.Events = events
+		if opts.Clock == nil {
+			opts.Clock = clock
+		}
+		return NewRouter(Graph{{1}, {0, 2}, {1}}, []task.Sender{inboxes[0], inboxes[1], inboxes[2]}, opts)
+	}
+
+	Context("when links are perfect", func() {
+		It("should deliver messages along edges", func() {
+			router := setup(RouterOptions{})
+			router.Handle(nil, Unicast{From: 0, To: 1, Message: msg(1)})
+			router.Handle(nil, Multicast{From: 1, Message: msg(2)})
+			Expect(inboxes[0].get()).To(Equal([]task.Message{msg(2)}))
+			Expect(inboxes[1].get()).To(Equal([]task.Message{msg(1)}))
+			Expect(inboxes[2].get()).To(Equal([]task.Message{msg(2)}))
+			Expect(events.get()).To(BeEmpty())
+		})
+
+		It("should drop messages without an edge, or that are rejected", func() {
+			router := setup(RouterOptions{})
+			router.Handle(nil, Unicast{From: 0, To: 2, Message: msg(1)})
+			router.Handle(nil, Unicast{From: 1, To: 2, Message: msg(2)})
+			router.Handle(nil, Unicast{From: 1, To: 2, Message: msg(3)})
+			Expect(inboxes[2].get()).To(Equal([]task.Message{msg(2)}))
+			Expect(events.get()).To(Equal([]task.Message{
+				Dropped{From: 0, To: 2, Message: msg(1), Err: ErrNoLink},
+				Dropped{From: 1, To: 2, Message: msg(3), Err: ErrRejected},
+			}))
+		})
+
+		It("should ignore and report unexpected messages", func() {
+			router := setup(RouterOptions{})
+			router.Handle(nil, msg(1))
+			for _, in := range inboxes {
+				Expect(in.get()).To(BeEmpty())
+			}
+			Expect(events.get()).To(Equal([]task.Message{Unexpected{Message: msg(1)}}))
+		})
+	})
+
+	Context("when links are lossy", func() {
+		It("should lose messages at random", func() {
+			router := setup(RouterOptions{Link: Link{Loss: 0.5}, Seed: 1})
+			for i := 0; i < 100; i++ {
+				router.Handle(nil, Unicast{From: 1, To: 0, Message: msg(i)})
+			}
+			delivered := len(inboxes[0].get())
+			Expect(delivered).To(BeNumerically("~", 50, 20))
+			Expect(events.get()).To(HaveLen(100 - delivered))
+			Expect(events.get()[0].(Dropped).Err).To(Equal(ErrLost))
+		})
+
+		It("should change the quality of individual links", func() {
+			router := setup(RouterOptions{})
+			router.Handle(nil, SetLink{From: 1, To: 0, Link: Link{Loss: 1}})
+			router.Handle(nil, Multicast{From: 1, Message: msg(1)})
+			router.Handle(nil, Unicast{From: 0, To: 1, Message: msg(2)})
+			Expect(inboxes[0].get()).To(BeEmpty())
+			Expect(inboxes[1].get()).To(Equal([]task.Message{msg(2)}))
+			Expect(inboxes[2].get()).To(Equal([]task.Message{msg(1)}))
+		})
+	})
+
+	Context("when links have latency", func() {
+		It("should deliver messages once the latency has elapsed", func() {
+			router := setup(RouterOptions{Link: Link{Latency: time.Second, Jitter: time.Second}})
+			router.Handle(nil, Unicast{From: 0, To: 1, Message: msg(1)})
+			Eventually(clock.Waiters).Should(Equal(1))
+			clock.Advance(time.Second - time.Nanosecond)
+			Consistently(inboxes[1].get, 10*time.Millisecond).Should(BeEmpty())
+			clock.Advance(time.Second)
+			Eventually(inboxes[1].get).Should(Equal([]task.Message{msg(1)}))
+		})
+
+		It("should discard delayed messages once the router has stopped", func() {
+			router := setup(RouterOptions{Link: Link{Latency: time.Second}})
+			ctx, cancel := context.WithCancel(context.Background())
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				router.Run(ctx)
+			}()
+
+			// Node 2 rejects the second message, so it would be reported as
+			// dropped if it were delivered.
+			router.Handle(nil, Unicast{From: 1, To: 2, Message: msg(1)})
+			router.Handle(nil, Unicast{From: 1, To: 2, Message: msg(2)})
+			Eventually(clock.Waiters).Should(Equal(2))
+			cancel()
+			Eventually(stopped).Should(BeClosed())
+
+			router.Handle(nil, Unicast{From: 0, To: 1, Message: msg(3)})
+			clock.Advance(time.Second)
+			Consistently(func() int { return len(inboxes[1].get()) + len(inboxes[2].get()) + len(events.get()) }, 10*time.Millisecond).Should(Equal(0))
+		})
+	})
+
+	Context("when the network is partitioned", func() {
+		It("should only deliver messages within partitions", func() {
+			router := setup(RouterOptions{})
+			router.Handle(nil, Partition{Groups: [][]uint{{0, 1}}})
+			router.Handle(nil, Multicast{From: 1, Message: msg(1)})
+			Expect(inboxes[0].get()).To(Equal([]task.Message{msg(1)}))
+			Expect(inboxes[2].get()).To(BeEmpty())
+			Expect(events.get()).To(Equal([]task.Message{
+				Dropped{From: 1, To: 2, Message: msg(1), Err: ErrPartitioned},
+			}))
+
+			router.Handle(nil, Heal{})
+			router.Handle(nil, Unicast{From: 1, To: 2, Message: msg(2)})
+			Expect(inboxes[2].get()).To(Equal([]task.Message{msg(2)}))
+		})
+	})
+
+	Context("when running in a task", func() {
+		It("should route messages between tasks", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			g := SmallWorld(20, 4, 0.1, 1)
+			nodes := make([]task.Sender, g.Len())
+			recorders := make([]*inbox, g.Len())
+			for i := range nodes {
+				recorders[i] = newInbox(0)
+				nodes[i] = recorders[i]
+			}
+			router := task.New(NewRouter(g, nodes, RouterOptions{Link: Link{Latency: time.Millisecond}}), task.Options{Cap: 20})
+			go router.Run(ctx)
+
+			for i := 0; i < g.Len(); i++ {
+				Expect(router.Send(Multicast{From: uint(i), Message: msg(i)})).To(BeTrue())
+			}
+			for i := range recorders {
+				Eventually(func() int { return len(recorders[i].get()) }).Should(Equal(len(g.Neighbours(uint(i)))))
+			}
+		})
+	})
+
+	Context("when constructed with the wrong number of nodes", func() {
+		It("should panic", func() {
+			Expect(func() { NewRouter(Ring(3), nil, RouterOptions{}) }).To(Panic())
+		})
+	})
+})